@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -49,6 +50,76 @@ var (
 		flagKey:      "playlist-limit",
 		defaultValue: 25,
 	}
+	historyLimit = configVar[int]{
+		envKey:       "SERVER_HISTORY_LIMIT",
+		flagKey:      "history-limit",
+		defaultValue: 50,
+	}
+	writeQueueSize = configVar[int]{
+		envKey:       "SERVER_WRITE_QUEUE_SIZE",
+		flagKey:      "write-queue-size",
+		defaultValue: 64,
+	}
+	writeTimeout = configVar[time.Duration]{
+		envKey:       "SERVER_WRITE_TIMEOUT",
+		flagKey:      "write-timeout",
+		defaultValue: 10 * time.Second,
+	}
+	pingInterval = configVar[time.Duration]{
+		envKey:       "SERVER_PING_INTERVAL",
+		flagKey:      "ping-interval",
+		defaultValue: 25 * time.Second,
+	}
+	pongWait = configVar[time.Duration]{
+		envKey:       "SERVER_PONG_WAIT",
+		flagKey:      "pong-wait",
+		defaultValue: 60 * time.Second,
+	}
+	compression = configVar[bool]{
+		envKey:       "SERVER_COMPRESSION",
+		flagKey:      "compression",
+		defaultValue: false,
+	}
+	compressionLevel = configVar[int]{
+		envKey:       "SERVER_COMPRESSION_LEVEL",
+		flagKey:      "compression-level",
+		defaultValue: 1,
+	}
+	compressionThreshold = configVar[int]{
+		envKey:       "SERVER_COMPRESSION_THRESHOLD",
+		flagKey:      "compression-threshold",
+		defaultValue: 512,
+	}
+	reconnectGracePeriod = configVar[time.Duration]{
+		envKey:       "SERVER_RECONNECT_GRACE_PERIOD",
+		flagKey:      "reconnect-grace-period",
+		defaultValue: 15 * time.Second,
+	}
+	driftTolerance = configVar[time.Duration]{
+		envKey:       "SERVER_DRIFT_TOLERANCE",
+		flagKey:      "drift-tolerance",
+		defaultValue: 500 * time.Millisecond,
+	}
+	roomExp = configVar[time.Duration]{
+		envKey:       "SERVER_ROOM_EXP",
+		flagKey:      "room-exp",
+		defaultValue: 5 * time.Minute,
+	}
+	persistentRoomExp = configVar[time.Duration]{
+		envKey:       "SERVER_PERSISTENT_ROOM_EXP",
+		flagKey:      "persistent-room-exp",
+		defaultValue: 0,
+	}
+	videoDataCacheTtl = configVar[time.Duration]{
+		envKey:       "SERVER_VIDEO_DATA_CACHE_TTL",
+		flagKey:      "video-data-cache-ttl",
+		defaultValue: 24 * time.Hour,
+	}
+	videoDataCacheNegativeTtl = configVar[time.Duration]{
+		envKey:       "SERVER_VIDEO_DATA_CACHE_NEGATIVE_TTL",
+		flagKey:      "video-data-cache-negative-ttl",
+		defaultValue: 10 * time.Minute,
+	}
 	redisPort = configVar[int]{
 		envKey:       "REDIS_PORT",
 		flagKey:      "redis-port",
@@ -74,6 +145,20 @@ func loadAppConfig() *app.AppConfig {
 	pflag.String(logLevel.flagKey, logLevel.defaultValue, "Logging level")
 	pflag.Int(membersLimit.flagKey, membersLimit.defaultValue, "Maximum number of members in the room")
 	pflag.Int(playlistLimit.flagKey, playlistLimit.defaultValue, "Maximum number of videos in the playlist")
+	pflag.Int(historyLimit.flagKey, historyLimit.defaultValue, "Maximum number of played videos kept in room history")
+	pflag.Int(writeQueueSize.flagKey, writeQueueSize.defaultValue, "Maximum number of outbound messages queued per connection")
+	pflag.Duration(writeTimeout.flagKey, writeTimeout.defaultValue, "Websocket write timeout")
+	pflag.Duration(pingInterval.flagKey, pingInterval.defaultValue, "Interval between websocket pings sent by server")
+	pflag.Duration(pongWait.flagKey, pongWait.defaultValue, "Time to wait for any message or pong before connection is considered dead")
+	pflag.Bool(compression.flagKey, compression.defaultValue, "Enable permessage-deflate compression for clients supporting it")
+	pflag.Int(compressionLevel.flagKey, compressionLevel.defaultValue, "Deflate compression level, from -2 to 9")
+	pflag.Int(compressionThreshold.flagKey, compressionThreshold.defaultValue, "Minimum size in bytes of message to be compressed")
+	pflag.Duration(reconnectGracePeriod.flagKey, reconnectGracePeriod.defaultValue, "Time during which disconnected member keeps its slot, 0 disables it")
+	pflag.Duration(driftTolerance.flagKey, driftTolerance.defaultValue, "Allowed difference between member and expected player position")
+	pflag.Duration(roomExp.flagKey, roomExp.defaultValue, "Time empty room is kept before it is deleted")
+	pflag.Duration(persistentRoomExp.flagKey, persistentRoomExp.defaultValue, "Time empty persistent room is kept before it is deleted, 0 keeps it forever")
+	pflag.Duration(videoDataCacheTtl.flagKey, videoDataCacheTtl.defaultValue, "Time fetched video metadata is cached, 0 disables cache")
+	pflag.Duration(videoDataCacheNegativeTtl.flagKey, videoDataCacheNegativeTtl.defaultValue, "Time not found and not embeddable videos are cached, 0 disables it")
 	pflag.Int(redisPort.flagKey, redisPort.defaultValue, "Redis port")
 	pflag.String(redisHost.flagKey, redisHost.defaultValue, "Redis host")
 	pflag.String(redisPassword.flagKey, redisPassword.defaultValue, "Redis password")
@@ -87,6 +172,20 @@ func loadAppConfig() *app.AppConfig {
 	viper.BindEnv(logLevel.flagKey, logLevel.envKey)
 	viper.BindEnv(membersLimit.flagKey, membersLimit.envKey)
 	viper.BindEnv(playlistLimit.flagKey, playlistLimit.envKey)
+	viper.BindEnv(historyLimit.flagKey, historyLimit.envKey)
+	viper.BindEnv(writeQueueSize.flagKey, writeQueueSize.envKey)
+	viper.BindEnv(writeTimeout.flagKey, writeTimeout.envKey)
+	viper.BindEnv(pingInterval.flagKey, pingInterval.envKey)
+	viper.BindEnv(pongWait.flagKey, pongWait.envKey)
+	viper.BindEnv(compression.flagKey, compression.envKey)
+	viper.BindEnv(compressionLevel.flagKey, compressionLevel.envKey)
+	viper.BindEnv(compressionThreshold.flagKey, compressionThreshold.envKey)
+	viper.BindEnv(reconnectGracePeriod.flagKey, reconnectGracePeriod.envKey)
+	viper.BindEnv(driftTolerance.flagKey, driftTolerance.envKey)
+	viper.BindEnv(roomExp.flagKey, roomExp.envKey)
+	viper.BindEnv(persistentRoomExp.flagKey, persistentRoomExp.envKey)
+	viper.BindEnv(videoDataCacheTtl.flagKey, videoDataCacheTtl.envKey)
+	viper.BindEnv(videoDataCacheNegativeTtl.flagKey, videoDataCacheNegativeTtl.envKey)
 	viper.BindEnv(redisPort.flagKey, redisPort.envKey)
 	viper.BindEnv(redisHost.flagKey, redisHost.envKey)
 	viper.BindEnv(redisPassword.flagKey, redisPassword.envKey)
@@ -97,20 +196,48 @@ func loadAppConfig() *app.AppConfig {
 	viper.SetDefault(logLevel.flagKey, logLevel.defaultValue)
 	viper.SetDefault(membersLimit.flagKey, membersLimit.defaultValue)
 	viper.SetDefault(playlistLimit.flagKey, playlistLimit.defaultValue)
+	viper.SetDefault(historyLimit.flagKey, historyLimit.defaultValue)
+	viper.SetDefault(writeQueueSize.flagKey, writeQueueSize.defaultValue)
+	viper.SetDefault(writeTimeout.flagKey, writeTimeout.defaultValue)
+	viper.SetDefault(pingInterval.flagKey, pingInterval.defaultValue)
+	viper.SetDefault(pongWait.flagKey, pongWait.defaultValue)
+	viper.SetDefault(compression.flagKey, compression.defaultValue)
+	viper.SetDefault(compressionLevel.flagKey, compressionLevel.defaultValue)
+	viper.SetDefault(compressionThreshold.flagKey, compressionThreshold.defaultValue)
+	viper.SetDefault(reconnectGracePeriod.flagKey, reconnectGracePeriod.defaultValue)
+	viper.SetDefault(driftTolerance.flagKey, driftTolerance.defaultValue)
+	viper.SetDefault(roomExp.flagKey, roomExp.defaultValue)
+	viper.SetDefault(persistentRoomExp.flagKey, persistentRoomExp.defaultValue)
+	viper.SetDefault(videoDataCacheTtl.flagKey, videoDataCacheTtl.defaultValue)
+	viper.SetDefault(videoDataCacheNegativeTtl.flagKey, videoDataCacheNegativeTtl.defaultValue)
 	viper.SetDefault(redisPort.flagKey, redisPort.defaultValue)
 	viper.SetDefault(redisHost.flagKey, redisHost.defaultValue)
 	viper.SetDefault(redisPassword.flagKey, redisPassword.defaultValue)
 
 	config := &app.AppConfig{
-		Secret:        viper.GetString(secret.flagKey),
-		Host:          viper.GetString(host.flagKey),
-		Port:          viper.GetInt(port.flagKey),
-		LogLevel:      viper.GetString(logLevel.flagKey),
-		MembersLimit:  viper.GetInt(membersLimit.flagKey),
-		PlaylistLimit: viper.GetInt(playlistLimit.flagKey),
-		RedisPort:     viper.GetInt(redisPort.flagKey),
-		RedisHost:     viper.GetString(redisHost.flagKey),
-		RedisPassword: viper.GetString(redisPassword.flagKey),
+		Secret:                    viper.GetString(secret.flagKey),
+		Host:                      viper.GetString(host.flagKey),
+		Port:                      viper.GetInt(port.flagKey),
+		LogLevel:                  viper.GetString(logLevel.flagKey),
+		MembersLimit:              viper.GetInt(membersLimit.flagKey),
+		PlaylistLimit:             viper.GetInt(playlistLimit.flagKey),
+		HistoryLimit:              viper.GetInt(historyLimit.flagKey),
+		RedisPort:                 viper.GetInt(redisPort.flagKey),
+		RedisHost:                 viper.GetString(redisHost.flagKey),
+		RedisPassword:             viper.GetString(redisPassword.flagKey),
+		WriteQueueSize:            viper.GetInt(writeQueueSize.flagKey),
+		WriteTimeout:              viper.GetDuration(writeTimeout.flagKey),
+		PingInterval:              viper.GetDuration(pingInterval.flagKey),
+		PongWait:                  viper.GetDuration(pongWait.flagKey),
+		Compression:               viper.GetBool(compression.flagKey),
+		CompressionLevel:          viper.GetInt(compressionLevel.flagKey),
+		CompressionThreshold:      viper.GetInt(compressionThreshold.flagKey),
+		ReconnectGracePeriod:      viper.GetDuration(reconnectGracePeriod.flagKey),
+		DriftTolerance:            viper.GetDuration(driftTolerance.flagKey),
+		RoomExp:                   viper.GetDuration(roomExp.flagKey),
+		PersistentRoomExp:         viper.GetDuration(persistentRoomExp.flagKey),
+		VideoDataCacheTtl:         viper.GetDuration(videoDataCacheTtl.flagKey),
+		VideoDataCacheNegativeTtl: viper.GetDuration(videoDataCacheNegativeTtl.flagKey),
 	}
 
 	return config
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"compress/flate"
 	"context"
 	"fmt"
 	"log"
@@ -12,12 +13,17 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/sharetube/server/internal/broker"
 	"github.com/sharetube/server/internal/controller"
+	"github.com/sharetube/server/internal/executor"
 	"github.com/sharetube/server/internal/repository/connection/inmemory"
 	"github.com/sharetube/server/internal/repository/room/redis"
+	videodataRedis "github.com/sharetube/server/internal/repository/videodata/redis"
 	"github.com/sharetube/server/internal/service"
 	"github.com/sharetube/server/pkg/ctxlogger"
 	"github.com/sharetube/server/pkg/redisclient"
+	"github.com/sharetube/server/pkg/videoprovider"
+	"github.com/sharetube/server/pkg/ytvideodata"
 )
 
 type AppConfig struct {
@@ -26,13 +32,35 @@ type AppConfig struct {
 	Port          int    `json:"port"`
 	MembersLimit  int    `json:"members_limit"`
 	PlaylistLimit int    `json:"playlist_limit"`
+	HistoryLimit  int    `json:"history_limit"`
 	LogLevel      string `json:"log_level"`
 	RedisPort     int    `json:"redis_port"`
 	RedisHost     string `json:"redis_host"`
 	RedisPassword string `json:"-"`
+	// outbound messages queued per conn before member is evicted
+	WriteQueueSize int           `json:"write_queue_size"`
+	WriteTimeout   time.Duration `json:"write_timeout"`
+	PingInterval   time.Duration `json:"ping_interval"`
+	PongWait       time.Duration `json:"pong_wait"`
+	// permessage-deflate of messages not smaller than CompressionThreshold bytes
+	Compression          bool `json:"compression"`
+	CompressionLevel     int  `json:"compression_level"`
+	CompressionThreshold int  `json:"compression_threshold"`
+	// how long disconnected member keeps its slot
+	ReconnectGracePeriod time.Duration `json:"reconnect_grace_period"`
+	// allowed difference between member and expected player position
+	DriftTolerance time.Duration `json:"drift_tolerance"`
+	// expiration of room after last member left
+	RoomExp time.Duration `json:"room_exp"`
+	// same for persistent room, zero means never
+	PersistentRoomExp time.Duration `json:"persistent_room_exp"`
+	// shared cache of fetched video metadata, zero disables it
+	VideoDataCacheTtl time.Duration `json:"video_data_cache_ttl"`
+	// same for not found and not embeddable videos
+	VideoDataCacheNegativeTtl time.Duration `json:"video_data_cache_negative_ttl"`
 }
 
-// todo: add validation
+// Validate checks config bounds, Run refuses to start with invalid config.
 func (cfg *AppConfig) Validate() error {
 	if cfg.MembersLimit < 1 {
 		return fmt.Errorf("members limit must be greater than 0")
@@ -40,10 +68,53 @@ func (cfg *AppConfig) Validate() error {
 	if cfg.PlaylistLimit < 1 {
 		return fmt.Errorf("playlist limit must be greater than 0")
 	}
+	if cfg.HistoryLimit < 1 {
+		return fmt.Errorf("history limit must be greater than 0")
+	}
+	if cfg.WriteQueueSize < 1 {
+		return fmt.Errorf("write queue size must be greater than 0")
+	}
+	if cfg.WriteTimeout <= 0 {
+		return fmt.Errorf("write timeout must be greater than 0")
+	}
+	if cfg.PingInterval <= 0 {
+		return fmt.Errorf("ping interval must be greater than 0")
+	}
+	if cfg.PongWait <= cfg.PingInterval {
+		return fmt.Errorf("pong wait must be greater than ping interval")
+	}
+	if cfg.CompressionLevel < flate.HuffmanOnly || cfg.CompressionLevel > flate.BestCompression {
+		return fmt.Errorf("compression level must be from %d to %d", flate.HuffmanOnly, flate.BestCompression)
+	}
+	if cfg.CompressionThreshold < 0 {
+		return fmt.Errorf("compression threshold must not be negative")
+	}
+	if cfg.ReconnectGracePeriod < 0 {
+		return fmt.Errorf("reconnect grace period must not be negative")
+	}
+	if cfg.DriftTolerance <= 0 {
+		return fmt.Errorf("drift tolerance must be greater than 0")
+	}
+	if cfg.RoomExp <= 0 {
+		return fmt.Errorf("room expiration must be greater than 0")
+	}
+	if cfg.PersistentRoomExp < 0 {
+		return fmt.Errorf("persistent room expiration must not be negative")
+	}
+	if cfg.VideoDataCacheTtl < 0 {
+		return fmt.Errorf("video data cache ttl must not be negative")
+	}
+	if cfg.VideoDataCacheNegativeTtl < 0 {
+		return fmt.Errorf("video data cache negative ttl must not be negative")
+	}
 	return nil
 }
 
 func Run(ctx context.Context, cfg *AppConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
 	logLevel := slog.LevelInfo
 	if err := logLevel.UnmarshalText([]byte(strings.ToUpper(cfg.LogLevel))); err != nil {
 		log.Fatal(err)
@@ -69,18 +140,45 @@ func Run(ctx context.Context, cfg *AppConfig) error {
 
 	roomRepo := redis.NewRepo(rc, 14*24*time.Hour)
 	connectionRepo := inmemory.NewRepo()
-	roomService := service.New(roomRepo, connectionRepo, &service.Config{
-		MembersLimit:  cfg.MembersLimit,
-		PlaylistLimit: cfg.PlaylistLimit,
-		Secret:        cfg.Secret,
-		RoomExp:       5 * time.Minute,
+	connBroker := broker.New(rc, connectionRepo, logger)
+	defer connBroker.Close()
+	videoDataFetcher := videodataRedis.NewCache(rc, ytvideodata.Fetcher{}, cfg.VideoDataCacheTtl, cfg.VideoDataCacheNegativeTtl, logger)
+	videoProvider := videoprovider.NewChain(
+		videoprovider.NewYouTube(videoDataFetcher),
+		videoprovider.NewVimeo(http.DefaultClient, videoprovider.VimeoBaseUrl),
+		videoprovider.NewDirect(),
+	)
+	playlistFetcher := ytvideodata.NewPlaylistFetcher(http.DefaultClient, ytvideodata.YouTubeBaseUrl, videoDataFetcher)
+	roomService := service.New(roomRepo, videoProvider, playlistFetcher, logger, &service.Config{
+		MembersLimit:         cfg.MembersLimit,
+		PlaylistLimit:        cfg.PlaylistLimit,
+		HistoryLimit:         cfg.HistoryLimit,
+		Secret:               cfg.Secret,
+		RoomExp:              cfg.RoomExp,
+		ReconnectGracePeriod: cfg.ReconnectGracePeriod,
+		PersistentRoomExp:    cfg.PersistentRoomExp,
+		DriftTolerance:       cfg.DriftTolerance,
+	})
+	controller := controller.NewController(roomService, connBroker, executor.New(), logger, &controller.Config{
+		WriteQueueSize:       cfg.WriteQueueSize,
+		WriteTimeout:         cfg.WriteTimeout,
+		PingInterval:         cfg.PingInterval,
+		PongWait:             cfg.PongWait,
+		Compression:          cfg.Compression,
+		CompressionLevel:     cfg.CompressionLevel,
+		CompressionThreshold: cfg.CompressionThreshold,
 	})
-	controller := controller.NewController(roomService, logger)
 	server := &http.Server{Addr: fmt.Sprintf("%s:%d", cfg.Host, cfg.Port), Handler: controller.GetMux()}
 
 	// graceful shutdown
 	serverCtx, serverStopCtx := context.WithCancel(ctx)
 
+	go func() {
+		if err := connBroker.Run(serverCtx, controller.Deliver); err != nil {
+			logger.ErrorContext(serverCtx, "broker stopped", "error", err)
+		}
+	}()
+
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	go func() {
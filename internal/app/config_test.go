@@ -0,0 +1,73 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newValidConfig() *AppConfig {
+	return &AppConfig{
+		Secret:                    "secret",
+		Host:                      "localhost",
+		Port:                      8080,
+		MembersLimit:              9,
+		PlaylistLimit:             25,
+		HistoryLimit:              50,
+		LogLevel:                  "INFO",
+		RedisPort:                 6379,
+		RedisHost:                 "localhost",
+		WriteQueueSize:            64,
+		WriteTimeout:              10 * time.Second,
+		PingInterval:              25 * time.Second,
+		PongWait:                  60 * time.Second,
+		CompressionLevel:          1,
+		CompressionThreshold:      512,
+		ReconnectGracePeriod:      15 * time.Second,
+		DriftTolerance:            500 * time.Millisecond,
+		RoomExp:                   5 * time.Minute,
+		VideoDataCacheTtl:         24 * time.Hour,
+		VideoDataCacheNegativeTtl: 10 * time.Minute,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, newValidConfig().Validate())
+
+	tests := map[string]func(cfg *AppConfig){
+		"members limit":          func(cfg *AppConfig) { cfg.MembersLimit = 0 },
+		"playlist limit":         func(cfg *AppConfig) { cfg.PlaylistLimit = 0 },
+		"history limit":          func(cfg *AppConfig) { cfg.HistoryLimit = 0 },
+		"write queue size":       func(cfg *AppConfig) { cfg.WriteQueueSize = 0 },
+		"write timeout":          func(cfg *AppConfig) { cfg.WriteTimeout = 0 },
+		"ping interval":          func(cfg *AppConfig) { cfg.PingInterval = 0 },
+		"pong wait":              func(cfg *AppConfig) { cfg.PongWait = cfg.PingInterval },
+		"compression level":      func(cfg *AppConfig) { cfg.CompressionLevel = 10 },
+		"compression threshold":  func(cfg *AppConfig) { cfg.CompressionThreshold = -1 },
+		"reconnect grace period": func(cfg *AppConfig) { cfg.ReconnectGracePeriod = -time.Second },
+		"drift tolerance":        func(cfg *AppConfig) { cfg.DriftTolerance = 0 },
+		"room exp":               func(cfg *AppConfig) { cfg.RoomExp = 0 },
+		"persistent room exp":    func(cfg *AppConfig) { cfg.PersistentRoomExp = -time.Second },
+		"cache ttl":              func(cfg *AppConfig) { cfg.VideoDataCacheTtl = -time.Second },
+		"cache negative ttl":     func(cfg *AppConfig) { cfg.VideoDataCacheNegativeTtl = -time.Second },
+	}
+
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := newValidConfig()
+			mutate(cfg)
+			assert.Error(t, cfg.Validate())
+		})
+	}
+}
+
+func TestRunRejectsInvalidConfig(t *testing.T) {
+	cfg := newValidConfig()
+	cfg.PingInterval = 0
+
+	err := Run(context.Background(), cfg)
+	assert.ErrorContains(t, err, "invalid config")
+}
@@ -0,0 +1,339 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"github.com/sharetube/server/internal/repository/connection"
+)
+
+// Message is published to room channel and delivered by every instance
+// to its locally held connections of MemberIds.
+type Message struct {
+	// room sequence number, zero for close messages which are not logged.
+	// Payload is delivered as published, receiver adds seq to it if needed.
+	Seq       int             `json:"seq,omitempty"`
+	MemberIds []string        `json:"member_ids"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	CloseCode int             `json:"close_code,omitempty"`
+	CloseText string          `json:"close_text,omitempty"`
+}
+
+const (
+	// number of last room events kept for resume
+	eventLogSize = 256
+	eventLogExp  = 24 * time.Hour
+	// how long Connect waits for confirmation of room channel subscription
+	subscribeTimeout = 5 * time.Second
+)
+
+// KEYS[1] seq key, KEYS[2] event log key. ARGV[1] channel, ARGV[2] json
+// message without seq, ARGV[3] log size, ARGV[4] expiration in seconds.
+// Seq is prepended to fields of marshaled message, which always has member
+// ids, so numbers in payload are not reencoded by lua.
+const publishEventScript = `
+	local seq = redis.call('INCR', KEYS[1])
+	local msg = '{"seq":' .. seq .. ',' .. string.sub(ARGV[2], 2)
+
+	redis.call('RPUSH', KEYS[2], msg)
+	redis.call('LTRIM', KEYS[2], -tonumber(ARGV[3]), -1)
+	redis.call('EXPIRE', KEYS[1], ARGV[4])
+	redis.call('EXPIRE', KEYS[2], ARGV[4])
+	redis.call('PUBLISH', ARGV[1], msg)
+
+	return seq
+`
+
+type DeliverFunc func(context.Context, *websocket.Conn, *Message)
+
+type iConnRepo interface {
+	Replace(*websocket.Conn, string) *websocket.Conn
+	RemoveByConn(*websocket.Conn) (string, error)
+	GetConn(string) (*websocket.Conn, error)
+}
+
+type broker struct {
+	rc                 *redis.Client
+	pubsub             *redis.PubSub
+	publishEventScript *redis.Script
+	connRepo           iConnRepo
+	logger             *slog.Logger
+	rooms              map[string]int
+	mu                 sync.Mutex
+	// closed once subscription to channel is confirmed, see Run
+	subscribeWaiters   map[string][]chan struct{}
+	subscribeWaitersMu sync.Mutex
+}
+
+func New(rc *redis.Client, connRepo iConnRepo, logger *slog.Logger) *broker {
+	return &broker{
+		rc:                 rc,
+		pubsub:             rc.Subscribe(context.Background()),
+		publishEventScript: redis.NewScript(publishEventScript),
+		connRepo:           connRepo,
+		logger:             logger,
+		rooms:              make(map[string]int),
+		mu:                 sync.Mutex{},
+		subscribeWaiters:   make(map[string][]chan struct{}),
+		subscribeWaitersMu: sync.Mutex{},
+	}
+}
+
+func (b *broker) getRoomChannel(roomId string) string {
+	return fmt.Sprintf("room:%s:events", roomId)
+}
+
+func (b *broker) getSeqKey(roomId string) string {
+	return fmt.Sprintf("room:%s:seq", roomId)
+}
+
+func (b *broker) getEventLogKey(roomId string) string {
+	return fmt.Sprintf("room:%s:event-log", roomId)
+}
+
+// Connect registers conn locally and subscribes instance to room channel
+// if it is the first local member of the room. Previous local conn of member,
+// e.g. not closed yet after member reconnected, is replaced and returned, so
+// caller can close it.
+// Connect returns once subscription is confirmed, so every event with seq
+// greater than GetSeq read afterwards is delivered to conn.
+func (b *broker) Connect(ctx context.Context, roomId, memberId string, conn *websocket.Conn) (*websocket.Conn, error) {
+	replaced := b.connRepo.Replace(conn, memberId)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rooms[roomId] == 0 {
+		if err := b.subscribe(ctx, b.getRoomChannel(roomId)); err != nil {
+			b.connRepo.RemoveByConn(conn)
+			return nil, fmt.Errorf("failed to subscribe to room channel: %w", err)
+		}
+	}
+	b.rooms[roomId]++
+
+	return replaced, nil
+}
+
+// subscribe subscribes to channel and waits for confirmation received by Run.
+// Receive of pubsub can not be used for it, since Run reads pubsub channel.
+func (b *broker) subscribe(ctx context.Context, channel string) error {
+	confirmed := make(chan struct{})
+	b.subscribeWaitersMu.Lock()
+	b.subscribeWaiters[channel] = append(b.subscribeWaiters[channel], confirmed)
+	b.subscribeWaitersMu.Unlock()
+
+	if err := b.pubsub.Subscribe(ctx, channel); err != nil {
+		b.removeSubscribeWaiter(channel, confirmed)
+		return err
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, subscribeTimeout)
+	defer cancel()
+
+	select {
+	case <-confirmed:
+		return nil
+	case <-waitCtx.Done():
+		b.removeSubscribeWaiter(channel, confirmed)
+		// instance must not stay subscribed while room count is not incremented
+		if err := b.pubsub.Unsubscribe(context.WithoutCancel(ctx), channel); err != nil {
+			return fmt.Errorf("failed to unsubscribe: %w", err)
+		}
+
+		return fmt.Errorf("subscription is not confirmed: %w", waitCtx.Err())
+	}
+}
+
+// confirmSubscription releases Connect calls waiting for subscription to
+// channel. Go-redis resubscribes on reconnect, confirmations without waiters
+// are ignored.
+func (b *broker) confirmSubscription(channel string) {
+	b.subscribeWaitersMu.Lock()
+	defer b.subscribeWaitersMu.Unlock()
+
+	for _, confirmed := range b.subscribeWaiters[channel] {
+		close(confirmed)
+	}
+	delete(b.subscribeWaiters, channel)
+}
+
+func (b *broker) removeSubscribeWaiter(channel string, confirmed chan struct{}) {
+	b.subscribeWaitersMu.Lock()
+	defer b.subscribeWaitersMu.Unlock()
+
+	b.subscribeWaiters[channel] = slices.DeleteFunc(b.subscribeWaiters[channel], func(c chan struct{}) bool {
+		return c == confirmed
+	})
+	if len(b.subscribeWaiters[channel]) == 0 {
+		delete(b.subscribeWaiters, channel)
+	}
+}
+
+// Disconnect removes local conn and unsubscribes instance from room channel
+// if no local members of the room left. Conn replaced by newer conn of the
+// same member was already removed, see Connect.
+// Room count is decremented even if conn removal fails, so instance does not
+// stay subscribed to room channel forever.
+func (b *broker) Disconnect(ctx context.Context, roomId string, conn *websocket.Conn) error {
+	_, removeErr := b.connRepo.RemoveByConn(conn)
+	if errors.Is(removeErr, connection.ErrNotFound) {
+		removeErr = nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.rooms[roomId]--
+	if b.rooms[roomId] <= 0 {
+		delete(b.rooms, roomId)
+		if err := b.pubsub.Unsubscribe(ctx, b.getRoomChannel(roomId)); err != nil {
+			return fmt.Errorf("failed to unsubscribe from room channel: %w", err)
+		}
+	}
+
+	if removeErr != nil {
+		return fmt.Errorf("failed to remove conn: %w", removeErr)
+	}
+
+	return nil
+}
+
+// Publish delivers msg to its members. Messages with payload get next room seq
+// and are appended to room event log.
+func (b *broker) Publish(ctx context.Context, roomId string, msg *Message) error {
+	if len(msg.MemberIds) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	if msg.Payload == nil {
+		return b.rc.Publish(ctx, b.getRoomChannel(roomId), data).Err()
+	}
+
+	// seq is assigned by script
+	if msg.Seq != 0 {
+		return errors.New("message seq must not be set")
+	}
+
+	return b.publishEventScript.Run(ctx, b.rc,
+		[]string{b.getSeqKey(roomId), b.getEventLogKey(roomId)},
+		b.getRoomChannel(roomId),
+		data,
+		eventLogSize,
+		int(eventLogExp.Seconds()),
+	).Err()
+}
+
+// GetSeq returns seq of the last published room event.
+func (b *broker) GetSeq(ctx context.Context, roomId string) (int, error) {
+	seq, err := b.rc.Get(ctx, b.getSeqKey(roomId)).Int()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return seq, nil
+}
+
+// GetEventsAfter returns logged room events with seq greater than afterSeq.
+// False is returned if some of them were already trimmed from the log.
+func (b *broker) GetEventsAfter(ctx context.Context, roomId string, afterSeq int) ([]*Message, bool, error) {
+	seq, err := b.GetSeq(ctx, roomId)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get seq: %w", err)
+	}
+
+	if afterSeq > seq {
+		return nil, false, nil
+	}
+
+	data, err := b.rc.LRange(ctx, b.getEventLogKey(roomId), 0, -1).Result()
+	if err != nil {
+		return nil, false, err
+	}
+
+	events := make([]*Message, 0, len(data))
+	for _, d := range data {
+		var msg Message
+		if err := json.Unmarshal([]byte(d), &msg); err != nil {
+			return nil, false, fmt.Errorf("failed to unmarshal event: %w", err)
+		}
+
+		if msg.Seq > afterSeq {
+			events = append(events, &msg)
+		}
+	}
+
+	if afterSeq == seq {
+		return events, true, nil
+	}
+
+	return events, len(events) > 0 && events[0].Seq == afterSeq+1, nil
+}
+
+// Run receives messages from subscribed room channels and delivers them to
+// local conns until ctx is done. Connect does not return before Run receives
+// confirmation of its subscription.
+func (b *broker) Run(ctx context.Context, deliver DeliverFunc) error {
+	ch := b.pubsub.ChannelWithSubscriptions()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case received, ok := <-ch:
+			if !ok {
+				return nil
+			}
+
+			var redisMsg *redis.Message
+			switch v := received.(type) {
+			case *redis.Subscription:
+				if v.Kind == "subscribe" {
+					b.confirmSubscription(v.Channel)
+				}
+				continue
+			case *redis.Message:
+				redisMsg = v
+			default:
+				continue
+			}
+
+			var msg Message
+			if err := json.Unmarshal([]byte(redisMsg.Payload), &msg); err != nil {
+				b.logger.ErrorContext(ctx, "failed to unmarshal broker message", "error", err, "channel", redisMsg.Channel)
+				continue
+			}
+
+			for _, memberId := range msg.MemberIds {
+				conn, err := b.connRepo.GetConn(memberId)
+				if err != nil {
+					if !errors.Is(err, connection.ErrNotFound) {
+						b.logger.ErrorContext(ctx, "failed to get conn", "error", err, "member_id", memberId)
+					}
+					continue
+				}
+
+				deliver(ctx, conn, &msg)
+			}
+		}
+	}
+}
+
+// Close closes broker subscription.
+func (b *broker) Close() error {
+	return b.pubsub.Close()
+}
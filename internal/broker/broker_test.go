@@ -0,0 +1,287 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"github.com/sharetube/server/internal/repository/connection/inmemory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type delivery struct {
+	conn *websocket.Conn
+	msg  *Message
+}
+
+func newInstance(t *testing.T, ctx context.Context, addr string) (*broker, chan delivery) {
+	t.Helper()
+
+	rc := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { rc.Close() })
+
+	b := New(rc, inmemory.NewRepo(), slog.Default())
+	t.Cleanup(func() { b.Close() })
+
+	deliveries := make(chan delivery, 16)
+	go b.Run(ctx, func(_ context.Context, conn *websocket.Conn, msg *Message) {
+		deliveries <- delivery{conn: conn, msg: msg}
+	})
+
+	return b, deliveries
+}
+
+func connect(t *testing.T, b *broker, roomId, memberId string, conn *websocket.Conn) {
+	t.Helper()
+
+	replaced, err := b.Connect(context.Background(), roomId, memberId, conn)
+	require.NoError(t, err)
+	require.Nil(t, replaced)
+}
+
+func waitForSubscribers(t *testing.T, rc *redis.Client, channel string, n int64) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		res, err := rc.PubSubNumSub(context.Background(), channel).Result()
+		return err == nil && res[channel] == n
+	}, time.Second, 10*time.Millisecond)
+}
+
+func receive(t *testing.T, deliveries chan delivery) delivery {
+	t.Helper()
+
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+		return delivery{}
+	}
+}
+
+func assertNoDelivery(t *testing.T, deliveries chan delivery) {
+	t.Helper()
+
+	select {
+	case d := <-deliveries:
+		t.Fatalf("unexpected delivery: %s", d.msg.Payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBroadcastAcrossInstances(t *testing.T) {
+	s := miniredis.RunT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rc := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer rc.Close()
+
+	instance1, deliveries1 := newInstance(t, ctx, s.Addr())
+	instance2, deliveries2 := newInstance(t, ctx, s.Addr())
+
+	roomId := "room0001"
+	channel := instance1.getRoomChannel(roomId)
+	conn1 := &websocket.Conn{}
+	conn2 := &websocket.Conn{}
+	conn3 := &websocket.Conn{}
+
+	connect(t, instance1, roomId, "member-1", conn1)
+	connect(t, instance2, roomId, "member-2", conn2)
+	connect(t, instance2, roomId, "member-3", conn3)
+	waitForSubscribers(t, rc, channel, 2)
+
+	// event published by one instance reaches members connected to both
+	payload := json.RawMessage(`{"type":"MEMBER_JOINED","payload":null}`)
+	require.NoError(t, instance1.Publish(ctx, roomId, &Message{
+		MemberIds: []string{"member-1", "member-2"},
+		Payload:   payload,
+	}))
+
+	// seq is set on message, payload is delivered as published
+	d1 := receive(t, deliveries1)
+	assert.Same(t, conn1, d1.conn)
+	assert.Equal(t, 1, d1.msg.Seq)
+	assert.JSONEq(t, string(payload), string(d1.msg.Payload))
+
+	d2 := receive(t, deliveries2)
+	assert.Same(t, conn2, d2.conn)
+	assert.Equal(t, 1, d2.msg.Seq)
+	assert.JSONEq(t, string(payload), string(d2.msg.Payload))
+
+	assertNoDelivery(t, deliveries1)
+	assertNoDelivery(t, deliveries2)
+
+	// targeted message is delivered only by instance holding the conn
+	require.NoError(t, instance1.Publish(ctx, roomId, &Message{
+		MemberIds: []string{"member-3"},
+		CloseCode: 4001,
+		CloseText: "kicked",
+	}))
+
+	d3 := receive(t, deliveries2)
+	assert.Same(t, conn3, d3.conn)
+	assert.Equal(t, 4001, d3.msg.CloseCode)
+	assertNoDelivery(t, deliveries1)
+
+	// instance unsubscribes only after last local member of the room left
+	require.NoError(t, instance2.Disconnect(ctx, roomId, conn3))
+	waitForSubscribers(t, rc, channel, 2)
+	require.NoError(t, instance2.Disconnect(ctx, roomId, conn2))
+	waitForSubscribers(t, rc, channel, 1)
+
+	require.NoError(t, instance1.Publish(ctx, roomId, &Message{
+		MemberIds: []string{"member-1", "member-2"},
+		Payload:   payload,
+	}))
+	receive(t, deliveries1)
+	assertNoDelivery(t, deliveries2)
+
+	// close messages are not logged, so both events can be resumed
+	events, ok, err := instance2.GetEventsAfter(ctx, roomId, 0)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.Len(t, events, 2)
+	assert.Equal(t, 1, events[0].Seq)
+	assert.Equal(t, 2, events[1].Seq)
+	assert.Equal(t, []string{"member-1", "member-2"}, events[1].MemberIds)
+
+	events, ok, err = instance2.GetEventsAfter(ctx, roomId, 2)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Empty(t, events)
+}
+
+func TestPublishEmptyPayload(t *testing.T) {
+	s := miniredis.RunT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rc := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer rc.Close()
+
+	instance, deliveries := newInstance(t, ctx, s.Addr())
+
+	roomId := "room0001"
+	connect(t, instance, roomId, "member-1", &websocket.Conn{})
+	waitForSubscribers(t, rc, instance.getRoomChannel(roomId), 1)
+
+	// script is loaded on first run, also after script cache is flushed
+	for seq := 1; seq <= 2; seq++ {
+		require.NoError(t, instance.Publish(ctx, roomId, &Message{
+			MemberIds: []string{"member-1"},
+			Payload:   json.RawMessage(`{}`),
+		}))
+
+		d := receive(t, deliveries)
+		assert.Equal(t, seq, d.msg.Seq)
+		assert.JSONEq(t, `{}`, string(d.msg.Payload))
+
+		require.NoError(t, rc.ScriptFlush(ctx).Err())
+	}
+
+	events, ok, err := instance.GetEventsAfter(ctx, roomId, 0)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.Len(t, events, 2)
+	assert.JSONEq(t, `{}`, string(events[1].Payload))
+}
+
+type failingConnRepo struct {
+	iConnRepo
+}
+
+func (failingConnRepo) RemoveByConn(*websocket.Conn) (string, error) {
+	return "", errors.New("conn repo failed")
+}
+
+func TestDisconnectUnsubscribesOnRemoveError(t *testing.T) {
+	s := miniredis.RunT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rc := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer rc.Close()
+
+	b := New(rc, failingConnRepo{inmemory.NewRepo()}, slog.Default())
+	defer b.Close()
+	// receives subscription confirmations Connect waits for
+	go b.Run(ctx, func(context.Context, *websocket.Conn, *Message) {})
+
+	roomId := "room0001"
+	channel := b.getRoomChannel(roomId)
+	conn := &websocket.Conn{}
+	connect(t, b, roomId, "member-1", conn)
+	waitForSubscribers(t, rc, channel, 1)
+
+	assert.Error(t, b.Disconnect(ctx, roomId, conn))
+	waitForSubscribers(t, rc, channel, 0)
+	assert.Empty(t, b.rooms)
+}
+
+func TestConnectReplacesConnOfMember(t *testing.T) {
+	s := miniredis.RunT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rc := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer rc.Close()
+
+	instance, deliveries := newInstance(t, ctx, s.Addr())
+
+	roomId := "room0001"
+	channel := instance.getRoomChannel(roomId)
+	oldConn := &websocket.Conn{}
+	newConn := &websocket.Conn{}
+
+	// member reconnects before its old conn is closed
+	connect(t, instance, roomId, "member-1", oldConn)
+	replaced, err := instance.Connect(ctx, roomId, "member-1", newConn)
+	require.NoError(t, err)
+	assert.Same(t, oldConn, replaced)
+	waitForSubscribers(t, rc, channel, 1)
+
+	// closing replaced conn keeps new one connected and subscribed
+	require.NoError(t, instance.Disconnect(ctx, roomId, oldConn))
+	require.NoError(t, instance.Publish(ctx, roomId, &Message{
+		MemberIds: []string{"member-1"},
+		Payload:   json.RawMessage(`{}`),
+	}))
+	assert.Same(t, newConn, receive(t, deliveries).conn)
+
+	require.NoError(t, instance.Disconnect(ctx, roomId, newConn))
+	waitForSubscribers(t, rc, channel, 0)
+}
+
+func TestConnectWaitsForSubscription(t *testing.T) {
+	s := miniredis.RunT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	instance, deliveries := newInstance(t, ctx, s.Addr())
+
+	// event published right after Connect returns is delivered, so seq read
+	// after Connect covers every event conn does not receive
+	for i, roomId := range []string{"room0001", "room0002", "room0003"} {
+		memberId := fmt.Sprintf("member-%d", i)
+		connect(t, instance, roomId, memberId, &websocket.Conn{})
+		seq, err := instance.GetSeq(ctx, roomId)
+		require.NoError(t, err)
+		assert.Zero(t, seq, i)
+
+		require.NoError(t, instance.Publish(ctx, roomId, &Message{
+			MemberIds: []string{memberId},
+			Payload:   json.RawMessage(`{}`),
+		}))
+		assert.Equal(t, 1, receive(t, deliveries).msg.Seq)
+	}
+}
@@ -8,6 +8,7 @@ const (
 	roomIdCtxKey contextKey = iota
 	memberIdCtxKey
 	requestIdCtxKey
+	wsRequestIdCtxKey
 )
 
 func (c controller) getRoomIdFromCtx(ctx context.Context) string {
@@ -27,3 +28,14 @@ func (c controller) getMemberIdFromCtx(ctx context.Context) string {
 
 	return memberId
 }
+
+// getWSRequestIdFromCtx returns id of websocket message being handled, see
+// wsRequestIdWSMw.
+func (c controller) getWSRequestIdFromCtx(ctx context.Context) string {
+	requestId, ok := ctx.Value(wsRequestIdCtxKey).(string)
+	if !ok {
+		return ""
+	}
+
+	return requestId
+}
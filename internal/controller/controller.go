@@ -4,48 +4,103 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/sharetube/server/internal/broker"
 	"github.com/sharetube/server/internal/service"
 	"github.com/sharetube/server/pkg/wsrouter"
 )
 
 type iRoomService interface {
 	CreateRoom(context.Context, *service.CreateRoomParams) (*service.CreateRoomResponse, error)
-	ConnectMember(context.Context, *service.ConnectMemberParams) error
+	SuspendMember(context.Context, *service.SuspendMemberParams) (*service.SuspendMemberResponse, error)
 	DisconnectMember(context.Context, *service.DisconnectMemberParams) (*service.DisconnectMemberResponse, error)
 	GetRoom(context.Context, string) (*service.Room, error)
+	GetMembers(context.Context, string) ([]service.Member, error)
+	GetPlaylist(context.Context, string) (*service.Playlist, error)
+	IsRoomExists(context.Context, string) (bool, error)
+	UpdateRoomSettings(context.Context, *service.UpdateRoomSettingsParams) (*service.UpdateRoomSettingsResponse, error)
 	UpdatePlayerState(context.Context, *service.UpdatePlayerStateParams) (*service.UpdatePlayerStateResponse, error)
 	UpdatePlayerVideo(context.Context, *service.UpdatePlayerVideoParams) (*service.UpdatePlayerVideoResponse, error)
+	GetPlayerPosition(context.Context, string) (*service.PlayerPosition, error)
+	ReportPosition(context.Context, *service.ReportPositionParams) (*service.ReportPositionResponse, error)
 	JoinRoom(context.Context, *service.JoinRoomParams) (*service.JoinRoomResponse, error)
 	AddVideo(context.Context, *service.AddVideoParams) (*service.AddVideoResponse, error)
+	ResolvePlaylist(context.Context, *service.ResolvePlaylistParams) (*service.ResolvedPlaylist, error)
+	ImportPlaylist(context.Context, *service.ImportPlaylistParams) (*service.ImportPlaylistResponse, error)
 	RemoveVideo(context.Context, *service.RemoveVideoParams) (*service.RemoveVideoResponse, error)
 	RemoveMember(context.Context, *service.RemoveMemberParams) (*service.RemoveMemberResponse, error)
 	PromoteMember(context.Context, *service.PromoteMemberParams) (*service.PromoteMemberResponse, error)
 	UpdateProfile(context.Context, *service.UpdateProfileParams) (*service.UpdateProfileResponse, error)
 	UpdateIsReady(context.Context, *service.UpdateIsReadyParams) (*service.UpdateIsReadyResponse, error)
+	StartBuffering(context.Context, *service.StartBufferingParams) (*service.StartBufferingResponse, error)
 	UpdateIsMuted(context.Context, *service.UpdateIsMutedParams) (*service.UpdateIsMutedResponse, error)
 	ReorderPlaylist(context.Context, *service.ReorderPlaylistParams) (*service.ReorderPlaylistResponse, error)
 	EndVideo(context.Context, *service.EndVideoParams) (*service.EndVideoResponse, error)
+	GetHistory(context.Context, string) ([]service.HistoryEntry, error)
+	RequeueHistoryVideo(context.Context, *service.RequeueHistoryVideoParams) (*service.AddVideoResponse, error)
+	PlayHistoryVideo(context.Context, *service.PlayHistoryVideoParams) (*service.UpdatePlayerVideoResponse, error)
+}
+
+type iBroker interface {
+	Connect(ctx context.Context, roomId, memberId string, conn *websocket.Conn) (*websocket.Conn, error)
+	Disconnect(ctx context.Context, roomId string, conn *websocket.Conn) error
+	Publish(ctx context.Context, roomId string, msg *broker.Message) error
+	GetSeq(ctx context.Context, roomId string) (int, error)
+	GetEventsAfter(ctx context.Context, roomId string, afterSeq int) ([]*broker.Message, bool, error)
+}
+
+type iExecutor interface {
+	Do(ctx context.Context, key string, fn func(context.Context) error) error
 }
 
 type controller struct {
 	roomService iRoomService
+	broker      iBroker
+	executor    iExecutor
+	writers     *connWriters
+	pongWait    time.Duration
 	upgrader    websocket.Upgrader
 	wsmux       *wsrouter.WSRouter
 	logger      *slog.Logger
 }
 
-func NewController(roomService iRoomService, logger *slog.Logger) *controller {
+type Config struct {
+	WriteQueueSize int
+	WriteTimeout   time.Duration
+	PingInterval   time.Duration
+	// conn is considered dead if nothing, including pong, was received within PongWait
+	PongWait time.Duration
+	// permessage-deflate is used if client supports it, only for messages
+	// not smaller than CompressionThreshold bytes
+	Compression          bool
+	CompressionLevel     int
+	CompressionThreshold int
+}
+
+func NewController(roomService iRoomService, broker iBroker, executor iExecutor, logger *slog.Logger, cfg *Config) *controller {
 	c := controller{
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true
 			},
+			EnableCompression: cfg.Compression,
 		},
 		roomService: roomService,
-		logger:      logger,
-		wsmux:       nil,
+		broker:      broker,
+		executor:    executor,
+		pongWait:    cfg.PongWait,
+		writers: newConnWriters(&connWriterConfig{
+			queueSize:            cfg.WriteQueueSize,
+			writeTimeout:         cfg.WriteTimeout,
+			pingInterval:         cfg.PingInterval,
+			compressionLevel:     cfg.CompressionLevel,
+			compressionThreshold: cfg.CompressionThreshold,
+			logger:               logger,
+		}),
+		logger: logger,
+		wsmux:  nil,
 	}
 	c.wsmux = c.getWSRouter()
 
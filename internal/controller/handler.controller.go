@@ -10,6 +10,7 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/sharetube/server/internal/service"
 	"github.com/sharetube/server/pkg/ctxlogger"
+	"github.com/sharetube/server/pkg/wsrouter"
 )
 
 func (c controller) createRoom(w http.ResponseWriter, r *http.Request) {
@@ -17,15 +18,24 @@ func (c controller) createRoom(w http.ResponseWriter, r *http.Request) {
 	deferDisconnect := true
 	start := time.Now()
 
+	protocol, err := c.negotiateProtocol(r)
+	if err != nil {
+		c.logger.DebugContext(r.Context(), "failed to negotiate protocol", "error", err)
+		c.rejectProtocolVersion(w, r, protocol.subprotocol, err)
+		return
+	}
+
 	user, err := c.getUser(r)
 	if err != nil {
 		c.logger.DebugContext(r.Context(), "failed to get user", "error", err)
+		c.writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
 		return
 	}
 
 	initialVideoUrl, err := c.getQueryParam(r, "video-url")
 	if err != nil {
 		c.logger.DebugContext(r.Context(), "failed to get query param", "error", err)
+		c.writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
 		return
 	}
 
@@ -37,51 +47,54 @@ func (c controller) createRoom(w http.ResponseWriter, r *http.Request) {
 	})
 	if err != nil {
 		c.logger.InfoContext(r.Context(), "failed to create room", "error", err)
+		c.writeServiceError(w, r, err)
 		return
 	}
 
-	conn, err := c.upgrader.Upgrade(w, r, nil)
+	conn, err := c.upgrade(w, r, protocol.subprotocol)
 	if err != nil {
 		c.logger.ErrorContext(r.Context(), "failed to upgrade to websocket", "error", err)
 		return
 	}
 	defer conn.Close()
 
-	if err := c.roomService.ConnectMember(r.Context(), &service.ConnectMemberParams{
-		Conn:     conn,
-		MemberId: createRoomResponse.JoinedMember.Id,
-	}); err != nil {
+	writer := c.writers.add(conn, protocol)
+	defer c.writers.remove(conn)
+
+	// events published before snapshot is written are held, see writeJoinedRoom
+	writer.hold()
+	replacedConn, err := c.broker.Connect(r.Context(), createRoomResponse.RoomId, createRoomResponse.JoinedMember.Id, conn)
+	if err != nil {
 		c.logger.ErrorContext(r.Context(), "failed to connect member", "error", err)
 		return
 	}
+	c.closeReplacedConn(replacedConn)
 	defer func() {
+		if err := c.broker.Disconnect(r.Context(), createRoomResponse.RoomId, conn); err != nil {
+			c.logger.DebugContext(r.Context(), "failed to disconnect conn", "error", err)
+		}
+
 		if deferDisconnect {
-			if err := c.helperDisconn(r.Context(), createRoomResponse.RoomId, createRoomResponse.JoinedMember.Id); err != nil {
+			if err := c.helperDisconn(r.Context(), createRoomResponse.RoomId, createRoomResponse.JoinedMember.Id, createRoomResponse.ConnId); err != nil {
 				c.logger.DebugContext(r.Context(), "failed to disconnect member", "error", err)
 			}
 		}
 	}()
 
-	roomState, err := c.roomService.GetRoom(r.Context(), createRoomResponse.RoomId)
-	if err != nil {
-		c.logger.ErrorContext(r.Context(), "failed to get room state", "error", err)
+	if err := c.writeJoinedRoom(r.Context(), writer, createRoomResponse.RoomId, createRoomResponse.JWT, &createRoomResponse.JoinedMember); err != nil {
+		c.logger.ErrorContext(r.Context(), "failed to write joined room", "error", err)
 		return
 	}
 
-	if err := c.writeToConn(r.Context(), conn, &Output{
-		Type: "JOINED_ROOM",
-		Payload: map[string]any{
-			"jwt":           createRoomResponse.JWT,
-			"joined_member": createRoomResponse.JoinedMember,
-			"room":          roomState,
-		},
-	}); err != nil {
+	if err := writer.release(nil); err != nil {
 		return
 	}
 
 	c.logger.InfoContext(r.Context(), "room created", "room_id", createRoomResponse.RoomId, "processing_time_us", time.Since(start).Microseconds())
 
-	ctx := context.WithValue(r.Context(), roomIdCtxKey, createRoomResponse.RoomId)
+	ctx := wsrouter.WithProtocolVersion(r.Context(), protocol.version)
+	ctx = wsrouter.WithCodec(ctx, protocol.codec)
+	ctx = context.WithValue(ctx, roomIdCtxKey, createRoomResponse.RoomId)
 	ctx = ctxlogger.AppendCtx(ctx, slog.String("room_id", createRoomResponse.RoomId))
 	ctx = context.WithValue(ctx, memberIdCtxKey, createRoomResponse.JoinedMember.Id)
 	ctx = ctxlogger.AppendCtx(ctx, slog.String("sender_id", createRoomResponse.JoinedMember.Id))
@@ -102,84 +115,130 @@ func (c controller) joinRoom(w http.ResponseWriter, r *http.Request) {
 	deferDisconnect := true
 	start := time.Now()
 
+	protocol, err := c.negotiateProtocol(r)
+	if err != nil {
+		c.logger.DebugContext(r.Context(), "failed to negotiate protocol", "error", err)
+		c.rejectProtocolVersion(w, r, protocol.subprotocol, err)
+		return
+	}
+
 	roomId := chi.URLParam(r, "room-id")
 	if roomId == "" {
 		c.logger.DebugContext(r.Context(), "empty room id")
+		c.writeJSONError(w, http.StatusNotFound, service.ErrRoomNotFound.Error())
 		return
 	}
 
 	user, err := c.getUser(r)
 	if err != nil {
 		c.logger.DebugContext(r.Context(), "failed to get user", "error", err)
+		c.writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
 		return
 	}
 
 	userJWT, _ := c.getQueryParam(r, "jwt")
 
-	joinRoomResponse, err := c.roomService.JoinRoom(r.Context(), &service.JoinRoomParams{
-		JWT:       userJWT,
-		Username:  user.username,
-		Color:     user.color,
-		AvatarUrl: user.avatarUrl,
-		RoomId:    roomId,
-	})
+	lastSeq, err := c.getOptIntQueryParam(r, "last-seq")
 	if err != nil {
-		c.logger.ErrorContext(r.Context(), "failed to join room", "error", err)
+		c.logger.DebugContext(r.Context(), "failed to get query param", "error", err)
+		c.writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
 		return
 	}
 
-	conn, err := c.upgrader.Upgrade(w, r, nil)
+	var joinRoomResponse *service.JoinRoomResponse
+	if err := c.executor.Do(r.Context(), roomId, func(ctx context.Context) error {
+		var err error
+		joinRoomResponse, err = c.roomService.JoinRoom(ctx, &service.JoinRoomParams{
+			JWT:       userJWT,
+			Username:  user.username,
+			Color:     user.color,
+			AvatarUrl: user.avatarUrl,
+			RoomId:    roomId,
+		})
+		return err
+	}); err != nil {
+		c.logger.InfoContext(r.Context(), "failed to join room", "error", err)
+		c.writeServiceError(w, r, err)
+		return
+	}
+
+	conn, err := c.upgrade(w, r, protocol.subprotocol)
 	if err != nil {
 		c.logger.ErrorContext(r.Context(), "failed to upgrade to websocket", "error", err)
 		return
 	}
 	defer conn.Close()
 
-	if err := c.roomService.ConnectMember(r.Context(), &service.ConnectMemberParams{
-		Conn:     conn,
-		MemberId: joinRoomResponse.JoinedMember.Id,
-	}); err != nil {
+	writer := c.writers.add(conn, protocol)
+	defer c.writers.remove(conn)
+
+	// events published before snapshot or replay is written are held, see
+	// writeJoinedRoom and resumeMember
+	writer.hold()
+	replacedConn, err := c.broker.Connect(r.Context(), roomId, joinRoomResponse.JoinedMember.Id, conn)
+	if err != nil {
 		c.logger.ErrorContext(r.Context(), "failed to connect member", "error", err)
 		return
 	}
+	c.closeReplacedConn(replacedConn)
 	defer func() {
+		if err := c.broker.Disconnect(r.Context(), roomId, conn); err != nil {
+			c.logger.DebugContext(r.Context(), "failed to disconnect conn", "error", err)
+		}
+
 		if deferDisconnect {
-			if err := c.helperDisconn(r.Context(), roomId, joinRoomResponse.JoinedMember.Id); err != nil {
+			if err := c.helperDisconn(r.Context(), roomId, joinRoomResponse.JoinedMember.Id, joinRoomResponse.ConnId); err != nil {
 				c.logger.DebugContext(r.Context(), "failed to disconnect member", "error", err)
 			}
 		}
 	}()
 
-	roomState, err := c.roomService.GetRoom(r.Context(), roomId)
-	if err != nil {
-		c.logger.ErrorContext(r.Context(), "failed to get room state", "error", err)
-		return
+	// missed events can be replayed only to member restored within grace
+	// period, others were not addressed by them
+	resumed := false
+	if lastSeq != nil && joinRoomResponse.IsRestored {
+		resumed, err = c.resumeMember(r.Context(), conn, writer, roomId, joinRoomResponse, *lastSeq)
+		if err != nil {
+			c.logger.ErrorContext(r.Context(), "failed to resume member", "error", err)
+			return
+		}
 	}
 
-	if err := c.writeToConn(r.Context(), conn, &Output{
-		Type: "JOINED_ROOM",
-		Payload: map[string]any{
-			"jwt":           joinRoomResponse.JWT,
-			"joined_member": joinRoomResponse.JoinedMember,
-			"room":          roomState,
-		},
-	}); err != nil {
-		return
+	if !resumed {
+		if err := c.writeJoinedRoom(r.Context(), writer, roomId, joinRoomResponse.JWT, &joinRoomResponse.JoinedMember); err != nil {
+			c.logger.ErrorContext(r.Context(), "failed to write joined room", "error", err)
+			return
+		}
+
+		if err := writer.release(nil); err != nil {
+			return
+		}
 	}
 
-	if err := c.broadcast(r.Context(), joinRoomResponse.Conns, &Output{
-		Type: "MEMBER_JOINED",
-		Payload: map[string]any{
-			"joined_member": joinRoomResponse.JoinedMember,
-			"members":       joinRoomResponse.Members,
-		},
-	}); err != nil {
-		return
+	if !joinRoomResponse.IsRestored {
+		if err := c.broadcast(r.Context(), roomId, joinRoomResponse.MemberIds, &Output{
+			Type: "MEMBER_JOINED",
+			Payload: map[string]any{
+				"joined_member":   joinRoomResponse.JoinedMember,
+				"members_version": joinRoomResponse.MembersVersion,
+			},
+			Legacy: &Output{
+				Type: "MEMBER_JOINED",
+				Payload: map[string]any{
+					"joined_member": joinRoomResponse.JoinedMember,
+					"members":       joinRoomResponse.Members,
+				},
+			},
+		}); err != nil {
+			return
+		}
 	}
 
 	c.logger.InfoContext(r.Context(), "room joined", "room_id", roomId, "processing_time_us", time.Since(start).Microseconds())
 
-	ctx := context.WithValue(r.Context(), roomIdCtxKey, roomId)
+	ctx := wsrouter.WithProtocolVersion(r.Context(), protocol.version)
+	ctx = wsrouter.WithCodec(ctx, protocol.codec)
+	ctx = context.WithValue(ctx, roomIdCtxKey, roomId)
 	ctx = ctxlogger.AppendCtx(ctx, slog.String("room_id", roomId))
 	ctx = context.WithValue(ctx, memberIdCtxKey, joinRoomResponse.JoinedMember.Id)
 	ctx = ctxlogger.AppendCtx(ctx, slog.String("sender_id", joinRoomResponse.JoinedMember.Id))
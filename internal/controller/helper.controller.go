@@ -2,12 +2,17 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/sharetube/server/internal/broker"
 	"github.com/sharetube/server/internal/service"
 )
 
@@ -35,6 +40,55 @@ func (c controller) getQueryParam(r *http.Request, key string) (string, error) {
 	return value, nil
 }
 
+func (c controller) getOptIntQueryParam(r *http.Request, key string) (*int, error) {
+	value := c.getOptQueryParam(r, key)
+	if value == nil {
+		return nil, nil
+	}
+
+	i, err := strconv.Atoi(*value)
+	if err != nil {
+		return nil, fmt.Errorf("param %s is not a number", key)
+	}
+
+	return &i, nil
+}
+
+func (c controller) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		c.logger.Debug("failed to write json response", "error", err)
+	}
+}
+
+func (c controller) writeJSONError(w http.ResponseWriter, status int, message string) {
+	c.writeJSON(w, status, map[string]any{
+		"error": message,
+	})
+}
+
+// writeServiceError responds with status matching service error kind.
+func (c controller) writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErr *service.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		c.writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"error":  "validation failed",
+			"fields": validationErr.Fields,
+		})
+	case errors.Is(err, service.ErrRoomNotFound):
+		c.writeJSONError(w, http.StatusNotFound, service.ErrRoomNotFound.Error())
+	case errors.Is(err, service.ErrRoomIsFull):
+		c.writeJSONError(w, http.StatusConflict, service.ErrRoomIsFull.Error())
+	case errors.Is(err, service.ErrInvalidToken):
+		c.writeJSONError(w, http.StatusUnauthorized, service.ErrInvalidToken.Error())
+	default:
+		c.logger.ErrorContext(r.Context(), "failed to handle request", "error", err)
+		c.writeJSONError(w, http.StatusInternalServerError, "internal server error")
+	}
+}
+
 type user struct {
 	username  string
 	color     string
@@ -61,44 +115,217 @@ func (c controller) getUser(r *http.Request) (user, error) {
 	}, nil
 }
 
+func (c controller) writeMessage(conn *websocket.Conn, data []byte) error {
+	w, ok := c.writers.get(conn)
+	if !ok {
+		return errWriterClosed
+	}
+
+	return w.sendMessage(0, data)
+}
+
 func (c controller) writeToConn(ctx context.Context, conn *websocket.Conn, output *Output) error {
 	c.logger.DebugContext(ctx, "writing to conn", "output", output)
-	return conn.WriteJSON(output)
+	data, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+
+	return c.writeMessage(conn, data)
 }
 
-func (c controller) writeError(ctx context.Context, conn *websocket.Conn, err error) error {
-	return c.writeToConn(ctx, conn, &Output{
-		Type:    "error",
-		Payload: err.Error(),
+func (c controller) broadcast(ctx context.Context, roomId string, memberIds []string, output *Output) error {
+	c.logger.DebugContext(ctx, "broadcasting", "output", output)
+	payload, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+
+	return c.broker.Publish(ctx, roomId, &broker.Message{
+		MemberIds: memberIds,
+		Payload:   payload,
 	})
 }
 
-func (c controller) broadcast(ctx context.Context, conns []*websocket.Conn, output *Output) error {
-	c.logger.DebugContext(ctx, "broadcasting", "output", output)
+func (c controller) writeToMember(ctx context.Context, roomId, memberId string, output *Output) error {
+	return c.broadcast(ctx, roomId, []string{memberId}, output)
+}
+
+func (c controller) closeMember(ctx context.Context, roomId, memberId string, code int, text string) error {
+	return c.broker.Publish(ctx, roomId, &broker.Message{
+		MemberIds: []string{memberId},
+		CloseCode: code,
+		CloseText: text,
+	})
+}
+
+// Deliver writes broker message to local conn.
+func (c controller) Deliver(ctx context.Context, conn *websocket.Conn, msg *broker.Message) {
+	w, ok := c.writers.get(conn)
+	if !ok {
+		return
+	}
+
 	var err error
-	for _, conn := range conns {
-		err = conn.WriteJSON(output)
+	switch {
+	case msg.CloseCode != 0:
+		err = w.send(websocket.CloseMessage, websocket.FormatCloseMessage(msg.CloseCode, msg.CloseText))
+	case msg.Seq != 0:
+		err = w.sendEvent(msg.Seq, msg.Payload)
+	default:
+		err = w.sendMessage(0, msg.Payload)
 	}
 
-	return err
+	if err != nil {
+		c.logger.DebugContext(ctx, "failed to deliver message", "error", err)
+	}
+}
+
+// writeJoinedRoom writes room snapshot with seq of the last event included in
+// it. Seq is read before room state, so events racing with snapshot are
+// written after it instead of being lost, writer must hold them meanwhile.
+func (c controller) writeJoinedRoom(ctx context.Context, w *connWriter, roomId, jwt string, joinedMember *service.Member) error {
+	seq, err := c.broker.GetSeq(ctx, roomId)
+	if err != nil {
+		return fmt.Errorf("failed to get seq: %w", err)
+	}
+
+	roomState, err := c.roomService.GetRoom(ctx, roomId)
+	if err != nil {
+		return fmt.Errorf("failed to get room state: %w", err)
+	}
+
+	output := &Output{
+		Seq:  seq,
+		Type: "JOINED_ROOM",
+		Payload: map[string]any{
+			"jwt":           jwt,
+			"joined_member": joinedMember,
+			"room":          roomState,
+		},
+	}
+	c.logger.DebugContext(ctx, "writing to conn", "output", output)
+	data, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+
+	return w.sendSnapshot(seq, data)
+}
+
+// writeRoomSnapshot writes room snapshot with seq of the last event included
+// in it, older events still in flight are not written after it.
+func (c controller) writeRoomSnapshot(ctx context.Context, conn *websocket.Conn, roomId string) error {
+	w, ok := c.writers.get(conn)
+	if !ok {
+		return errWriterClosed
+	}
+
+	seq, err := c.broker.GetSeq(ctx, roomId)
+	if err != nil {
+		return fmt.Errorf("failed to get seq: %w", err)
+	}
+
+	roomState, err := c.roomService.GetRoom(ctx, roomId)
+	if err != nil {
+		return fmt.Errorf("failed to get room state: %w", err)
+	}
+
+	output := &Output{
+		Seq:  seq,
+		Type: "ROOM_SNAPSHOT",
+		Payload: map[string]any{
+			"room": roomState,
+		},
+	}
+	c.logger.DebugContext(ctx, "writing to conn", "output", output)
+	data, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+
+	return w.sendSnapshot(seq, data)
+}
+
+// resumeMember replays room events sent to member after lastSeq. False is
+// returned if they are no longer available and snapshot must be sent instead.
+func (c controller) resumeMember(ctx context.Context, conn *websocket.Conn, w *connWriter, roomId string, joinRoomResp *service.JoinRoomResponse, lastSeq int) (bool, error) {
+	events, ok, err := c.broker.GetEventsAfter(ctx, roomId, lastSeq)
+	if err != nil {
+		return false, fmt.Errorf("failed to get events: %w", err)
+	}
+
+	if !ok {
+		return false, nil
+	}
+
+	if err := c.writeToConn(ctx, conn, &Output{
+		Seq:  lastSeq,
+		Type: "ROOM_RESUMED",
+		Payload: map[string]any{
+			"jwt":           joinRoomResp.JWT,
+			"joined_member": joinRoomResp.JoinedMember,
+		},
+	}); err != nil {
+		return false, fmt.Errorf("failed to write to conn: %w", err)
+	}
+
+	missed := make([]event, 0, len(events))
+	for _, e := range events {
+		if slices.Contains(e.MemberIds, joinRoomResp.JoinedMember.Id) {
+			missed = append(missed, event{
+				seq:  e.Seq,
+				data: e.Payload,
+			})
+		}
+	}
+
+	return true, w.release(missed)
 }
 
 func (c controller) generateTimeBasedId() string {
 	return fmt.Sprintf("%d-%s", time.Now().Unix(), uuid.NewString())
 }
 
-func (c controller) broadcastMemberUpdated(ctx context.Context, conns []*websocket.Conn, updatedMember *service.Member, members []service.Member) error {
-	return c.broadcast(ctx, conns, &Output{
+func (c controller) broadcastMemberUpdated(ctx context.Context, roomId string, memberIds []string, updatedMember *service.Member, members []service.Member, membersVersion int) error {
+	return c.broadcast(ctx, roomId, memberIds, &Output{
 		Type: "MEMBER_UPDATED",
 		Payload: map[string]any{
-			"updated_member": updatedMember,
-			"members":        members,
+			"updated_member":  updatedMember,
+			"members_version": membersVersion,
+		},
+		Legacy: &Output{
+			Type: "MEMBER_UPDATED",
+			Payload: map[string]any{
+				"updated_member": updatedMember,
+				"members":        members,
+			},
+		},
+	})
+}
+
+// broadcastMemberDisconnected announces member left or removed from the room.
+// promotedMemberId is set if the only member left was promoted to admin.
+func (c controller) broadcastMemberDisconnected(ctx context.Context, roomId string, memberIds []string, disconnectedMemberId string, promotedMemberId *string, members []service.Member, membersVersion int) error {
+	return c.broadcast(ctx, roomId, memberIds, &Output{
+		Type: "MEMBER_DISCONNECTED",
+		Payload: map[string]any{
+			"disconnected_member_id": disconnectedMemberId,
+			"promoted_member_id":     promotedMemberId,
+			"members_version":        membersVersion,
+		},
+		Legacy: &Output{
+			Type: "MEMBER_DISCONNECTED",
+			Payload: map[string]any{
+				"disconnected_member_id": disconnectedMemberId,
+				"members":                members,
+			},
 		},
 	})
 }
 
-func (c controller) broadcastPlayerStateUpdated(ctx context.Context, conns []*websocket.Conn, player *service.Player) error {
-	return c.broadcast(ctx, conns, &Output{
+func (c controller) broadcastPlayerStateUpdated(ctx context.Context, roomId string, memberIds []string, player *service.Player) error {
+	return c.broadcast(ctx, roomId, memberIds, &Output{
 		Type: "PLAYER_STATE_UPDATED",
 		Payload: map[string]any{
 			"player": player,
@@ -106,38 +333,192 @@ func (c controller) broadcastPlayerStateUpdated(ctx context.Context, conns []*we
 	})
 }
 
-func (c controller) broadcastPlayerVideoUpdated(ctx context.Context, conns []*websocket.Conn, player *service.Player, playlist *service.Playlist, members []service.Member) error {
-	return c.broadcast(ctx, conns, &Output{
+// broadcastPlayerVideoUpdated announces switched current video. Delta clients
+// remove it from playlist and reset ready state of every member.
+func (c controller) broadcastPlayerVideoUpdated(ctx context.Context, roomId string, memberIds []string, resp *service.PlayerVideoUpdatedResponse) error {
+	return c.broadcast(ctx, roomId, memberIds, &Output{
 		Type: "PLAYER_VIDEO_UPDATED",
 		Payload: map[string]any{
-			"player":   player,
-			"playlist": playlist,
-			"members":  members,
+			"player":           resp.Player,
+			"current_video":    resp.Playlist.CurrentVideo,
+			"last_video":       resp.Playlist.LastVideo,
+			"playlist_version": resp.Playlist.Version,
+			"members_version":  resp.MembersVersion,
+		},
+		Legacy: &Output{
+			Type: "PLAYER_VIDEO_UPDATED",
+			Payload: map[string]any{
+				"player":   resp.Player,
+				"playlist": resp.Playlist,
+				"members":  resp.Members,
+			},
 		},
 	})
 }
 
-func (c controller) broadcastPlaylistReordered(ctx context.Context, conns []*websocket.Conn, playlist *service.Playlist) error {
-	return c.broadcast(ctx, conns, &Output{
+// writePlayerConflict writes authoritative player to sender of message
+// rejected because of outdated player version. Conflict is the reply to the
+// message, so no error is returned.
+func (c controller) writePlayerConflict(ctx context.Context, conn *websocket.Conn, player *service.Player) error {
+	if err := c.writeToConn(ctx, conn, &Output{
+		Type: "PLAYER_CONFLICT",
+		Payload: map[string]any{
+			"request_id": c.getWSRequestIdFromCtx(ctx),
+			"player":     player,
+		},
+		Legacy: &Output{
+			Type: "PLAYER_STATE_UPDATED",
+			Payload: map[string]any{
+				"player": player,
+			},
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to write player conflict: %w", err)
+	}
+
+	return nil
+}
+
+// writePlaylistConflict writes authoritative playlist to sender of message
+// rejected because of outdated playlist version. Conflict is the reply to the
+// message, so no error is returned.
+func (c controller) writePlaylistConflict(ctx context.Context, conn *websocket.Conn, playlist *service.Playlist) error {
+	if err := c.writeToConn(ctx, conn, &Output{
+		Type: "PLAYLIST_CONFLICT",
+		Payload: map[string]any{
+			"request_id": c.getWSRequestIdFromCtx(ctx),
+			"playlist":   playlist,
+		},
+		Legacy: &Output{
+			Type: "PLAYLIST_REORDERED",
+			Payload: map[string]any{
+				"playlist": playlist,
+			},
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to write playlist conflict: %w", err)
+	}
+
+	return nil
+}
+
+// broadcastPlaylistReordered announces reordered playlist. Delta clients
+// receive single moved video if possible, otherwise new order of video ids.
+func (c controller) broadcastPlaylistReordered(ctx context.Context, roomId string, memberIds []string, resp *service.PlaylistReorderedResponse) error {
+	legacy := &Output{
 		Type: "PLAYLIST_REORDERED",
 		Payload: map[string]any{
-			"playlist": playlist,
+			"playlist": resp.Playlist,
 		},
+	}
+
+	if resp.MovedVideoId != nil {
+		return c.broadcast(ctx, roomId, memberIds, &Output{
+			Type: "VIDEO_MOVED",
+			Payload: map[string]any{
+				"video_id":         *resp.MovedVideoId,
+				"index":            resp.MovedVideoIndex,
+				"playlist_version": resp.Playlist.Version,
+			},
+			Legacy: legacy,
+		})
+	}
+
+	videoIds := make([]int, 0, len(resp.Playlist.Videos))
+	for _, video := range resp.Playlist.Videos {
+		videoIds = append(videoIds, video.Id)
+	}
+
+	return c.broadcast(ctx, roomId, memberIds, &Output{
+		Type: "PLAYLIST_REORDERED",
+		Payload: map[string]any{
+			"video_ids":        videoIds,
+			"playlist_version": resp.Playlist.Version,
+		},
+		Legacy: legacy,
 	})
 }
 
-func (c controller) helperDisconn(ctx context.Context, roomId string, memberId string) error {
+// closeReplacedConn closes previous local conn of reconnected member, its
+// cleanup does not disconnect member, see helperDisconn.
+func (c controller) closeReplacedConn(conn *websocket.Conn) {
+	if conn == nil {
+		return
+	}
+
+	if w, ok := c.writers.get(conn); ok {
+		w.replace()
+	}
+}
+
+// helperDisconn disconnects member after reconnect grace period, unless
+// member joins again before it ends. Nothing is done if member has already
+// joined with newer conn than closed connId.
+func (c controller) helperDisconn(ctx context.Context, roomId, memberId, connId string) error {
+	var suspendMemberResp *service.SuspendMemberResponse
+	if err := c.executor.Do(ctx, roomId, func(ctx context.Context) error {
+		var err error
+		suspendMemberResp, err = c.roomService.SuspendMember(ctx, &service.SuspendMemberParams{
+			MemberId: memberId,
+			RoomId:   roomId,
+			ConnId:   connId,
+		})
+		if err != nil {
+			return err
+		}
+
+		if suspendMemberResp.Player != nil {
+			if err := c.broadcastPlayerStateUpdated(ctx, roomId, suspendMemberResp.MemberIds, suspendMemberResp.Player); err != nil {
+				return fmt.Errorf("failed to broadcast player state updated: %w", err)
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to suspend member: %w", err)
+	}
+
+	if suspendMemberResp.IsMemberReconnected {
+		return nil
+	}
+
+	disconnect := func(ctx context.Context) error {
+		return c.disconnectMember(ctx, roomId, memberId, connId, suspendMemberResp.Token)
+	}
+
+	if suspendMemberResp.GracePeriod == 0 {
+		return c.executor.Do(ctx, roomId, disconnect)
+	}
+
+	// request ctx is canceled once conn handler returns
+	ctx = context.WithoutCancel(ctx)
+	time.AfterFunc(suspendMemberResp.GracePeriod, func() {
+		if err := c.executor.Do(ctx, roomId, disconnect); err != nil {
+			c.logger.ErrorContext(ctx, "failed to disconnect member after grace period", "error", err)
+		}
+	})
+
+	return nil
+}
+
+func (c controller) disconnectMember(ctx context.Context, roomId, memberId, connId, token string) error {
 	disconnectMemberResp, err := c.roomService.DisconnectMember(ctx, &service.DisconnectMemberParams{
 		MemberId: memberId,
 		RoomId:   roomId,
+		ConnId:   connId,
+		Token:    token,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to disconnect member: %w", err)
 	}
 
+	if disconnectMemberResp.IsMemberReconnected {
+		return nil
+	}
+
 	if !disconnectMemberResp.IsRoomDeleted {
-		if disconnectMemberResp.PromotedMemberConn != nil {
-			if err := c.writeToConn(ctx, disconnectMemberResp.PromotedMemberConn, &Output{
+		if disconnectMemberResp.PromotedMemberId != "" {
+			if err := c.writeToMember(ctx, roomId, disconnectMemberResp.PromotedMemberId, &Output{
 				Type: "IS_ADMIN_UPDATED",
 				Payload: map[string]any{
 					//?
@@ -147,14 +528,93 @@ func (c controller) helperDisconn(ctx context.Context, roomId string, memberId s
 				return fmt.Errorf("failed to write to conn: %w", err)
 			}
 		}
-		if err := c.broadcast(ctx, disconnectMemberResp.Conns, &Output{
-			Type: "MEMBER_DISCONNECTED",
+		var promotedMemberId *string
+		if disconnectMemberResp.PromotedMemberId != "" {
+			promotedMemberId = &disconnectMemberResp.PromotedMemberId
+		}
+
+		if err := c.broadcastMemberDisconnected(ctx, roomId, disconnectMemberResp.MemberIds, memberId, promotedMemberId, disconnectMemberResp.Members, disconnectMemberResp.MembersVersion); err != nil {
+			return fmt.Errorf("failed to broadcast member disconnected: %w", err)
+		}
+
+		if disconnectMemberResp.Player != nil {
+			if err := c.broadcastPlayerStateUpdated(ctx, roomId, disconnectMemberResp.MemberIds, disconnectMemberResp.Player); err != nil {
+				return fmt.Errorf("failed to broadcast player state updated: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeAddVideoResponse writes conflict to sender or broadcasts video added to
+// room.
+func (c controller) writeAddVideoResponse(ctx context.Context, conn *websocket.Conn, roomId string, addVideoResp *service.AddVideoResponse) error {
+	switch {
+	case addVideoResp.PlaylistVersionMismatchResponse != nil:
+		return c.writePlaylistConflict(ctx, conn, &addVideoResp.PlaylistVersionMismatchResponse.Playlist)
+
+	case addVideoResp.PlayerVersionMismatchResponse != nil:
+		return c.writePlayerConflict(ctx, conn, &addVideoResp.PlayerVersionMismatchResponse.Player)
+
+	case addVideoResp.VideoAddedResponse != nil:
+		videoAddedResp := addVideoResp.VideoAddedResponse
+		index := slices.IndexFunc(videoAddedResp.Playlist.Videos, func(v service.Video) bool {
+			return v.Id == videoAddedResp.AddedVideo.Id
+		})
+		if err := c.broadcast(ctx, roomId, addVideoResp.MemberIds, &Output{
+			Type: "VIDEO_ADDED",
 			Payload: map[string]any{
-				"disconnected_member_id": memberId,
-				"members":                disconnectMemberResp.Members,
+				"added_video":      videoAddedResp.AddedVideo,
+				"index":            index,
+				"playlist_version": videoAddedResp.Playlist.Version,
+			},
+			Legacy: &Output{
+				Type: "VIDEO_ADDED",
+				Payload: map[string]any{
+					"added_video": videoAddedResp.AddedVideo,
+					"playlist":    videoAddedResp.Playlist,
+				},
 			},
 		}); err != nil {
-			return fmt.Errorf("failed to broadcast member disconnected: %w", err)
+			return fmt.Errorf("failed to broadcast video added: %w", err)
+		}
+
+	case addVideoResp.PlayerVideoUpdatedResponse != nil:
+		if err := c.broadcastPlayerVideoUpdated(ctx, roomId, addVideoResp.MemberIds, addVideoResp.PlayerVideoUpdatedResponse); err != nil {
+			return fmt.Errorf("failed to broadcast player updated: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c controller) writeImportPlaylistResponse(ctx context.Context, conn *websocket.Conn, roomId string, importPlaylistResp *service.ImportPlaylistResponse) error {
+	switch {
+	case importPlaylistResp.PlaylistVersionMismatchResponse != nil:
+		return c.writePlaylistConflict(ctx, conn, &importPlaylistResp.PlaylistVersionMismatchResponse.Playlist)
+
+	case importPlaylistResp.PlaylistImportedResponse != nil:
+		playlistImportedResp := importPlaylistResp.PlaylistImportedResponse
+		if err := c.broadcast(ctx, roomId, importPlaylistResp.MemberIds, &Output{
+			Type: "VIDEOS_ADDED",
+			Payload: map[string]any{
+				"added_videos":     playlistImportedResp.AddedVideos,
+				"playlist_version": playlistImportedResp.Playlist.Version,
+			},
+			Legacy: &Output{
+				Type: "PLAYLIST_REORDERED",
+				Payload: map[string]any{
+					"playlist": playlistImportedResp.Playlist,
+				},
+			},
+		}); err != nil {
+			return fmt.Errorf("failed to broadcast videos added: %w", err)
+		}
+
+	case importPlaylistResp.PlayerVideoUpdatedResponse != nil:
+		if err := c.broadcastPlayerVideoUpdated(ctx, roomId, importPlaylistResp.MemberIds, importPlaylistResp.PlayerVideoUpdatedResponse); err != nil {
+			return fmt.Errorf("failed to broadcast player updated: %w", err)
 		}
 	}
 
@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sharetube/server/pkg/wsrouter"
+)
+
+const (
+	subprotocolPrefix        = "sharetube.v"
+	msgpackSubprotocolSuffix = ".msgpack"
+	// version assumed for clients which do not negotiate it
+	legacyProtocolVersion = 1
+	// clients using older version are closed with 4003
+	minProtocolVersion = 1
+	maxProtocolVersion = 2
+	// first version receiving delta playlist and member events, older
+	// clients receive snapshots, see Output.Legacy
+	deltaProtocolVersion = 2
+)
+
+var (
+	errProtocolVersionTooOld       = errors.New("protocol version is too old")
+	errProtocolVersionNotSupported = errors.New("protocol version is not supported")
+)
+
+// protocol is negotiated with client on handshake.
+type protocol struct {
+	version int
+	codec   wsrouter.Codec
+	// subprotocol to respond with, empty if client did not offer any
+	subprotocol string
+}
+
+// parseSubprotocol parses sharetube.v<version>[.msgpack] subprotocol.
+func (c controller) parseSubprotocol(subprotocol string) (*protocol, bool) {
+	value, ok := strings.CutPrefix(subprotocol, subprotocolPrefix)
+	if !ok {
+		return nil, false
+	}
+
+	codec := wsrouter.JSONCodec
+	if v, ok := strings.CutSuffix(value, msgpackSubprotocolSuffix); ok {
+		value = v
+		codec = wsrouter.MsgpackCodec
+	}
+
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, false
+	}
+
+	return &protocol{
+		version:     version,
+		codec:       codec,
+		subprotocol: subprotocol,
+	}, true
+}
+
+// negotiateProtocol returns the latest protocol version supported by both
+// server and client, preferring codec offered first. Client offers protocols
+// with Sec-WebSocket-Protocol header or protocol-version query param.
+// Returned protocol is set even on error, so conn can be upgraded to report
+// the error with close code.
+func (c controller) negotiateProtocol(r *http.Request) (*protocol, error) {
+	offered := make([]*protocol, 0)
+	for _, s := range websocket.Subprotocols(r) {
+		if p, ok := c.parseSubprotocol(s); ok {
+			offered = append(offered, p)
+		}
+	}
+
+	if len(offered) == 0 {
+		version, err := c.getOptIntQueryParam(r, "protocol-version")
+		if err != nil {
+			return &protocol{
+				version:     0,
+				codec:       wsrouter.JSONCodec,
+				subprotocol: "",
+			}, errProtocolVersionNotSupported
+		}
+
+		p := protocol{
+			version:     legacyProtocolVersion,
+			codec:       wsrouter.JSONCodec,
+			subprotocol: "",
+		}
+		if version != nil {
+			p.version = *version
+		}
+		offered = append(offered, &p)
+	}
+
+	var negotiated *protocol
+	isTooOld := false
+	for _, p := range offered {
+		switch {
+		case p.version < minProtocolVersion:
+			isTooOld = true
+		case p.version <= maxProtocolVersion && (negotiated == nil || p.version > negotiated.version):
+			negotiated = p
+		}
+	}
+
+	switch {
+	case negotiated != nil:
+		return negotiated, nil
+	case isTooOld:
+		return offered[0], errProtocolVersionTooOld
+	default:
+		return offered[0], errProtocolVersionNotSupported
+	}
+}
+
+// upgrade upgrades conn responding with subprotocol, if it is not empty.
+func (c controller) upgrade(w http.ResponseWriter, r *http.Request, subprotocol string) (*websocket.Conn, error) {
+	var header http.Header
+	if subprotocol != "" {
+		header = http.Header{
+			"Sec-WebSocket-Protocol": []string{subprotocol},
+		}
+	}
+
+	return c.upgrader.Upgrade(w, r, header)
+}
+
+// rejectProtocolVersion upgrades conn and closes it with close code, since
+// browsers do not expose handshake response to client.
+func (c controller) rejectProtocolVersion(w http.ResponseWriter, r *http.Request, subprotocol string, err error) {
+	conn, upgradeErr := c.upgrade(w, r, subprotocol)
+	if upgradeErr != nil {
+		c.logger.ErrorContext(r.Context(), "failed to upgrade to websocket", "error", upgradeErr)
+		return
+	}
+	defer conn.Close()
+
+	code := 4004
+	if errors.Is(err, errProtocolVersionTooOld) {
+		code = 4003
+	}
+
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, err.Error()), time.Now().Add(closeTimeout))
+}
+
+// renderedOutput is Output as it is published, with payloads already encoded.
+type renderedOutput struct {
+	Seq     int             `json:"seq,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	Legacy  *renderedOutput `json:"legacy,omitempty"`
+}
+
+// renderOutput picks output rendering for protocol version negotiated with
+// conn, see Output.Legacy, and sets seq of room event, which is assigned by
+// broker after output was marshaled. Legacy rendering is looked up in decoded
+// message, so payloads mentioning it are not mistaken for it. Messages without
+// legacy rendering and seq to set are returned as is.
+func renderOutput(version, seq int, data []byte) ([]byte, error) {
+	var output renderedOutput
+	if err := json.Unmarshal(data, &output); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal output: %w", err)
+	}
+
+	if output.Legacy == nil && (seq == 0 || output.Seq == seq) {
+		return data, nil
+	}
+
+	if seq != 0 {
+		output.Seq = seq
+	}
+
+	if output.Legacy != nil {
+		if version < deltaProtocolVersion {
+			output.Type = output.Legacy.Type
+			output.Payload = output.Legacy.Payload
+		}
+		output.Legacy = nil
+	}
+
+	return json.Marshal(output)
+}
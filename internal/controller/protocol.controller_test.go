@@ -0,0 +1,287 @@
+package controller
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/sharetube/server/pkg/wsrouter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestController() controller {
+	return controller{
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		},
+		logger: slog.Default(),
+	}
+}
+
+func TestNegotiateProtocol(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		subprotocols string
+		query        string
+		version      int
+		codec        wsrouter.Codec
+		subprotocol  string
+		err          error
+	}{
+		{
+			name:    "legacy client",
+			version: legacyProtocolVersion,
+			codec:   wsrouter.JSONCodec,
+		},
+		{
+			name:    "query param",
+			query:   "protocol-version=2",
+			version: 2,
+			codec:   wsrouter.JSONCodec,
+		},
+		{
+			name:    "query param too old",
+			query:   "protocol-version=0",
+			version: 0,
+			codec:   wsrouter.JSONCodec,
+			err:     errProtocolVersionTooOld,
+		},
+		{
+			name:    "query param not supported",
+			query:   "protocol-version=3",
+			version: 3,
+			codec:   wsrouter.JSONCodec,
+			err:     errProtocolVersionNotSupported,
+		},
+		{
+			name:    "query param not a number",
+			query:   "protocol-version=latest",
+			version: 0,
+			codec:   wsrouter.JSONCodec,
+			err:     errProtocolVersionNotSupported,
+		},
+		{
+			name:         "subprotocol",
+			subprotocols: "sharetube.v2",
+			version:      2,
+			codec:        wsrouter.JSONCodec,
+			subprotocol:  "sharetube.v2",
+		},
+		{
+			name:         "msgpack subprotocol",
+			subprotocols: "sharetube.v2.msgpack",
+			version:      2,
+			codec:        wsrouter.MsgpackCodec,
+			subprotocol:  "sharetube.v2.msgpack",
+		},
+		{
+			name:         "latest supported version",
+			subprotocols: "sharetube.v1, sharetube.v3, sharetube.v2",
+			version:      2,
+			codec:        wsrouter.JSONCodec,
+			subprotocol:  "sharetube.v2",
+		},
+		{
+			name:         "codec offered first",
+			subprotocols: "sharetube.v2.msgpack, sharetube.v2",
+			version:      2,
+			codec:        wsrouter.MsgpackCodec,
+			subprotocol:  "sharetube.v2.msgpack",
+		},
+		{
+			name:         "subprotocol is preferred over query param",
+			subprotocols: "sharetube.v1",
+			query:        "protocol-version=2",
+			version:      1,
+			codec:        wsrouter.JSONCodec,
+			subprotocol:  "sharetube.v1",
+		},
+		{
+			name:         "unknown subprotocols are ignored",
+			subprotocols: "chat, sharetube.vx, sharetube.v2",
+			version:      2,
+			codec:        wsrouter.JSONCodec,
+			subprotocol:  "sharetube.v2",
+		},
+		{
+			name:         "subprotocol too old",
+			subprotocols: "sharetube.v0.msgpack, sharetube.v3",
+			version:      0,
+			codec:        wsrouter.MsgpackCodec,
+			subprotocol:  "sharetube.v0.msgpack",
+			err:          errProtocolVersionTooOld,
+		},
+		{
+			name:         "subprotocol not supported",
+			subprotocols: "sharetube.v3",
+			version:      3,
+			codec:        wsrouter.JSONCodec,
+			subprotocol:  "sharetube.v3",
+			err:          errProtocolVersionNotSupported,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ws?"+tc.query, nil)
+			if tc.subprotocols != "" {
+				r.Header.Set("Sec-WebSocket-Protocol", tc.subprotocols)
+			}
+
+			p, err := newTestController().negotiateProtocol(r)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			// protocol is returned on error too, to report it with close code
+			require.NotNil(t, p)
+			assert.Equal(t, tc.version, p.version)
+			assert.Equal(t, tc.codec, p.codec)
+			assert.Equal(t, tc.subprotocol, p.subprotocol)
+		})
+	}
+}
+
+func TestRejectProtocolVersion(t *testing.T) {
+	c := newTestController()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := c.negotiateProtocol(r)
+		if err != nil {
+			c.rejectProtocolVersion(w, r, p.subprotocol, err)
+			return
+		}
+
+		conn, err := c.upgrade(w, r, p.subprotocol)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteMessage(websocket.TextMessage, []byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	for _, tc := range []struct {
+		name         string
+		subprotocols []string
+		query        string
+		// zero if conn is accepted
+		closeCode int
+	}{
+		{
+			name:         "accepted",
+			subprotocols: []string{"sharetube.v2"},
+			closeCode:    0,
+		},
+		{
+			name:         "too old",
+			subprotocols: []string{"sharetube.v0"},
+			closeCode:    4003,
+		},
+		{
+			name:      "query param too old",
+			query:     "?protocol-version=0",
+			closeCode: 4003,
+		},
+		{
+			name:         "not supported",
+			subprotocols: []string{"sharetube.v3.msgpack"},
+			closeCode:    4004,
+		},
+		{
+			name:      "query param not supported",
+			query:     "?protocol-version=3",
+			closeCode: 4004,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dialer := websocket.Dialer{
+				Subprotocols: tc.subprotocols,
+			}
+			conn, resp, err := dialer.Dial(wsUrl+tc.query, nil)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			// offered subprotocol is echoed, so browser accepts handshake and
+			// receives close code
+			if len(tc.subprotocols) > 0 {
+				assert.Equal(t, tc.subprotocols[0], resp.Header.Get("Sec-WebSocket-Protocol"))
+			}
+
+			_, data, err := conn.ReadMessage()
+			if tc.closeCode == 0 {
+				require.NoError(t, err)
+				assert.Equal(t, "ok", string(data))
+				return
+			}
+
+			var closeErr *websocket.CloseError
+			require.ErrorAs(t, err, &closeErr)
+			assert.Equal(t, tc.closeCode, closeErr.Code)
+		})
+	}
+}
+
+func TestRenderOutput(t *testing.T) {
+	withLegacy := []byte(`{"seq":7,"type":"VIDEO_ADDED","payload":{"index":0},"legacy":{"type":"VIDEO_ADDED","payload":{"playlist":[]}}}`)
+	// title mentions legacy key, but message has no legacy rendering
+	withoutLegacy := []byte(`{"type":"PLAYER_VIDEO_UPDATED","payload":{"title":"\"legacy\":{}"}}`)
+
+	for _, tc := range []struct {
+		name     string
+		version  int
+		seq      int
+		data     []byte
+		expected string
+	}{
+		{
+			name:     "legacy version",
+			version:  legacyProtocolVersion,
+			seq:      7,
+			data:     withLegacy,
+			expected: `{"seq":7,"type":"VIDEO_ADDED","payload":{"playlist":[]}}`,
+		},
+		{
+			name:     "delta version",
+			version:  deltaProtocolVersion,
+			seq:      7,
+			data:     withLegacy,
+			expected: `{"seq":7,"type":"VIDEO_ADDED","payload":{"index":0}}`,
+		},
+		{
+			name:     "legacy version without legacy rendering",
+			version:  legacyProtocolVersion,
+			seq:      0,
+			data:     withoutLegacy,
+			expected: string(withoutLegacy),
+		},
+		{
+			name:     "delta version without legacy rendering",
+			version:  deltaProtocolVersion,
+			seq:      0,
+			data:     withoutLegacy,
+			expected: string(withoutLegacy),
+		},
+		{
+			name:     "seq of event",
+			version:  deltaProtocolVersion,
+			seq:      3,
+			data:     []byte(`{"type":"MEMBER_JOINED","payload":{}}`),
+			expected: `{"seq":3,"type":"MEMBER_JOINED","payload":{}}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := renderOutput(tc.version, tc.seq, tc.data)
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, string(data))
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (c controller) getRoom(w http.ResponseWriter, r *http.Request) {
+	room, err := c.roomService.GetRoom(r.Context(), chi.URLParam(r, "room-id"))
+	if err != nil {
+		c.writeServiceError(w, r, err)
+		return
+	}
+
+	c.writeJSON(w, http.StatusOK, room)
+}
+
+func (c controller) getRoomExists(w http.ResponseWriter, r *http.Request) {
+	exists, err := c.roomService.IsRoomExists(r.Context(), chi.URLParam(r, "room-id"))
+	if err != nil {
+		c.writeServiceError(w, r, err)
+		return
+	}
+
+	c.writeJSON(w, http.StatusOK, map[string]any{
+		"exists": exists,
+	})
+}
+
+func (c controller) getRoomMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := c.roomService.GetMembers(r.Context(), chi.URLParam(r, "room-id"))
+	if err != nil {
+		c.writeServiceError(w, r, err)
+		return
+	}
+
+	c.writeJSON(w, http.StatusOK, map[string]any{
+		"members": members,
+	})
+}
+
+func (c controller) getRoomPlaylist(w http.ResponseWriter, r *http.Request) {
+	playlist, err := c.roomService.GetPlaylist(r.Context(), chi.URLParam(r, "room-id"))
+	if err != nil {
+		c.writeServiceError(w, r, err)
+		return
+	}
+
+	c.writeJSON(w, http.StatusOK, playlist)
+}
@@ -21,6 +21,12 @@ func (c controller) GetMux() http.Handler {
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte("OK"))
 		})
+		r.Route("/rooms/{room-id}", func(r chi.Router) {
+			r.Get("/", c.getRoom)
+			r.Get("/exists", c.getRoomExists)
+			r.Get("/members", c.getRoomMembers)
+			r.Get("/playlist", c.getRoomPlaylist)
+		})
 		r.Route("/ws", func(r chi.Router) {
 			r.Route("/room", func(r chi.Router) {
 				r.Get("/create", c.createRoom)
@@ -0,0 +1,298 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sharetube/server/pkg/wsrouter"
+)
+
+var (
+	errWriterClosed   = errors.New("conn writer closed")
+	errWriteQueueFull = errors.New("conn write queue is full")
+)
+
+const (
+	closeTimeout = time.Second
+	// used if configured queue size is not positive, unbuffered queue would
+	// evict member on the first message
+	defaultWriteQueueSize = 64
+)
+
+type writeRequest struct {
+	messageType int
+	data        []byte
+}
+
+type connWriterConfig struct {
+	queueSize    int
+	writeTimeout time.Duration
+	pingInterval time.Duration
+	// used only if compression was negotiated with client
+	compressionLevel     int
+	compressionThreshold int
+	logger               *slog.Logger
+}
+
+type event struct {
+	seq  int
+	data []byte
+}
+
+// connWriter is the only goroutine writing to its conn, gorilla/websocket
+// does not support concurrent writers. Messages are queued without blocking
+// the sender, member is evicted if its queue overflows.
+type connWriter struct {
+	conn            *websocket.Conn
+	codec           wsrouter.Codec
+	protocolVersion int
+	writeTimeout    time.Duration
+	pingInterval    time.Duration
+	// messages smaller than it are sent uncompressed
+	compressionThreshold int
+	requests             chan writeRequest
+	closed               chan struct{}
+	once                 sync.Once
+	// room events ordering, see sendEvent
+	eventsMu sync.Mutex
+	lastSeq  int
+	holding  bool
+	held     []event
+}
+
+func newConnWriter(conn *websocket.Conn, protocol *protocol, cfg *connWriterConfig) *connWriter {
+	// gorilla rejects invalid level and keeps default one, level is validated
+	// with app config, so error means misconfigured controller
+	if err := conn.SetCompressionLevel(cfg.compressionLevel); err != nil {
+		cfg.logger.Warn("failed to set compression level", "compression_level", cfg.compressionLevel, "error", err)
+	}
+
+	queueSize := cfg.queueSize
+	if queueSize <= 0 {
+		queueSize = defaultWriteQueueSize
+	}
+
+	w := &connWriter{
+		conn:                 conn,
+		codec:                protocol.codec,
+		protocolVersion:      protocol.version,
+		writeTimeout:         cfg.writeTimeout,
+		pingInterval:         cfg.pingInterval,
+		compressionThreshold: cfg.compressionThreshold,
+		requests:             make(chan writeRequest, queueSize),
+		closed:               make(chan struct{}),
+		once:                 sync.Once{},
+		eventsMu:             sync.Mutex{},
+		lastSeq:              0,
+		holding:              false,
+		held:                 nil,
+	}
+	go w.run()
+
+	return w
+}
+
+func (w *connWriter) run() {
+	pingTicker := time.NewTicker(w.pingInterval)
+	defer pingTicker.Stop()
+
+	for {
+		select {
+		case <-w.closed:
+			return
+		case <-pingTicker.C:
+			// peer answers with pong which extends read deadline, see wsrouter.SetReadTimeout
+			if err := w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(w.writeTimeout)); err != nil {
+				w.close()
+				w.conn.Close()
+				return
+			}
+		case req := <-w.requests:
+			if err := w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout)); err != nil {
+				w.close()
+				w.conn.Close()
+				return
+			}
+
+			// no-op if compression was not negotiated
+			w.conn.EnableWriteCompression(len(req.data) >= w.compressionThreshold)
+			if err := w.conn.WriteMessage(req.messageType, req.data); err != nil {
+				// stalled or broken conn, closing it unblocks reader
+				w.close()
+				w.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// send queues message to be written. If queue is full conn is closed with
+// slow client close code.
+func (w *connWriter) send(messageType int, data []byte) error {
+	select {
+	case <-w.closed:
+		return errWriterClosed
+	default:
+	}
+
+	select {
+	case w.requests <- writeRequest{
+		messageType: messageType,
+		data:        data,
+	}:
+		return nil
+	default:
+		w.evict()
+		return errWriteQueueFull
+	}
+}
+
+// sendMessage renders JSON message for conn protocol version, encodes it with
+// conn codec and queues it. Seq of room event is added to message, zero seq
+// keeps message as is.
+func (w *connWriter) sendMessage(seq int, data []byte) error {
+	data, err := renderOutput(w.protocolVersion, seq, data)
+	if err != nil {
+		return fmt.Errorf("failed to render message: %w", err)
+	}
+
+	data, err = w.codec.Encode(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	return w.send(w.codec.MessageType(), data)
+}
+
+// sendEvent sends room event unless event with greater or equal seq was
+// already sent. Events are buffered while writer holds them, see hold.
+func (w *connWriter) sendEvent(seq int, data []byte) error {
+	w.eventsMu.Lock()
+	defer w.eventsMu.Unlock()
+
+	if w.holding {
+		w.held = append(w.held, event{
+			seq:  seq,
+			data: data,
+		})
+		return nil
+	}
+
+	return w.sendEventLocked(seq, data)
+}
+
+func (w *connWriter) sendEventLocked(seq int, data []byte) error {
+	if seq <= w.lastSeq {
+		return nil
+	}
+	w.lastSeq = seq
+
+	return w.sendMessage(seq, data)
+}
+
+// sendSnapshot sends room snapshot including events up to seq. Unlike
+// sendEvent it is sent even if event with seq was already sent, but events
+// it includes which are still in flight are dropped.
+func (w *connWriter) sendSnapshot(seq int, data []byte) error {
+	w.eventsMu.Lock()
+	defer w.eventsMu.Unlock()
+
+	w.lastSeq = max(w.lastSeq, seq)
+
+	return w.sendMessage(seq, data)
+}
+
+// hold buffers live room events until release, so missed events can be
+// replayed before them.
+func (w *connWriter) hold() {
+	w.eventsMu.Lock()
+	defer w.eventsMu.Unlock()
+
+	w.holding = true
+}
+
+// release sends missed events followed by held live ones.
+func (w *connWriter) release(missed []event) error {
+	w.eventsMu.Lock()
+	defer w.eventsMu.Unlock()
+
+	w.holding = false
+	held := w.held
+	w.held = nil
+
+	for _, e := range append(missed, held...) {
+		if err := w.sendEventLocked(e.seq, e.data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *connWriter) evict() {
+	w.closeWithCode(4002, "too slow")
+}
+
+// replace closes conn replaced by newer conn of the same member.
+func (w *connWriter) replace() {
+	w.closeWithCode(4005, "replaced by new connection")
+}
+
+func (w *connWriter) closeWithCode(code int, text string) {
+	w.close()
+	// WriteControl is allowed concurrently with other writes
+	w.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(closeTimeout))
+	w.conn.Close()
+}
+
+func (w *connWriter) close() {
+	w.once.Do(func() {
+		close(w.closed)
+	})
+}
+
+type connWriters struct {
+	writers map[*websocket.Conn]*connWriter
+	cfg     *connWriterConfig
+	mu      sync.RWMutex
+}
+
+func newConnWriters(cfg *connWriterConfig) *connWriters {
+	return &connWriters{
+		writers: make(map[*websocket.Conn]*connWriter),
+		cfg:     cfg,
+		mu:      sync.RWMutex{},
+	}
+}
+
+func (cw *connWriters) add(conn *websocket.Conn, protocol *protocol) *connWriter {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	w := newConnWriter(conn, protocol, cw.cfg)
+	cw.writers[conn] = w
+
+	return w
+}
+
+func (cw *connWriters) get(conn *websocket.Conn) (*connWriter, bool) {
+	cw.mu.RLock()
+	defer cw.mu.RUnlock()
+
+	w, ok := cw.writers[conn]
+	return w, ok
+}
+
+func (cw *connWriters) remove(conn *websocket.Conn) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	if w, ok := cw.writers[conn]; ok {
+		w.close()
+		delete(cw.writers, conn)
+	}
+}
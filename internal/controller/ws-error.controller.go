@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sharetube/server/internal/service"
+	"github.com/sharetube/server/pkg/wsrouter"
+)
+
+const (
+	errCodePermissionDenied     = "PERMISSION_DENIED"
+	errCodePlaylistLimitReached = "PLAYLIST_LIMIT_REACHED"
+	errCodeVideoNotEmbeddable   = "VIDEO_NOT_EMBEDDABLE"
+	errCodeVersionMismatch      = "VERSION_MISMATCH"
+	errCodeValidationFailed     = "VALIDATION_FAILED"
+	errCodeInvalidMessage       = "INVALID_MESSAGE"
+	errCodeInternal             = "INTERNAL_ERROR"
+)
+
+// requestError is returned from handlers to keep id of request that failed.
+type requestError struct {
+	requestId string
+	err       error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// wsError is ERROR message payload. Internal error details are only logged.
+type wsError struct {
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Details   map[string]string `json:"details"`
+	RequestId *string           `json:"request_id"`
+}
+
+func (c controller) newWSError(ctx context.Context, err error) *wsError {
+	wsErr := wsError{
+		Code:      errCodeInternal,
+		Message:   "internal error",
+		Details:   nil,
+		RequestId: nil,
+	}
+
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		wsErr.RequestId = &reqErr.requestId
+	} else if requestId := wsrouter.GetMessageIdFromCtx(ctx); requestId != "" {
+		// failed before reaching handler middlewares
+		wsErr.RequestId = &requestId
+	}
+
+	var validationErr *service.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		wsErr.Code = errCodeValidationFailed
+		wsErr.Message = "validation failed"
+		wsErr.Details = validationErr.Fields
+	case errors.Is(err, service.ErrMemberNotFound):
+		wsErr.Code = errCodeValidationFailed
+		wsErr.Message = service.ErrMemberNotFound.Error()
+	case errors.Is(err, service.ErrMemberIsAlreadyAdmin):
+		wsErr.Code = errCodeValidationFailed
+		wsErr.Message = service.ErrMemberIsAlreadyAdmin.Error()
+	case errors.Is(err, service.ErrPermissionDenied):
+		wsErr.Code = errCodePermissionDenied
+		wsErr.Message = service.ErrPermissionDenied.Error()
+	case errors.Is(err, service.ErrPlaylistLimitReached):
+		wsErr.Code = errCodePlaylistLimitReached
+		wsErr.Message = service.ErrPlaylistLimitReached.Error()
+	case errors.Is(err, service.ErrVideoNotEmbeddable):
+		wsErr.Code = errCodeVideoNotEmbeddable
+		wsErr.Message = service.ErrVideoNotEmbeddable.Error()
+	case errors.Is(err, service.ErrVersionMismatch):
+		wsErr.Code = errCodeVersionMismatch
+		wsErr.Message = service.ErrVersionMismatch.Error()
+	case errors.Is(err, wsrouter.ErrInvalidMessage):
+		wsErr.Code = errCodeInvalidMessage
+		wsErr.Message = wsrouter.ErrInvalidMessage.Error()
+	case errors.Is(err, wsrouter.ErrHandlerNotFound):
+		wsErr.Code = errCodeInvalidMessage
+		wsErr.Message = "unknown message type"
+	}
+
+	return &wsErr
+}
+
+// newErrorOutput returns ERROR message. Clients older than deltaProtocolVersion
+// receive error message only, as it was sent before error codes.
+func newErrorOutput(wsErr *wsError) *Output {
+	return &Output{
+		Type:    "ERROR",
+		Payload: wsErr,
+		Legacy: &Output{
+			Type:    "error",
+			Payload: wsErr.Message,
+		},
+	}
+}
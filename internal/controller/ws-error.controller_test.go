@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorOutput(t *testing.T) {
+	requestId := "req-1"
+	data, err := json.Marshal(newErrorOutput(&wsError{
+		Code:      errCodeVersionMismatch,
+		Message:   "version mismatch",
+		Details:   nil,
+		RequestId: &requestId,
+	}))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		version  int
+		expected string
+	}{
+		{
+			name:     "legacy version",
+			version:  legacyProtocolVersion,
+			expected: `{"type":"error","payload":"version mismatch"}`,
+		},
+		{
+			name:     "delta version",
+			version:  deltaProtocolVersion,
+			expected: `{"type":"ERROR","payload":{"code":"VERSION_MISMATCH","message":"version mismatch","details":null,"request_id":"req-1"}}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rendered, err := renderOutput(tc.version, 0, data)
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, string(rendered))
+		})
+	}
+}
@@ -3,29 +3,209 @@ package controller
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/sharetube/server/internal/service"
+	"github.com/sharetube/server/pkg/wsrouter"
 	"github.com/skewb1k/goutils/optional"
 )
 
 type Output struct {
+	// room sequence number, set by broker for broadcasted events
+	Seq     int    `json:"seq,omitempty"`
 	Type    string `json:"type"`
 	Payload any    `json:"payload"`
+	// sent instead to clients older than deltaProtocolVersion, see renderOutput
+	Legacy *Output `json:"legacy,omitempty"`
 }
 
 func (c controller) handleAlive(_ context.Context, _ *websocket.Conn, _ EmptyInput) error {
 	return nil
 }
 
+// handleGetRoom writes room snapshot, clients request it on version gaps.
+func (c controller) handleGetRoom(ctx context.Context, conn *websocket.Conn, _ EmptyInput) error {
+	roomId := c.getRoomIdFromCtx(ctx)
+
+	if err := c.writeRoomSnapshot(ctx, conn, roomId); err != nil {
+		return fmt.Errorf("failed to write room snapshot: %w", err)
+	}
+
+	return nil
+}
+
+type SyncClockInput struct {
+	// client clock reading, echoed back as is
+	ClientTime int `json:"client_time"`
+}
+
+// handleSyncClock replies with server timestamps in microseconds, so client
+// can estimate its clock offset and round trip delay NTP-style.
+func (c controller) handleSyncClock(ctx context.Context, conn *websocket.Conn, input SyncClockInput) error {
+	if err := c.writeToConn(ctx, conn, &Output{
+		Type: "CLOCK_SYNCED",
+		Payload: map[string]any{
+			"client_time":        input.ClientTime,
+			"server_received_at": wsrouter.GetReceivedAtFromCtx(ctx).UnixMicro(),
+			"server_sent_at":     time.Now().UnixMicro(),
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to write clock synced: %w", err)
+	}
+
+	return nil
+}
+
+func (c controller) handleGetPlayerPosition(ctx context.Context, conn *websocket.Conn, _ EmptyInput) error {
+	roomId := c.getRoomIdFromCtx(ctx)
+
+	position, err := c.roomService.GetPlayerPosition(ctx, roomId)
+	if err != nil {
+		return fmt.Errorf("failed to get player position: %w", err)
+	}
+
+	if err := c.writeToConn(ctx, conn, &Output{
+		Type:    "PLAYER_POSITION",
+		Payload: position,
+	}); err != nil {
+		return fmt.Errorf("failed to write player position: %w", err)
+	}
+
+	return nil
+}
+
+type ReportPositionInput struct {
+	VideoId     int `json:"video_id"`
+	CurrentTime int `json:"current_time"`
+	// optional server time current time was measured at, estimated with clock
+	// offset, receive time is used if omitted
+	ReportedAt int `json:"reported_at"`
+}
+
+// handleReportPosition sends RESYNC to reporting member only, if it drifted
+// from authoritative player beyond tolerance.
+func (c controller) handleReportPosition(ctx context.Context, conn *websocket.Conn, input ReportPositionInput) error {
+	roomId := c.getRoomIdFromCtx(ctx)
+	memberId := c.getMemberIdFromCtx(ctx)
+
+	reportedAt := input.ReportedAt
+	if reportedAt == 0 {
+		reportedAt = int(wsrouter.GetReceivedAtFromCtx(ctx).UnixMicro())
+	}
+
+	reportPositionResp, err := c.roomService.ReportPosition(ctx, &service.ReportPositionParams{
+		VideoId:     input.VideoId,
+		CurrentTime: input.CurrentTime,
+		ReportedAt:  reportedAt,
+		SenderId:    memberId,
+		RoomId:      roomId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to report position: %w", err)
+	}
+
+	c.logger.DebugContext(ctx, "member position reported", "drift_us", reportPositionResp.Drift)
+
+	if reportPositionResp.Resync == nil {
+		return nil
+	}
+
+	if err := c.writeToConn(ctx, conn, &Output{
+		Type: "RESYNC",
+		Payload: map[string]any{
+			"position": reportPositionResp.Resync,
+			"drift":    reportPositionResp.Drift,
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to write resync: %w", err)
+	}
+
+	return nil
+}
+
+func (c controller) handleGetHistory(ctx context.Context, conn *websocket.Conn, _ EmptyInput) error {
+	roomId := c.getRoomIdFromCtx(ctx)
+
+	history, err := c.roomService.GetHistory(ctx, roomId)
+	if err != nil {
+		return fmt.Errorf("failed to get history: %w", err)
+	}
+
+	if err := c.writeToConn(ctx, conn, &Output{
+		Type: "HISTORY",
+		Payload: map[string]any{
+			"history": history,
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+
+	return nil
+}
+
+type RequeueHistoryVideoInput struct {
+	HistoryId       int `json:"history_id"`
+	PlaylistVersion int `json:"playlist_version"`
+	PlayerVersion   int `json:"player_version"`
+}
+
+func (c controller) handleRequeueHistoryVideo(ctx context.Context, conn *websocket.Conn, input RequeueHistoryVideoInput) error {
+	roomId := c.getRoomIdFromCtx(ctx)
+	memberId := c.getMemberIdFromCtx(ctx)
+
+	addVideoResponse, err := c.roomService.RequeueHistoryVideo(ctx, &service.RequeueHistoryVideoParams{
+		HistoryId:       input.HistoryId,
+		PlaylistVersion: input.PlaylistVersion,
+		PlayerVersion:   input.PlayerVersion,
+		SenderId:        memberId,
+		RoomId:          roomId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to requeue history video: %w", err)
+	}
+
+	return c.writeAddVideoResponse(ctx, conn, roomId, addVideoResponse)
+}
+
+type PlayHistoryVideoInput struct {
+	HistoryId     int `json:"history_id"`
+	PlayerVersion int `json:"player_version"`
+}
+
+func (c controller) handlePlayHistoryVideo(ctx context.Context, conn *websocket.Conn, input PlayHistoryVideoInput) error {
+	roomId := c.getRoomIdFromCtx(ctx)
+	memberId := c.getMemberIdFromCtx(ctx)
+
+	playHistoryVideoResp, err := c.roomService.PlayHistoryVideo(ctx, &service.PlayHistoryVideoParams{
+		HistoryId:     input.HistoryId,
+		PlayerVersion: input.PlayerVersion,
+		SenderId:      memberId,
+		RoomId:        roomId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to play history video: %w", err)
+	}
+
+	switch {
+	case playHistoryVideoResp.PlayerVersionMismatchResponse != nil:
+		return c.writePlayerConflict(ctx, conn, &playHistoryVideoResp.PlayerVersionMismatchResponse.Player)
+	case playHistoryVideoResp.PlayerVideoUpdatedResponse != nil:
+		if err := c.broadcastPlayerVideoUpdated(ctx, roomId, playHistoryVideoResp.MemberIds, playHistoryVideoResp.PlayerVideoUpdatedResponse); err != nil {
+			return fmt.Errorf("failed to broadcast player updated: %w", err)
+		}
+	}
+
+	return nil
+}
+
 type UpdatePlayerStateInput struct {
 	Rid           string  `json:"rid"`
 	VideoId       int     `json:"video_id"`
 	IsPlaying     bool    `json:"is_playing"`
 	CurrentTime   int     `json:"current_time"`
 	PlaybackRate  float64 `json:"playback_rate"`
-	UpdatedAt     int     `json:"updated_at"`
 	PlayerVersion int     `json:"player_version"`
 }
 
@@ -34,12 +214,10 @@ func (c controller) handleUpdatePlayerState(ctx context.Context, conn *websocket
 	memberId := c.getMemberIdFromCtx(ctx)
 
 	updatePlayerStateResp, err := c.roomService.UpdatePlayerState(ctx, &service.UpdatePlayerStateParams{
-		SenderConn:    conn,
 		VideoId:       input.VideoId,
 		IsPlaying:     input.IsPlaying,
 		CurrentTime:   input.CurrentTime,
 		PlaybackRate:  input.PlaybackRate,
-		UpdatedAt:     input.UpdatedAt,
 		PlayerVersion: input.PlayerVersion,
 		SenderId:      memberId,
 		RoomId:        roomId,
@@ -50,16 +228,9 @@ func (c controller) handleUpdatePlayerState(ctx context.Context, conn *websocket
 
 	switch {
 	case updatePlayerStateResp.PlayerVersionMismatchResponse != nil:
-		if err := c.broadcast(ctx, updatePlayerStateResp.Conns, &Output{
-			Type: "PLAYER_STATE_UPDATED",
-			Payload: map[string]any{
-				"player": updatePlayerStateResp.PlayerVersionMismatchResponse.Player,
-			},
-		}); err != nil {
-			return fmt.Errorf("failed to broadcast player state updated: %w", err)
-		}
+		return c.writePlayerConflict(ctx, conn, &updatePlayerStateResp.PlayerVersionMismatchResponse.Player)
 	case updatePlayerStateResp.PlayerStateUpdatedResponse != nil:
-		if err := c.writeToConn(ctx, conn, &Output{
+		if err := c.writeToMember(ctx, roomId, memberId, &Output{
 			Type: "PLAYER_STATE_UPDATED",
 			Payload: map[string]any{
 				"rid":    input.Rid,
@@ -69,7 +240,7 @@ func (c controller) handleUpdatePlayerState(ctx context.Context, conn *websocket
 			return fmt.Errorf("failed to write to sender conn: %w", err)
 		}
 
-		if err := c.broadcastPlayerStateUpdated(ctx, updatePlayerStateResp.Conns, &updatePlayerStateResp.PlayerStateUpdatedResponse.Player); err != nil {
+		if err := c.broadcastPlayerStateUpdated(ctx, roomId, updatePlayerStateResp.MemberIds, &updatePlayerStateResp.PlayerStateUpdatedResponse.Player); err != nil {
 			return fmt.Errorf("failed to broadcast player updated: %w", err)
 		}
 	}
@@ -79,7 +250,6 @@ func (c controller) handleUpdatePlayerState(ctx context.Context, conn *websocket
 
 type UpdatePlayerVideoInput struct {
 	VideoId         int `json:"video_id"`
-	UpdatedAt       int `json:"updated_at"`
 	PlayerVersion   int `json:"player_version"`
 	PlaylistVersion int `json:"playlist_version"`
 }
@@ -89,11 +259,9 @@ func (c controller) handleUpdatePlayerVideo(ctx context.Context, conn *websocket
 	memberId := c.getMemberIdFromCtx(ctx)
 
 	updatePlayerVideoResp, err := c.roomService.UpdatePlayerVideo(ctx, &service.UpdatePlayerVideoParams{
-		SenderConn:      conn,
 		PlaylistVersion: input.PlaylistVersion,
 		PlayerVersion:   input.PlayerVersion,
 		VideoId:         input.VideoId,
-		UpdatedAt:       input.UpdatedAt,
 		SenderId:        memberId,
 		RoomId:          roomId,
 	})
@@ -103,16 +271,9 @@ func (c controller) handleUpdatePlayerVideo(ctx context.Context, conn *websocket
 
 	switch {
 	case updatePlayerVideoResp.PlayerVersionMismatchResponse != nil:
-		if err := c.broadcastPlayerStateUpdated(ctx, updatePlayerVideoResp.Conns, &updatePlayerVideoResp.PlayerVersionMismatchResponse.Player); err != nil {
-			return fmt.Errorf("failed to broadcast player state updated: %w", err)
-		}
+		return c.writePlayerConflict(ctx, conn, &updatePlayerVideoResp.PlayerVersionMismatchResponse.Player)
 	case updatePlayerVideoResp.PlayerVideoUpdatedResponse != nil:
-		if err := c.broadcastPlayerVideoUpdated(ctx,
-			updatePlayerVideoResp.Conns,
-			&updatePlayerVideoResp.PlayerVideoUpdatedResponse.Player,
-			&updatePlayerVideoResp.PlayerVideoUpdatedResponse.Playlist,
-			updatePlayerVideoResp.PlayerVideoUpdatedResponse.Members,
-		); err != nil {
+		if err := c.broadcastPlayerVideoUpdated(ctx, roomId, updatePlayerVideoResp.MemberIds, updatePlayerVideoResp.PlayerVideoUpdatedResponse); err != nil {
 			return fmt.Errorf("failed to broadcast player updated: %w", err)
 		}
 	}
@@ -122,7 +283,6 @@ func (c controller) handleUpdatePlayerVideo(ctx context.Context, conn *websocket
 
 type AddVideoInput struct {
 	VideoUrl        string `json:"video_url"`
-	UpdatedAt       int    `json:"updated_at"`
 	PlaylsitVersion int    `json:"playlist_version"`
 	PlayerVersion   int    `json:"player_version"`
 }
@@ -132,52 +292,71 @@ func (c controller) handleAddVideo(ctx context.Context, conn *websocket.Conn, in
 	memberId := c.getMemberIdFromCtx(ctx)
 
 	addVideoResponse, err := c.roomService.AddVideo(ctx, &service.AddVideoParams{
-		SenderConn:      conn,
 		PlaylistVersion: input.PlaylsitVersion,
 		PlayerVersion:   input.PlayerVersion,
 		SenderId:        memberId,
 		RoomId:          roomId,
 		VideoUrl:        input.VideoUrl,
-		UpdatedAt:       input.UpdatedAt,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to add video: %w", err)
 	}
 
-	switch {
-	case addVideoResponse.PlaylistVersionMismatchResponse != nil:
-		// todo: replace with some other response
-		if err := c.broadcastPlaylistReordered(ctx, addVideoResponse.Conns, &addVideoResponse.PlaylistVersionMismatchResponse.Playlist); err != nil {
-			return fmt.Errorf("failed to broadcast playlist reordered: %w", err)
-		}
+	return c.writeAddVideoResponse(ctx, conn, roomId, addVideoResponse)
+}
 
-	case addVideoResponse.PlayerVersionMismatchResponse != nil:
-		if err := c.broadcastPlayerStateUpdated(ctx, addVideoResponse.Conns, &addVideoResponse.PlayerVideoUpdatedResponse.Player); err != nil {
-			return fmt.Errorf("failed to broadcast player state updated: %w", err)
-		}
+type ImportPlaylistInput struct {
+	PlaylistId      string `json:"playlist_id"`
+	PlaylistVersion int    `json:"playlist_version"`
+}
 
-	case addVideoResponse.VideoAddedResponse != nil:
-		if err := c.broadcast(ctx, addVideoResponse.Conns, &Output{
-			Type: "VIDEO_ADDED",
-			Payload: map[string]any{
-				"added_video": addVideoResponse.VideoAddedResponse.AddedVideo,
-				"playlist":    addVideoResponse.VideoAddedResponse.Playlist,
-			},
-		}); err != nil {
-			return fmt.Errorf("failed to broadcast video added: %w", err)
-		}
+// handleImportPlaylist is not serialized by roomExecutorWSMw, playlist is
+// resolved first and only adding of its videos is serialized.
+func (c controller) handleImportPlaylist(ctx context.Context, conn *websocket.Conn, input ImportPlaylistInput) error {
+	roomId := c.getRoomIdFromCtx(ctx)
+	memberId := c.getMemberIdFromCtx(ctx)
 
-	case addVideoResponse.PlayerVideoUpdatedResponse != nil:
-		if err := c.broadcastPlayerVideoUpdated(ctx,
-			addVideoResponse.Conns,
-			&addVideoResponse.PlayerVideoUpdatedResponse.Player,
-			&addVideoResponse.PlayerVideoUpdatedResponse.Playlist,
-			addVideoResponse.PlayerVideoUpdatedResponse.Members,
-		); err != nil {
-			return fmt.Errorf("failed to broadcast player updated: %w", err)
+	playlist, err := c.roomService.ResolvePlaylist(ctx, &service.ResolvePlaylistParams{
+		PlaylistId: input.PlaylistId,
+		SenderId:   memberId,
+		RoomId:     roomId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to resolve playlist: %w", err)
+	}
+
+	var importPlaylistResponse *service.ImportPlaylistResponse
+	if err := c.executor.Do(ctx, roomId, func(ctx context.Context) error {
+		var err error
+		importPlaylistResponse, err = c.roomService.ImportPlaylist(ctx, &service.ImportPlaylistParams{
+			PlaylistVersion: input.PlaylistVersion,
+			Playlist:        playlist,
+			SenderId:        memberId,
+			RoomId:          roomId,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to import playlist: %w", err)
 		}
 
+		return c.writeImportPlaylistResponse(ctx, conn, roomId, importPlaylistResponse)
+	}); err != nil {
+		return err
+	}
+
+	if importPlaylistResponse.PlaylistVersionMismatchResponse != nil {
+		return nil
+	}
+
+	if err := c.writeToConn(ctx, conn, &Output{
+		Type: "PLAYLIST_IMPORTED",
+		Payload: map[string]any{
+			"added_count": importPlaylistResponse.AddedCount,
+			"truncated":   importPlaylistResponse.Truncated,
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to write playlist imported: %w", err)
 	}
+
 	return nil
 }
 
@@ -190,7 +369,6 @@ func (c controller) handleEndVideo(ctx context.Context, conn *websocket.Conn, in
 	memberId := c.getMemberIdFromCtx(ctx)
 
 	endVideoResponse, err := c.roomService.EndVideo(ctx, &service.EndVideoParams{
-		SenderConn:    conn,
 		PlayerVersion: input.PlayerVersion,
 		SenderId:      memberId,
 		RoomId:        roomId,
@@ -201,20 +379,13 @@ func (c controller) handleEndVideo(ctx context.Context, conn *websocket.Conn, in
 
 	switch {
 	case endVideoResponse.PlayerVersionMismatchResponse != nil:
-		if err := c.broadcastPlayerStateUpdated(ctx, endVideoResponse.Conns, &endVideoResponse.PlayerVersionMismatchResponse.Player); err != nil {
-			return fmt.Errorf("failed to broadcast player state updated: %w", err)
-		}
+		return c.writePlayerConflict(ctx, conn, &endVideoResponse.PlayerVersionMismatchResponse.Player)
 	case endVideoResponse.PlayerStateUpdatedResponse != nil:
-		if err := c.broadcastPlayerStateUpdated(ctx, endVideoResponse.Conns, &endVideoResponse.PlayerStateUpdatedResponse.Player); err != nil {
+		if err := c.broadcastPlayerStateUpdated(ctx, roomId, endVideoResponse.MemberIds, &endVideoResponse.PlayerStateUpdatedResponse.Player); err != nil {
 			return fmt.Errorf("failed to broadcast player state updated: %w", err)
 		}
 	case endVideoResponse.PlayerVideoUpdatedResponse != nil:
-		if err := c.broadcastPlayerVideoUpdated(ctx,
-			endVideoResponse.Conns,
-			&endVideoResponse.PlayerVideoUpdatedResponse.Player,
-			&endVideoResponse.PlayerVideoUpdatedResponse.Playlist,
-			endVideoResponse.PlayerVideoUpdatedResponse.Members,
-		); err != nil {
+		if err := c.broadcastPlayerVideoUpdated(ctx, roomId, endVideoResponse.MemberIds, endVideoResponse.PlayerVideoUpdatedResponse); err != nil {
 			return fmt.Errorf("failed to broadcast player updated: %w", err)
 		}
 	}
@@ -240,15 +411,11 @@ func (c controller) handleRemoveMember(ctx context.Context, _ *websocket.Conn, i
 	}
 
 	// close with specific code
-	removeMemberResp.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4001, "kicked"))
+	if err := c.closeMember(ctx, roomId, input.MemberId.String(), 4001, "kicked"); err != nil {
+		return fmt.Errorf("failed to close removed member conn: %w", err)
+	}
 
-	if err := c.broadcast(ctx, removeMemberResp.Conns, &Output{
-		Type: "MEMBER_DISCONNECTED",
-		Payload: map[string]any{
-			"disconnected_member_id": input.MemberId,
-			"members":                removeMemberResp.Members,
-		},
-	}); err != nil {
+	if err := c.broadcastMemberDisconnected(ctx, roomId, removeMemberResp.MemberIds, input.MemberId.String(), nil, removeMemberResp.Members, removeMemberResp.MembersVersion); err != nil {
 		return fmt.Errorf("failed to broadcast member disconnected: %w", err)
 	}
 
@@ -272,11 +439,11 @@ func (c controller) handlePromoteMember(ctx context.Context, _ *websocket.Conn,
 		return fmt.Errorf("failed to promote member: %w", err)
 	}
 
-	if err := c.broadcastMemberUpdated(ctx, promoteMemberResp.Conns, &promoteMemberResp.PromotedMember, promoteMemberResp.Members); err != nil {
+	if err := c.broadcastMemberUpdated(ctx, roomId, promoteMemberResp.MemberIds, &promoteMemberResp.PromotedMember, promoteMemberResp.Members, promoteMemberResp.MembersVersion); err != nil {
 		return err
 	}
 
-	if err := c.writeToConn(ctx, promoteMemberResp.PromotedMemberConn, &Output{
+	if err := c.writeToMember(ctx, roomId, promoteMemberResp.PromotedMember.Id, &Output{
 		Type: "IS_ADMIN_UPDATED",
 		Payload: map[string]any{
 			"is_admin": promoteMemberResp.PromotedMember.IsAdmin,
@@ -298,7 +465,6 @@ func (c controller) handleRemoveVideo(ctx context.Context, conn *websocket.Conn,
 	memberId := c.getMemberIdFromCtx(ctx)
 
 	removeVideoResponse, err := c.roomService.RemoveVideo(ctx, &service.RemoveVideoParams{
-		SenderConn:      conn,
 		PlaylistVersion: input.PlaylistVersion,
 		VideoId:         input.VideoId,
 		SenderId:        memberId,
@@ -310,16 +476,20 @@ func (c controller) handleRemoveVideo(ctx context.Context, conn *websocket.Conn,
 
 	switch {
 	case removeVideoResponse.PlaylistVersionMismatchResponse != nil:
-		// todo: replace with some other response
-		if err := c.broadcastPlaylistReordered(ctx, removeVideoResponse.Conns, &removeVideoResponse.PlaylistVersionMismatchResponse.Playlist); err != nil {
-			return fmt.Errorf("failed to broadcast playlist reordered: %w", err)
-		}
+		return c.writePlaylistConflict(ctx, conn, &removeVideoResponse.PlaylistVersionMismatchResponse.Playlist)
 	case removeVideoResponse.VideoRemovedResponse != nil:
-		if err := c.broadcast(ctx, removeVideoResponse.Conns, &Output{
+		if err := c.broadcast(ctx, roomId, removeVideoResponse.MemberIds, &Output{
 			Type: "VIDEO_REMOVED",
 			Payload: map[string]any{
 				"removed_video_id": input.VideoId,
-				"playlist":         removeVideoResponse.VideoRemovedResponse.Playlist,
+				"playlist_version": removeVideoResponse.VideoRemovedResponse.Playlist.Version,
+			},
+			Legacy: &Output{
+				Type: "VIDEO_REMOVED",
+				Payload: map[string]any{
+					"removed_video_id": input.VideoId,
+					"playlist":         removeVideoResponse.VideoRemovedResponse.Playlist,
+				},
 			},
 		}); err != nil {
 			return fmt.Errorf("failed to broadcast video removed: %w", err)
@@ -350,7 +520,7 @@ func (c controller) handleUpdateProfile(ctx context.Context, _ *websocket.Conn,
 		return fmt.Errorf("failed to update member: %w", err)
 	}
 
-	if err := c.broadcastMemberUpdated(ctx, updateProfileResp.Conns, &updateProfileResp.UpdatedMember, updateProfileResp.Members); err != nil {
+	if err := c.broadcastMemberUpdated(ctx, roomId, updateProfileResp.MemberIds, &updateProfileResp.UpdatedMember, updateProfileResp.Members, updateProfileResp.MembersVersion); err != nil {
 		return fmt.Errorf("failed to broadcast member updated: %w", err)
 	}
 
@@ -361,26 +531,50 @@ type UpdateIsReadyInput struct {
 	IsReady bool `json:"is_ready"`
 }
 
-func (c controller) handleUpdateIsReady(ctx context.Context, conn *websocket.Conn, input UpdateIsReadyInput) error {
+func (c controller) handleUpdateIsReady(ctx context.Context, _ *websocket.Conn, input UpdateIsReadyInput) error {
 	roomId := c.getRoomIdFromCtx(ctx)
 	memberId := c.getMemberIdFromCtx(ctx)
 
 	updatePlayerVideoResp, err := c.roomService.UpdateIsReady(ctx, &service.UpdateIsReadyParams{
-		IsReady:    input.IsReady,
-		SenderId:   memberId,
-		RoomId:     roomId,
-		SenderConn: conn,
+		IsReady:  input.IsReady,
+		SenderId: memberId,
+		RoomId:   roomId,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to update player video: %w", err)
 	}
 
-	if err := c.broadcastMemberUpdated(ctx, updatePlayerVideoResp.Conns, &updatePlayerVideoResp.UpdatedMember, updatePlayerVideoResp.Members); err != nil {
+	if err := c.broadcastMemberUpdated(ctx, roomId, updatePlayerVideoResp.MemberIds, &updatePlayerVideoResp.UpdatedMember, updatePlayerVideoResp.Members, updatePlayerVideoResp.MembersVersion); err != nil {
 		return fmt.Errorf("failed to broadcast member updated: %w", err)
 	}
 
 	if updatePlayerVideoResp.Player != nil {
-		if err := c.broadcastPlayerStateUpdated(ctx, updatePlayerVideoResp.Conns, updatePlayerVideoResp.Player); err != nil {
+		if err := c.broadcastPlayerStateUpdated(ctx, roomId, updatePlayerVideoResp.MemberIds, updatePlayerVideoResp.Player); err != nil {
+			return fmt.Errorf("failed to broadcast player updated: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c controller) handleStartBuffering(ctx context.Context, _ *websocket.Conn, _ EmptyInput) error {
+	roomId := c.getRoomIdFromCtx(ctx)
+	memberId := c.getMemberIdFromCtx(ctx)
+
+	startBufferingResp, err := c.roomService.StartBuffering(ctx, &service.StartBufferingParams{
+		SenderId: memberId,
+		RoomId:   roomId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start buffering: %w", err)
+	}
+
+	if err := c.broadcastMemberUpdated(ctx, roomId, startBufferingResp.MemberIds, &startBufferingResp.UpdatedMember, startBufferingResp.Members, startBufferingResp.MembersVersion); err != nil {
+		return fmt.Errorf("failed to broadcast member updated: %w", err)
+	}
+
+	if startBufferingResp.Player != nil {
+		if err := c.broadcastPlayerStateUpdated(ctx, roomId, startBufferingResp.MemberIds, startBufferingResp.Player); err != nil {
 			return fmt.Errorf("failed to broadcast player updated: %w", err)
 		}
 	}
@@ -392,21 +586,20 @@ type UpdateIsMutedInput struct {
 	IsMuted bool `json:"is_muted"`
 }
 
-func (c controller) handleUpdateIsMuted(ctx context.Context, conn *websocket.Conn, input UpdateIsMutedInput) error {
+func (c controller) handleUpdateIsMuted(ctx context.Context, _ *websocket.Conn, input UpdateIsMutedInput) error {
 	roomId := c.getRoomIdFromCtx(ctx)
 	memberId := c.getMemberIdFromCtx(ctx)
 
 	updatePlayerVideoResp, err := c.roomService.UpdateIsMuted(ctx, &service.UpdateIsMutedParams{
-		IsMuted:    input.IsMuted,
-		SenderId:   memberId,
-		RoomId:     roomId,
-		SenderConn: conn,
+		IsMuted:  input.IsMuted,
+		SenderId: memberId,
+		RoomId:   roomId,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to update is muted: %w", err)
 	}
 
-	if err := c.broadcastMemberUpdated(ctx, updatePlayerVideoResp.Conns, &updatePlayerVideoResp.UpdatedMember, updatePlayerVideoResp.Members); err != nil {
+	if err := c.broadcastMemberUpdated(ctx, roomId, updatePlayerVideoResp.MemberIds, &updatePlayerVideoResp.UpdatedMember, updatePlayerVideoResp.Members, updatePlayerVideoResp.MembersVersion); err != nil {
 		return fmt.Errorf("failed to broadcast member updated: %w", err)
 	}
 
@@ -423,7 +616,6 @@ func (c controller) handleReorderPlaylist(ctx context.Context, conn *websocket.C
 	memberId := c.getMemberIdFromCtx(ctx)
 
 	reorderVideoResponse, err := c.roomService.ReorderPlaylist(ctx, &service.ReorderPlaylistParams{
-		SenderConn:      conn,
 		PlaylistVersion: input.PlaylistVersion,
 		VideoIds:        input.VideoIds,
 		SenderId:        memberId,
@@ -435,15 +627,43 @@ func (c controller) handleReorderPlaylist(ctx context.Context, conn *websocket.C
 
 	switch {
 	case reorderVideoResponse.PlaylistVersionMismatchResponse != nil:
-		// todo: replace with some other response
-		if err := c.broadcastPlaylistReordered(ctx, reorderVideoResponse.Conns, &reorderVideoResponse.PlaylistVersionMismatchResponse.Playlist); err != nil {
-			return fmt.Errorf("failed to broadcast playlist reordered: %w", err)
-		}
+		return c.writePlaylistConflict(ctx, conn, &reorderVideoResponse.PlaylistVersionMismatchResponse.Playlist)
 	case reorderVideoResponse.PlaylistReorderedResponse != nil:
-		if err := c.broadcastPlaylistReordered(ctx, reorderVideoResponse.Conns, &reorderVideoResponse.PlaylistReorderedResponse.Playlist); err != nil {
+		if err := c.broadcastPlaylistReordered(ctx, roomId, reorderVideoResponse.MemberIds, reorderVideoResponse.PlaylistReorderedResponse); err != nil {
 			return fmt.Errorf("failed to broadcast playlist reordered: %w", err)
 		}
 	}
 
 	return nil
 }
+
+type UpdateRoomSettingsInput struct {
+	IsPersistent     *bool `json:"is_persistent"`
+	PauseOnBuffering *bool `json:"pause_on_buffering"`
+}
+
+func (c controller) handleUpdateRoomSettings(ctx context.Context, _ *websocket.Conn, input UpdateRoomSettingsInput) error {
+	roomId := c.getRoomIdFromCtx(ctx)
+	memberId := c.getMemberIdFromCtx(ctx)
+
+	updateRoomSettingsResp, err := c.roomService.UpdateRoomSettings(ctx, &service.UpdateRoomSettingsParams{
+		IsPersistent:     input.IsPersistent,
+		PauseOnBuffering: input.PauseOnBuffering,
+		SenderId:         memberId,
+		RoomId:           roomId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update room settings: %w", err)
+	}
+
+	if err := c.broadcast(ctx, roomId, updateRoomSettingsResp.MemberIds, &Output{
+		Type: "ROOM_SETTINGS_UPDATED",
+		Payload: map[string]any{
+			"settings": updateRoomSettingsResp.Settings,
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to broadcast room settings updated: %w", err)
+	}
+
+	return nil
+}
@@ -14,8 +14,21 @@ import (
 func (c controller) wsRequestIdWSMw() wsrouter.Middleware {
 	return func(next wsrouter.HandlerFunc[any]) wsrouter.HandlerFunc[any] {
 		return func(ctx context.Context, conn *websocket.Conn, payload any) error {
-			ctx = ctxlogger.AppendCtx(ctx, slog.String("ws_request_id", c.generateTimeBasedId()))
-			return next(ctx, conn, payload)
+			// request id set by client is preferred, so client can match it with ERROR
+			requestId := wsrouter.GetMessageIdFromCtx(ctx)
+			if requestId == "" {
+				requestId = c.generateTimeBasedId()
+			}
+			ctx = context.WithValue(ctx, wsRequestIdCtxKey, requestId)
+			ctx = ctxlogger.AppendCtx(ctx, slog.String("ws_request_id", requestId))
+			if err := next(ctx, conn, payload); err != nil {
+				return &requestError{
+					requestId: requestId,
+					err:       err,
+				}
+			}
+
+			return nil
 		}
 	}
 }
@@ -44,3 +57,34 @@ func (c controller) loggerWSMw() wsrouter.Middleware {
 		}
 	}
 }
+
+// handlers of these message types are not run by roomExecutorWSMw
+var unserializedMessageTypes = map[string]bool{
+	// serializes only adding of resolved videos, see handleImportPlaylist
+	"IMPORT_PLAYLIST": true,
+	// do not change room, so they do not wait for commands of other members
+	"ALIVE":               true,
+	"SYNC_CLOCK":          true,
+	"GET_PLAYER_POSITION": true,
+	"GET_HISTORY":         true,
+	// periodic read-only beacon, replies to sender only
+	"REPORT_POSITION": true,
+}
+
+// roomExecutorWSMw runs handlers of the same room one by one, so commands of
+// different members are applied in the order they were received. The order
+// holds only for members connected to this instance, commands received by
+// other replicas are not ordered against them, see executor.
+func (c controller) roomExecutorWSMw() wsrouter.Middleware {
+	return func(next wsrouter.HandlerFunc[any]) wsrouter.HandlerFunc[any] {
+		return func(ctx context.Context, conn *websocket.Conn, payload any) error {
+			if unserializedMessageTypes[wsrouter.GetMessageTypeFromCtx(ctx)] {
+				return next(ctx, conn, payload)
+			}
+
+			return c.executor.Do(ctx, c.getRoomIdFromCtx(ctx), func(ctx context.Context) error {
+				return next(ctx, conn, payload)
+			})
+		}
+	}
+}
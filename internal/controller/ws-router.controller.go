@@ -8,23 +8,45 @@ import (
 )
 
 func (c controller) handleWSError(ctx context.Context, conn *websocket.Conn, err error) error {
-	c.logger.InfoContext(ctx, "websocket handler error", "error", err)
-	return c.writeError(ctx, conn, err)
+	wsErr := c.newWSError(ctx, err)
+	if wsErr.Code == errCodeInternal {
+		c.logger.ErrorContext(ctx, "websocket handler error", "error", err)
+	} else {
+		c.logger.InfoContext(ctx, "websocket handler error", "error", err)
+	}
+
+	return c.writeToConn(ctx, conn, newErrorOutput(wsErr))
+}
+
+func (c controller) handleWSAck(ctx context.Context, conn *websocket.Conn) error {
+	return c.writeToConn(ctx, conn, &Output{
+		Type: "ACK",
+		Payload: map[string]any{
+			"request_id": wsrouter.GetMessageIdFromCtx(ctx),
+		},
+	})
 }
 
 func (c controller) getWSRouter() *wsrouter.WSRouter {
 	mux := wsrouter.New()
 
 	mux.SetErrorHandler(c.handleWSError)
+	mux.SetAckHandler(c.handleWSAck)
+	mux.SetReadTimeout(c.pongWait)
 
 	mux.Use(c.wsRequestIdWSMw())
 	mux.Use(c.loggerWSMw())
+	mux.Use(c.roomExecutorWSMw())
 
 	// video
 	wsrouter.Handle(mux, "ALIVE", c.handleAlive)
 	wsrouter.Handle(mux, "ADD_VIDEO", c.handleAddVideo)
+	wsrouter.Handle(mux, "IMPORT_PLAYLIST", c.handleImportPlaylist)
 	wsrouter.Handle(mux, "REMOVE_VIDEO", c.handleRemoveVideo)
 	wsrouter.Handle(mux, "REORDER_PLAYLIST", c.handleReorderPlaylist)
+	wsrouter.Handle(mux, "GET_HISTORY", c.handleGetHistory)
+	wsrouter.Handle(mux, "REQUEUE_HISTORY_VIDEO", c.handleRequeueHistoryVideo)
+	wsrouter.Handle(mux, "PLAY_HISTORY_VIDEO", c.handlePlayHistoryVideo)
 
 	// member
 	wsrouter.Handle(mux, "PROMOTE_MEMBER", c.handlePromoteMember)
@@ -34,11 +56,19 @@ func (c controller) getWSRouter() *wsrouter.WSRouter {
 	wsrouter.Handle(mux, "UPDATE_PLAYER_STATE", c.handleUpdatePlayerState)
 	wsrouter.Handle(mux, "UPDATE_PLAYER_VIDEO", c.handleUpdatePlayerVideo)
 	wsrouter.Handle(mux, "END_VIDEO", c.handleEndVideo)
+	wsrouter.Handle(mux, "GET_PLAYER_POSITION", c.handleGetPlayerPosition)
+	wsrouter.Handle(mux, "SYNC_CLOCK", c.handleSyncClock)
+	wsrouter.Handle(mux, "REPORT_POSITION", c.handleReportPosition)
+
+	// room
+	wsrouter.Handle(mux, "UPDATE_ROOM_SETTINGS", c.handleUpdateRoomSettings)
+	wsrouter.HandleVersion(mux, deltaProtocolVersion, "GET_ROOM", c.handleGetRoom)
 
 	// profile
 	wsrouter.Handle(mux, "UPDATE_PROFILE", c.handleUpdateProfile)
 	wsrouter.Handle(mux, "UPDATE_MUTED", c.handleUpdateIsMuted)
 	wsrouter.Handle(mux, "UPDATE_READY", c.handleUpdateIsReady)
+	wsrouter.Handle(mux, "BUFFERING", c.handleStartBuffering)
 
 	return mux
 }
@@ -0,0 +1,89 @@
+package executor
+
+import (
+	"context"
+	"sync"
+)
+
+type task struct {
+	ctx  context.Context
+	fn   func(context.Context) error
+	done chan error
+}
+
+type queue struct {
+	tasks chan task
+	// number of tasks submitted and not finished yet
+	pending int
+}
+
+// executor runs tasks of the same key one by one in submission order. Every
+// key with pending tasks has its own goroutine which exits once queue is drained.
+//
+// Ordering guarantee is per process and does not hold across replicas: rooms
+// are not pinned to instances, so tasks of the same room on different
+// instances still run concurrently. Version checks
+// of room scripts are the guard across instances, executor only spares
+// members of the same instance from version mismatches.
+type executor struct {
+	queues map[string]*queue
+	mu     sync.Mutex
+}
+
+func New() *executor {
+	return &executor{
+		queues: make(map[string]*queue),
+		mu:     sync.Mutex{},
+	}
+}
+
+func (e *executor) acquire(key string) *queue {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	q, ok := e.queues[key]
+	if !ok {
+		q = &queue{
+			tasks:   make(chan task, 64),
+			pending: 0,
+		}
+		e.queues[key] = q
+		go e.run(q)
+	}
+	q.pending++
+
+	return q
+}
+
+func (e *executor) release(key string, q *queue) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	q.pending--
+	if q.pending == 0 {
+		delete(e.queues, key)
+		close(q.tasks)
+	}
+}
+
+func (e *executor) run(q *queue) {
+	for t := range q.tasks {
+		t.done <- t.fn(t.ctx)
+	}
+}
+
+// Do queues fn for key and waits until it is executed. fn must not call Do
+// with the same key, otherwise it deadlocks.
+func (e *executor) Do(ctx context.Context, key string, fn func(context.Context) error) error {
+	q := e.acquire(key)
+	defer e.release(key, q)
+
+	t := task{
+		ctx:  ctx,
+		fn:   fn,
+		done: make(chan error, 1),
+	}
+	q.tasks <- t
+
+	return <-t.done
+}
@@ -0,0 +1,147 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoKeepsOrderOfKey(t *testing.T) {
+	e := New()
+	ctx := context.Background()
+
+	// first task blocks queue, so the rest are queued in submission order
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		e.Do(ctx, "room", func(context.Context) error {
+			close(started)
+			<-unblock
+			return nil
+		})
+	}()
+	<-started
+
+	var mu sync.Mutex
+	var order []int
+	running := 0
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.Do(ctx, "room", func(context.Context) error {
+				mu.Lock()
+				running++
+				assert.Equal(t, 1, running)
+				order = append(order, i)
+				mu.Unlock()
+
+				time.Sleep(time.Millisecond)
+
+				mu.Lock()
+				running--
+				mu.Unlock()
+				return nil
+			})
+		}()
+
+		// wait until task is queued before submitting next one
+		require.Eventually(t, func() bool {
+			return e.getQueued("room") == i+1
+		}, time.Second, time.Millisecond)
+	}
+
+	close(unblock)
+	wg.Wait()
+
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, order)
+}
+
+func TestDoRunsKeysConcurrently(t *testing.T) {
+	e := New()
+	ctx := context.Background()
+
+	// task of the first key waits for task of the second one, it deadlocks if
+	// keys share queue
+	secondDone := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- e.Do(ctx, "room1", func(context.Context) error {
+			select {
+			case <-secondDone:
+				return nil
+			case <-time.After(time.Second):
+				return errors.New("task of another key is blocked")
+			}
+		})
+	}()
+
+	require.Eventually(t, func() bool {
+		return e.getPending("room1") == 1
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, e.Do(ctx, "room2", func(context.Context) error {
+		close(secondDone)
+		return nil
+	}))
+	assert.NoError(t, <-errCh)
+}
+
+func TestDoReturnsTaskError(t *testing.T) {
+	e := New()
+	taskErr := errors.New("task failed")
+
+	err := e.Do(context.Background(), "room", func(context.Context) error {
+		return taskErr
+	})
+	assert.ErrorIs(t, err, taskErr)
+}
+
+func TestDoRemovesIdleKeys(t *testing.T) {
+	e := New()
+	ctx := context.Background()
+
+	for _, key := range []string{"room1", "room2", "room1"} {
+		require.NoError(t, e.Do(ctx, key, func(context.Context) error {
+			assert.Equal(t, 1, e.getPending(key))
+			return nil
+		}))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	assert.Empty(t, e.queues)
+}
+
+func (e *executor) getPending(key string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	q, ok := e.queues[key]
+	if !ok {
+		return 0
+	}
+
+	return q.pending
+}
+
+// getQueued returns number of tasks of key waiting for execution.
+func (e *executor) getQueued(key string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	q, ok := e.queues[key]
+	if !ok {
+		return 0
+	}
+
+	return len(q.tasks)
+}
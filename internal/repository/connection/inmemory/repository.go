@@ -35,6 +35,23 @@ func (r *repo) Add(conn *websocket.Conn, memberId string) error {
 	return nil
 }
 
+// Replace adds conn of member, previous conn of member is removed and
+// returned, nil is returned if member had none.
+func (r *repo) Replace(conn *websocket.Conn, memberId string) *websocket.Conn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prev := r.idList[memberId]
+	if prev != nil {
+		delete(r.connList, prev)
+	}
+
+	r.connList[conn] = memberId
+	r.idList[memberId] = conn
+
+	return prev
+}
+
 func (r *repo) RemoveByConn(conn *websocket.Conn) (string, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
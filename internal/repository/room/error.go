@@ -17,4 +17,11 @@ var (
 	ErrTokenNotFound           = errors.New("auth token not found")
 	ErrPlaylistVersionNotFound = errors.New("playlist version not found")
 	ErrInvalidVideoIds         = errors.New("invalid video ids")
+	ErrPlayerVersionMismatch   = errors.New("player version mismatch")
+	ErrPlaylistVersionMismatch = errors.New("playlist version mismatch")
+	ErrCurrentVideoMismatch    = errors.New("current video mismatch")
+	ErrVideoAlreadyPlaying     = errors.New("video is already playing")
+	ErrVideoAlreadyEnded       = errors.New("video is already ended")
+	ErrPlaylistLimitReached    = errors.New("playlist limit reached")
+	ErrHistoryEntryNotFound    = errors.New("history entry not found")
 )
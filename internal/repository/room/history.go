@@ -0,0 +1,34 @@
+package room
+
+type HistoryEntry struct {
+	Id           int
+	Provider     string
+	Url          string
+	Title        string
+	AuthorName   string
+	ThumbnailUrl string
+	// id of member which queued video
+	AddedBy  string
+	PlayedAt int
+	// position in microseconds playback started at
+	StartTime int
+}
+
+type AddHistoryEntryParams struct {
+	RoomId       string
+	Provider     string
+	Url          string
+	Title        string
+	AuthorName   string
+	ThumbnailUrl string
+	AddedBy      string
+	PlayedAt     int
+	StartTime    int
+	// oldest entries are dropped beyond it
+	HistoryLimit int
+}
+
+type GetHistoryEntryParams struct {
+	RoomId  string
+	EntryId int
+}
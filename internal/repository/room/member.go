@@ -9,6 +9,8 @@ type Member struct {
 	IsMuted   bool
 	IsAdmin   bool
 	IsReady   bool
+	// member stalled mid-video, cleared when it is ready again
+	IsBuffering bool
 }
 
 type AddMemberToListParams struct {
@@ -22,14 +24,15 @@ type GetMemberParams struct {
 }
 
 type SetMemberParams struct {
-	MemberId  string
-	Username  string
-	Color     string
-	AvatarUrl *string
-	IsMuted   bool
-	IsAdmin   bool
-	IsReady   bool
-	RoomId    string
+	MemberId    string
+	Username    string
+	Color       string
+	AvatarUrl   *string
+	IsMuted     bool
+	IsAdmin     bool
+	IsReady     bool
+	IsBuffering bool
+	RoomId      string
 }
 
 type RemoveMemberParams struct {
@@ -52,3 +55,37 @@ type RemoveMemberFromListParams struct {
 	MemberId string
 	RoomId   string
 }
+
+type SetMemberConnIdParams struct {
+	MemberId string
+	RoomId   string
+	// identifies the latest conn of member, so cleanup of replaced conn does
+	// nothing
+	ConnId string
+}
+
+type SetMemberDisconnectedParams struct {
+	MemberId string
+	RoomId   string
+	// conn being closed, member is not marked if it has newer conn
+	ConnId string
+	// identifies disconnect, so stale grace period timers do nothing after reconnect
+	Token    string
+	ExpireAt time.Time
+}
+
+type DisconnectMemberParams struct {
+	MemberId string
+	RoomId   string
+	// conn being closed, member is not removed if it has newer conn
+	ConnId string
+	// token of SetMemberDisconnected, empty if member was not marked
+	Token string
+}
+
+type RemoveMemberDisconnectedParams struct {
+	MemberId string
+	RoomId   string
+	// if empty, removed regardless of token
+	Token string
+}
@@ -38,3 +38,62 @@ type ExpirePlayerVersionParams struct {
 	RoomId   string
 	ExpireAt time.Time
 }
+
+type UpdatePlayerStateParams struct {
+	RoomId        string
+	PlayerVersion int
+	VideoId       int
+	IsPlaying     bool
+	CurrentTime   int
+	PlaybackRate  float64
+	UpdatedAt     int
+}
+
+type UpdatePlayerStateResponse struct {
+	Updated       bool
+	PlayerVersion int
+	UpdatedAt     int
+}
+
+type SetPlayerIsPlayingParams struct {
+	RoomId          string
+	PlayerVersion   int
+	VideoId         int
+	IsPlaying       bool
+	WaitingForReady bool
+	CurrentTime     int
+	UpdatedAt       int
+}
+
+type SwitchCurrentVideoParams struct {
+	RoomId        string
+	PlayerVersion int
+	VideoId       int
+	UpdatedAt     int
+	IsPlaying     bool
+	CurrentTime   int
+	PlaybackRate  float64
+	HistoryLimit  int
+}
+
+type SwitchCurrentVideoResponse struct {
+	PlayerVersion   int
+	PlaylistVersion int
+}
+
+type EndVideoParams struct {
+	RoomId        string
+	PlayerVersion int
+	UpdatedAt     int
+	IsPlaying     bool
+	CurrentTime   int
+	PlaybackRate  float64
+	HistoryLimit  int
+}
+
+type EndVideoResponse struct {
+	// nil if playlist is empty and video was marked as ended
+	NextVideoId     *int
+	PlayerVersion   int
+	PlaylistVersion int
+}
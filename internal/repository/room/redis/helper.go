@@ -2,18 +2,176 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/sharetube/server/internal/repository/room"
 )
 
+// errRoomKeysChanged is returned by room script if member or video keys it was
+// called with no longer cover keys it accesses, e.g. member joined meanwhile.
+var errRoomKeysChanged = errors.New("room script keys changed")
+
+// room script is retried on errRoomKeysChanged at most that many times
+const roomScriptAttempts = 3
+
+var scriptErrors = map[string]error{
+	"KEYS_CHANGED":              errRoomKeysChanged,
+	"PLAYER_VERSION_MISMATCH":   room.ErrPlayerVersionMismatch,
+	"PLAYLIST_VERSION_MISMATCH": room.ErrPlaylistVersionMismatch,
+	"CURRENT_VIDEO_MISMATCH":    room.ErrCurrentVideoMismatch,
+	"VIDEO_ALREADY_PLAYING":     room.ErrVideoAlreadyPlaying,
+	"VIDEO_ALREADY_ENDED":       room.ErrVideoAlreadyEnded,
+	"VIDEO_NOT_FOUND":           room.ErrVideoNotFound,
+	"PLAYLIST_LIMIT_REACHED":    room.ErrPlaylistLimitReached,
+	"INVALID_VIDEO_IDS":         room.ErrInvalidVideoIds,
+}
+
 func (r repo) addWithIncrement(ctx context.Context, c redis.Cmdable, key string, value interface{}) *redis.Cmd {
-	return c.EvalSha(ctx, r.maxScoreScript, []string{key}, value)
+	return r.maxScoreScript.Run(ctx, c, []string{key}, value)
 }
 
 func (r repo) expireKeysWithPrefix(ctx context.Context, c redis.Cmdable, pattern string, expireAt time.Time) *redis.Cmd {
-	return c.EvalSha(ctx, r.expireKeysWithPrefixScript, []string{}, pattern, expireAt.Unix())
+	return r.expireKeysWithPrefixScript.Run(ctx, c, []string{}, pattern, expireAt.Unix())
+}
+
+func (r repo) getRoomScriptKeys(roomId string) []string {
+	return []string{
+		r.getPlayerKey(roomId),
+		r.getPlayerVersionKey(roomId),
+		r.getVideoEndedKey(roomId),
+		r.getCurrentVideoKey(roomId),
+		r.getLastVideoKey(roomId),
+		r.getPlaylistKey(roomId),
+		r.getPlaylistVersionKey(roomId),
+		r.getMemberListKey(roomId),
+		r.getMembersVersionKey(roomId),
+		r.getHistoryKey(roomId),
+		r.getHistoryLastIdKey(roomId),
+	}
+}
+
+type roomScriptKeys struct {
+	// videos script reads or writes
+	videoIds []int
+	// set if script may switch current video, which resets members and
+	// removes last video
+	switchesVideo bool
+	// set along with switchesVideo if script may switch to the first playlist
+	// video
+	switchesToNext bool
+	// used if switchesVideo, oldest history entries are dropped beyond it
+	historyLimit int
+}
+
+// getSwitchVideoKeys returns keys of members and videos accessed on switch of
+// current video.
+func (r repo) getSwitchVideoKeys(ctx context.Context, roomId string, switchesToNext bool) ([]string, error) {
+	pipe := r.rc.Pipeline()
+	memberIdsCmd := pipe.ZRange(ctx, r.getMemberListKey(roomId), 0, -1)
+	lastVideoIdCmd := pipe.Get(ctx, r.getLastVideoKey(roomId))
+	var nextVideoIdCmd *redis.StringSliceCmd
+	if switchesToNext {
+		nextVideoIdCmd = pipe.ZRange(ctx, r.getPlaylistKey(roomId), 0, 0)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(memberIdsCmd.Val())+2)
+	for _, memberId := range memberIdsCmd.Val() {
+		keys = append(keys, r.getMemberKey(roomId, memberId))
+	}
+
+	if lastVideoId, err := lastVideoIdCmd.Result(); err == nil {
+		keys = append(keys, r.getVideoKeyPrefix(roomId)+lastVideoId)
+	}
+
+	if nextVideoIdCmd != nil {
+		for _, videoId := range nextVideoIdCmd.Val() {
+			keys = append(keys, r.getVideoKeyPrefix(roomId)+videoId)
+		}
+	}
+
+	return keys, nil
+}
+
+// evalRoomScript runs one of room scripts atomically and maps its error replies
+// to room errors. Script accesses only keys it was called with, so member and
+// video keys are read before it runs and it is retried if they changed
+// meanwhile.
+func (r repo) evalRoomScript(ctx context.Context, script *redis.Script, roomId string, scriptKeys *roomScriptKeys, args ...any) ([]int64, error) {
+	args = append([]any{r.getMemberKeyPrefix(roomId), r.getVideoKeyPrefix(roomId), scriptKeys.historyLimit}, args...)
+
+	for attempt := 1; ; attempt++ {
+		keys := r.getRoomScriptKeys(roomId)
+		for _, videoId := range scriptKeys.videoIds {
+			keys = append(keys, r.getVideoKey(roomId, videoId))
+		}
+
+		if scriptKeys.switchesVideo {
+			switchKeys, err := r.getSwitchVideoKeys(ctx, roomId, scriptKeys.switchesToNext)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, switchKeys...)
+		}
+
+		res, err := r.runRoomScript(ctx, script, keys, args...)
+		if errors.Is(err, errRoomKeysChanged) && attempt < roomScriptAttempts {
+			continue
+		}
+
+		return res, err
+	}
+}
+
+func (r repo) runRoomScript(ctx context.Context, script *redis.Script, keys []string, args ...any) ([]int64, error) {
+	res, err := script.Run(ctx, r.rc, keys, args...).Result()
+	if err != nil {
+		var redisErr redis.Error
+		if errors.As(err, &redisErr) {
+			// some servers prefix error replies without code with ERR
+			if mapped, ok := scriptErrors[strings.TrimPrefix(redisErr.Error(), "ERR ")]; ok {
+				return nil, mapped
+			}
+		}
+
+		return nil, err
+	}
+
+	switch v := res.(type) {
+	case int64:
+		return []int64{v}, nil
+	case []any:
+		values := make([]int64, 0, len(v))
+		for _, value := range v {
+			i, _ := value.(int64)
+			values = append(values, i)
+		}
+
+		return values, nil
+	default:
+		return nil, errors.New("unexpected script result")
+	}
+}
+
+// allocVideoIds reserves ids of n videos to be added by room script.
+func (r repo) allocVideoIds(ctx context.Context, roomId string, n int) ([]int, error) {
+	lastId, err := r.rc.IncrBy(ctx, r.getLastIdKey(roomId), int64(n)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	videoIds := make([]int, 0, n)
+	for videoId := int(lastId) - n + 1; videoId <= int(lastId); videoId++ {
+		videoIds = append(videoIds, videoId)
+	}
+
+	return videoIds, nil
 }
 
 func (r repo) executePipe(ctx context.Context, pipe redis.Pipeliner) error {
@@ -0,0 +1,108 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sharetube/server/internal/repository/room"
+)
+
+type historyEntry struct {
+	Id           int    `json:"id"`
+	Provider     string `json:"provider"`
+	Url          string `json:"url"`
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	ThumbnailUrl string `json:"thumbnail_url"`
+	AddedBy      string `json:"added_by"`
+	PlayedAt     int    `json:"played_at"`
+	StartTime    int    `json:"start_time"`
+}
+
+func (r repo) getHistoryKey(roomId string) string {
+	return fmt.Sprintf("room:%s:history", roomId)
+}
+
+func (r repo) getHistoryLastIdKey(roomId string) string {
+	return fmt.Sprintf("room:%s:history-last-id", roomId)
+}
+
+// AddHistoryEntry prepends entry to room history, so history is ordered from
+// the latest played video. Switch of current video records its entry itself,
+// see roomScriptHeader.
+func (r repo) AddHistoryEntry(ctx context.Context, params *room.AddHistoryEntryParams) (int, error) {
+	entryId, err := r.rc.Incr(ctx, r.getHistoryLastIdKey(params.RoomId)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := json.Marshal(historyEntry{
+		Id:           int(entryId),
+		Provider:     params.Provider,
+		Url:          params.Url,
+		Title:        params.Title,
+		AuthorName:   params.AuthorName,
+		ThumbnailUrl: params.ThumbnailUrl,
+		AddedBy:      params.AddedBy,
+		PlayedAt:     params.PlayedAt,
+		StartTime:    params.StartTime,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	historyKey := r.getHistoryKey(params.RoomId)
+	pipe := r.rc.TxPipeline()
+	pipe.LPush(ctx, historyKey, data)
+	pipe.LTrim(ctx, historyKey, 0, int64(params.HistoryLimit-1))
+	if err := r.executePipe(ctx, pipe); err != nil {
+		return 0, err
+	}
+
+	return int(entryId), nil
+}
+
+func (r repo) GetHistory(ctx context.Context, roomId string) ([]room.HistoryEntry, error) {
+	res, err := r.rc.LRange(ctx, r.getHistoryKey(roomId), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]room.HistoryEntry, 0, len(res))
+	for _, data := range res {
+		var entry historyEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return nil, err
+		}
+
+		history = append(history, room.HistoryEntry{
+			Id:           entry.Id,
+			Provider:     entry.Provider,
+			Url:          entry.Url,
+			Title:        entry.Title,
+			AuthorName:   entry.AuthorName,
+			ThumbnailUrl: entry.ThumbnailUrl,
+			AddedBy:      entry.AddedBy,
+			PlayedAt:     entry.PlayedAt,
+			StartTime:    entry.StartTime,
+		})
+	}
+
+	return history, nil
+}
+
+func (r repo) GetHistoryEntry(ctx context.Context, params *room.GetHistoryEntryParams) (room.HistoryEntry, error) {
+	history, err := r.GetHistory(ctx, params.RoomId)
+	if err != nil {
+		return room.HistoryEntry{}, err
+	}
+
+	for _, entry := range history {
+		if entry.Id == params.EntryId {
+			return entry, nil
+		}
+	}
+
+	return room.HistoryEntry{}, room.ErrHistoryEntryNotFound
+}
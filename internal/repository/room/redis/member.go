@@ -2,8 +2,11 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/skewb1k/goutils/maps"
 
 	"github.com/sharetube/server/internal/repository/room"
@@ -16,27 +19,117 @@ const (
 	isMutedKey   = "is_muted"
 	isAdminKey   = "is_admin"
 	isReadyKey   = "is_ready"
+	// missing in members created before buffering was introduced
+	isBufferingKey = "is_buffering"
+	// id of the latest conn of member, see SetMemberConnId
+	connIdKey = "conn_id"
 )
 
 func (r repo) getMemberKey(roomId, memberId string) string {
 	return fmt.Sprintf("room:%s:member:%s", roomId, memberId)
 }
 
+func (r repo) getMemberKeyPrefix(roomId string) string {
+	return fmt.Sprintf("room:%s:member:", roomId)
+}
+
 func (r repo) getMemberListKey(roomId string) string {
 	return fmt.Sprintf("room:%s:memberlist", roomId)
 }
 
+func (r repo) getMembersVersionKey(roomId string) string {
+	return fmt.Sprintf("room:%s:members-version", roomId)
+}
+
+// IncrMembersVersion is called on every change of member list or member
+// fields, so clients can detect missed member events.
+func (r repo) IncrMembersVersion(ctx context.Context, roomId string) (int, error) {
+	membersVersion, err := r.rc.Incr(ctx, r.getMembersVersionKey(roomId)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(membersVersion), nil
+}
+
+func (r repo) GetMembersVersion(ctx context.Context, roomId string) (int, error) {
+	membersVersion, err := r.rc.Get(ctx, r.getMembersVersionKey(roomId)).Int()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	return membersVersion, nil
+}
+
+func (r repo) getDisconnectedMemberKey(roomId, memberId string) string {
+	return fmt.Sprintf("room:%s:disconnected-member:%s", roomId, memberId)
+}
+
+// SetMemberConnId makes conn the latest one of member, cleanup of its previous
+// conns does nothing since then.
+func (r repo) SetMemberConnId(ctx context.Context, params *room.SetMemberConnIdParams) error {
+	return r.rc.HSet(ctx, r.getMemberKey(params.RoomId, params.MemberId), connIdKey, params.ConnId).Err()
+}
+
+// SetMemberDisconnected returns false if member has conn newer than closed one,
+// member is not marked then.
+func (r repo) SetMemberDisconnected(ctx context.Context, params *room.SetMemberDisconnectedParams) (bool, error) {
+	res, err := r.setMemberDisconnectedScript.Run(ctx, r.rc, []string{
+		r.getMemberKey(params.RoomId, params.MemberId),
+		r.getDisconnectedMemberKey(params.RoomId, params.MemberId),
+	}, params.ConnId, params.Token, time.Until(params.ExpireAt).Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return res == 1, nil
+}
+
+// RemoveMemberDisconnected returns false if member was not marked as
+// disconnected or was marked with another token.
+func (r repo) RemoveMemberDisconnected(ctx context.Context, params *room.RemoveMemberDisconnectedParams) (bool, error) {
+	res, err := r.removeMemberDisconnectedScript.Run(ctx, r.rc, []string{
+		r.getDisconnectedMemberKey(params.RoomId, params.MemberId),
+	}, params.Token).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return res == 1, nil
+}
+
+// DisconnectMember removes member from member list, member is kept to be
+// restored if it joins again. False is returned if member has conn newer than
+// closed one or was marked as disconnected with another token.
+func (r repo) DisconnectMember(ctx context.Context, params *room.DisconnectMemberParams) (bool, error) {
+	res, err := r.disconnectMemberScript.Run(ctx, r.rc, []string{
+		r.getMemberKey(params.RoomId, params.MemberId),
+		r.getDisconnectedMemberKey(params.RoomId, params.MemberId),
+		r.getMemberListKey(params.RoomId),
+	}, params.MemberId, params.ConnId, params.Token).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return res == 1, nil
+}
+
 func (r repo) SetMember(ctx context.Context, params *room.SetMemberParams) error {
 	// pipe := r.rc.TxPipeline()
 
 	memberKey := r.getMemberKey(params.RoomId, params.MemberId)
 	return r.rc.HSet(ctx, memberKey, maps.OmitNilPointers(map[string]any{
-		usernameKey:  params.Username,
-		avatarUrlKey: params.AvatarUrl,
-		colorKey:     params.Color,
-		isMutedKey:   params.IsMuted,
-		isAdminKey:   params.IsAdmin,
-		isReadyKey:   params.IsReady,
+		usernameKey:    params.Username,
+		avatarUrlKey:   params.AvatarUrl,
+		colorKey:       params.Color,
+		isMutedKey:     params.IsMuted,
+		isAdminKey:     params.IsAdmin,
+		isReadyKey:     params.IsReady,
+		isBufferingKey: params.IsBuffering,
 	})).Err()
 	// pipe.Expire(ctx, memberKey, r.maxExpireDuration)
 	// return r.executePipe(ctx, pipe)
@@ -52,6 +145,12 @@ func (r repo) AddMemberToList(ctx context.Context, params *room.AddMemberToListP
 		return room.ErrMemberNotFound
 	}
 
+	// key of member who left expires with the room once it is empty, see
+	// PersistRoom
+	if err := r.rc.Persist(ctx, r.getMemberKey(params.RoomId, params.MemberId)).Err(); err != nil {
+		return err
+	}
+
 	return r.addWithIncrement(ctx, r.rc, r.getMemberListKey(params.RoomId), params.MemberId).Err()
 }
 
@@ -135,13 +234,16 @@ func (r repo) GetMember(ctx context.Context, params *room.GetMemberParams) (room
 
 	// r.rc.Expire(ctx, memberKey, r.maxExpireDuration)
 
+	isBuffering, isBufferingOk := memberMap[isBufferingKey]
+
 	return room.Member{
-		Username:  memberMap[usernameKey],
-		Color:     memberMap[colorKey],
-		AvatarUrl: maps.PtrFromStringMap(memberMap, avatarUrlKey),
-		IsMuted:   r.fieldToBool(memberMap[isMutedKey]),
-		IsAdmin:   r.fieldToBool(memberMap[isAdminKey]),
-		IsReady:   r.fieldToBool(memberMap[isReadyKey]),
+		Username:    memberMap[usernameKey],
+		Color:       memberMap[colorKey],
+		AvatarUrl:   maps.PtrFromStringMap(memberMap, avatarUrlKey),
+		IsMuted:     r.fieldToBool(memberMap[isMutedKey]),
+		IsAdmin:     r.fieldToBool(memberMap[isAdminKey]),
+		IsReady:     r.fieldToBool(memberMap[isReadyKey]),
+		IsBuffering: isBufferingOk && r.fieldToBool(isBuffering),
 	}, nil
 }
 
@@ -187,6 +289,26 @@ func (r repo) UpdateMemberIsReady(ctx context.Context, roomId, memberId string,
 	return nil
 }
 
+func (r repo) UpdateMemberIsBuffering(ctx context.Context, roomId, memberId string, isBuffering bool) error {
+	memberKey := r.getMemberKey(roomId, memberId)
+	cmd := r.rc.Exists(ctx, memberKey)
+	if err := cmd.Err(); err != nil {
+		return err
+	}
+
+	if cmd.Val() == 0 {
+		return room.ErrMemberNotFound
+	}
+
+	if err := r.rc.HSet(ctx, memberKey, isBufferingKey, isBuffering).Err(); err != nil {
+		return err
+	}
+
+	// r.rc.Expire(ctx, memberKey, r.maxExpireDuration)
+
+	return nil
+}
+
 func (r repo) UpdateMemberIsMuted(ctx context.Context, roomId, memberId string, isMuted bool) error {
 	memberKey := r.getMemberKey(roomId, memberId)
 	cmd := r.rc.Exists(ctx, memberKey)
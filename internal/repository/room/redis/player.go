@@ -211,3 +211,113 @@ func (r repo) UpdatePlayerPlaybackRate(ctx context.Context, roomId string, playb
 func (r repo) UpdatePlayerUpdatedAt(ctx context.Context, roomId string, updatedAt int) error {
 	return r.updatePlayerValue(ctx, roomId, updatedAtKey, updatedAt)
 }
+
+func (r repo) UpdatePlayerState(ctx context.Context, params *room.UpdatePlayerStateParams) (*room.UpdatePlayerStateResponse, error) {
+	scriptKeys := &roomScriptKeys{
+		videoIds:       nil,
+		switchesVideo:  false,
+		switchesToNext: false,
+		historyLimit:   0,
+	}
+
+	res, err := r.evalRoomScript(ctx, r.updatePlayerStateScript, params.RoomId, scriptKeys,
+		params.PlayerVersion,
+		params.VideoId,
+		params.IsPlaying,
+		params.CurrentTime,
+		params.PlaybackRate,
+		params.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &room.UpdatePlayerStateResponse{
+		Updated:       res[0] == 1,
+		PlayerVersion: int(res[1]),
+		UpdatedAt:     int(res[2]),
+	}, nil
+}
+
+// SetPlayerIsPlaying pauses or resumes player unless it was changed since
+// params were read. New player version is returned.
+func (r repo) SetPlayerIsPlaying(ctx context.Context, params *room.SetPlayerIsPlayingParams) (int, error) {
+	scriptKeys := &roomScriptKeys{
+		videoIds:       nil,
+		switchesVideo:  false,
+		switchesToNext: false,
+		historyLimit:   0,
+	}
+
+	res, err := r.evalRoomScript(ctx, r.setPlayerIsPlayingScript, params.RoomId, scriptKeys,
+		params.PlayerVersion,
+		params.VideoId,
+		params.IsPlaying,
+		params.WaitingForReady,
+		params.CurrentTime,
+		params.UpdatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(res[0]), nil
+}
+
+func (r repo) SwitchCurrentVideo(ctx context.Context, params *room.SwitchCurrentVideoParams) (*room.SwitchCurrentVideoResponse, error) {
+	scriptKeys := &roomScriptKeys{
+		videoIds:       []int{params.VideoId},
+		switchesVideo:  true,
+		switchesToNext: false,
+		historyLimit:   params.HistoryLimit,
+	}
+
+	res, err := r.evalRoomScript(ctx, r.switchVideoScript, params.RoomId, scriptKeys,
+		params.PlayerVersion,
+		params.VideoId,
+		params.UpdatedAt,
+		params.IsPlaying,
+		params.CurrentTime,
+		params.PlaybackRate,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &room.SwitchCurrentVideoResponse{
+		PlayerVersion:   int(res[0]),
+		PlaylistVersion: int(res[1]),
+	}, nil
+}
+
+func (r repo) EndVideo(ctx context.Context, params *room.EndVideoParams) (*room.EndVideoResponse, error) {
+	scriptKeys := &roomScriptKeys{
+		videoIds:       nil,
+		switchesVideo:  true,
+		switchesToNext: true,
+		historyLimit:   params.HistoryLimit,
+	}
+
+	res, err := r.evalRoomScript(ctx, r.endVideoScript, params.RoomId, scriptKeys,
+		params.PlayerVersion,
+		params.UpdatedAt,
+		params.IsPlaying,
+		params.CurrentTime,
+		params.PlaybackRate,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var nextVideoId *int
+	if res[0] != 0 {
+		videoId := int(res[0])
+		nextVideoId = &videoId
+	}
+
+	return &room.EndVideoResponse{
+		NextVideoId:     nextVideoId,
+		PlayerVersion:   int(res[1]),
+		PlaylistVersion: int(res[2]),
+	}, nil
+}
@@ -1,50 +1,49 @@
 package redis
 
 import (
-	"context"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
 type repo struct {
-	rc                         *redis.Client
-	maxScoreScript             string
-	expireKeysWithPrefixScript string
+	rc                             *redis.Client
+	maxScoreScript                 *redis.Script
+	expireKeysWithPrefixScript     *redis.Script
+	updatePlayerStateScript        *redis.Script
+	setPlayerIsPlayingScript       *redis.Script
+	switchVideoScript              *redis.Script
+	endVideoScript                 *redis.Script
+	addVideoScript                 *redis.Script
+	addVideosScript                *redis.Script
+	playVideoScript                *redis.Script
+	removePlaylistVideoScript      *redis.Script
+	reorderPlaylistScript          *redis.Script
+	removeMemberDisconnectedScript *redis.Script
+	setMemberDisconnectedScript    *redis.Script
+	disconnectMemberScript         *redis.Script
 	// maxExpireDuration          time.Duration
 }
 
+// NewRepo creates room repository. Scripts are loaded on first run, see
+// redis.Script.Run.
 func NewRepo(rc *redis.Client, maxExpireDuration time.Duration) *repo {
 	return &repo{
-		rc: rc,
-		maxScoreScript: rc.ScriptLoad(context.Background(), `
-			local maxScore = redis.call('ZREVRANGE', KEYS[1], 0, 0, 'WITHSCORES')
-			local nextScore = 1
-			if #maxScore > 0 then
-				nextScore = tonumber(maxScore[2]) + 1
-			end
-			redis.call('ZADD', KEYS[1], nextScore, ARGV[1])
-			return nextScore
-		`).Val(),
-		expireKeysWithPrefixScript: rc.ScriptLoad(context.Background(), `
-			local pattern = ARGV[1]
-			local timestamp = ARGV[2]
-			local cursor = "0"
-			local count = 0
-
-			repeat
-				local result = redis.call('SCAN', cursor, 'MATCH', pattern)
-				cursor = result[1]
-				local keys = result[2]
-
-				for i, key in ipairs(keys) do
-					redis.call('EXPIREAT', key, timestamp)
-					count = count + 1
-				end
-			until cursor == "0"
-
-			return count
-		`).Val(),
+		rc:                             rc,
+		maxScoreScript:                 redis.NewScript(maxScoreScript),
+		expireKeysWithPrefixScript:     redis.NewScript(expireKeysWithPrefixScript),
+		updatePlayerStateScript:        redis.NewScript(updatePlayerStateScript),
+		setPlayerIsPlayingScript:       redis.NewScript(setPlayerIsPlayingScript),
+		switchVideoScript:              redis.NewScript(switchVideoScript),
+		endVideoScript:                 redis.NewScript(endVideoScript),
+		addVideoScript:                 redis.NewScript(addVideoScript),
+		addVideosScript:                redis.NewScript(addVideosScript),
+		playVideoScript:                redis.NewScript(playVideoScript),
+		removePlaylistVideoScript:      redis.NewScript(removePlaylistVideoScript),
+		reorderPlaylistScript:          redis.NewScript(reorderPlaylistScript),
+		removeMemberDisconnectedScript: redis.NewScript(removeMemberDisconnectedScript),
+		setMemberDisconnectedScript:    redis.NewScript(setMemberDisconnectedScript),
+		disconnectMemberScript:         redis.NewScript(disconnectMemberScript),
 		// maxExpireDuration: maxExpireDuration,
 	}
 }
@@ -0,0 +1,478 @@
+package redis
+
+const maxScoreScript = `
+	local maxScore = redis.call('ZREVRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+	local nextScore = 1
+	if #maxScore > 0 then
+		nextScore = tonumber(maxScore[2]) + 1
+	end
+	redis.call('ZADD', KEYS[1], nextScore, ARGV[1])
+	return nextScore
+`
+
+const expireKeysWithPrefixScript = `
+	local pattern = ARGV[1]
+	local timestamp = ARGV[2]
+	local cursor = "0"
+	local count = 0
+
+	repeat
+		local result = redis.call('SCAN', cursor, 'MATCH', pattern)
+		cursor = result[1]
+		local keys = result[2]
+
+		for i, key in ipairs(keys) do
+			redis.call('EXPIREAT', key, timestamp)
+			count = count + 1
+		end
+	until cursor == "0"
+
+	return count
+`
+
+// KEYS[1] disconnected member key, ARGV[1] token, empty token matches any
+const removeMemberDisconnectedScript = `
+	local token = redis.call('GET', KEYS[1])
+	if not token or (ARGV[1] ~= '' and token ~= ARGV[1]) then
+		return 0
+	end
+
+	return redis.call('DEL', KEYS[1])
+`
+
+// KEYS[1] member key, KEYS[2] disconnected member key. ARGV[1] conn id,
+// ARGV[2] token, ARGV[3] expiration in milliseconds. Member is not marked if
+// it has newer conn.
+const setMemberDisconnectedScript = `
+	if redis.call('HGET', KEYS[1], 'conn_id') ~= ARGV[1] then
+		return 0
+	end
+
+	redis.call('SET', KEYS[2], ARGV[2], 'PX', ARGV[3])
+	return 1
+`
+
+// KEYS[1] member key, KEYS[2] disconnected member key, KEYS[3] member list
+// key. ARGV[1] member id, ARGV[2] conn id, ARGV[3] token, empty if member was
+// not marked as disconnected. Member is not removed if it has newer conn or
+// was marked with another token.
+const disconnectMemberScript = `
+	if redis.call('HGET', KEYS[1], 'conn_id') ~= ARGV[2] then
+		return 0
+	end
+
+	if ARGV[3] ~= '' then
+		if redis.call('GET', KEYS[2]) ~= ARGV[3] then
+			return 0
+		end
+
+		redis.call('DEL', KEYS[2])
+	end
+
+	redis.call('ZREM', KEYS[3], ARGV[1])
+	return 1
+`
+
+// Room scripts receive fixed room keys, see getRoomScriptKeys, followed by
+// keys of members and videos they may access, see evalRoomScript. ARGV[1],
+// ARGV[2] are member and video key prefixes, ARGV[3] is history limit used on
+// switch of current video. Keys of members and videos are
+// read before script runs, so script checks they are still actual before any
+// write and fails with KEYS_CHANGED otherwise. Errors are returned as error
+// replies, see scriptErrors.
+const roomScriptHeader = `
+	local playerKey = KEYS[1]
+	local playerVersionKey = KEYS[2]
+	local videoEndedKey = KEYS[3]
+	local currentVideoKey = KEYS[4]
+	local lastVideoKey = KEYS[5]
+	local playlistKey = KEYS[6]
+	local playlistVersionKey = KEYS[7]
+	local memberListKey = KEYS[8]
+	local membersVersionKey = KEYS[9]
+	local historyKey = KEYS[10]
+	local historyLastIdKey = KEYS[11]
+	local memberKeyPrefix = ARGV[1]
+	local videoKeyPrefix = ARGV[2]
+	local historyLimit = tonumber(ARGV[3])
+
+	local declaredKeys = {}
+	for _, key in ipairs(KEYS) do
+		declaredKeys[key] = true
+	end
+
+	local function getVersion(key)
+		return tonumber(redis.call('GET', key) or '0')
+	end
+
+	local function addToPlaylist(videoId)
+		local maxScore = redis.call('ZREVRANGE', playlistKey, 0, 0, 'WITHSCORES')
+		local nextScore = 1
+		if #maxScore > 0 then
+			nextScore = tonumber(maxScore[2]) + 1
+		end
+		redis.call('ZADD', playlistKey, nextScore, videoId)
+	end
+
+	-- checks keys switchVideo accesses to make videoId current were declared
+	local function canSwitchVideo(videoId)
+		if not declaredKeys[videoKeyPrefix .. videoId] then
+			return false
+		end
+
+		local lastVideoId = redis.call('GET', lastVideoKey)
+		if lastVideoId and not declaredKeys[videoKeyPrefix .. lastVideoId] then
+			return false
+		end
+
+		for _, memberId in ipairs(redis.call('ZRANGE', memberListKey, 0, -1)) do
+			if not declaredKeys[memberKeyPrefix .. memberId] then
+				return false
+			end
+		end
+
+		return true
+	end
+
+	-- prepends history entry of video played since playedAt. Numbers are
+	-- spliced, cjson would encode microsecond timestamp in exponent notation.
+	local function addToHistory(videoId, playedAt, startTime)
+		local video = redis.call('HMGET', videoKeyPrefix .. videoId,
+			'provider', 'url', 'title', 'author_name', 'thumbnail_url', 'added_by')
+		local entry = '{"id":' .. redis.call('INCR', historyLastIdKey) ..
+			',"provider":' .. cjson.encode(video[1] or '') ..
+			',"url":' .. cjson.encode(video[2] or '') ..
+			',"title":' .. cjson.encode(video[3] or '') ..
+			',"author_name":' .. cjson.encode(video[4] or '') ..
+			',"thumbnail_url":' .. cjson.encode(video[5] or '') ..
+			',"added_by":' .. cjson.encode(video[6] or '') ..
+			',"played_at":' .. playedAt ..
+			',"start_time":' .. startTime .. '}'
+
+		redis.call('LPUSH', historyKey, entry)
+		redis.call('LTRIM', historyKey, 0, historyLimit - 1)
+	end
+
+	-- makes videoId current, previous current video becomes last one. Video
+	-- start time, if set, overrides currentTime. Video is recorded in history
+	-- along with the switch.
+	local function switchVideo(videoId, updatedAt, isPlaying, currentTime, playbackRate)
+		local currentVideoId = redis.call('GET', currentVideoKey)
+		if currentVideoId == videoId then
+			return nil, 'VIDEO_ALREADY_PLAYING'
+		end
+
+		if not canSwitchVideo(videoId) then
+			return nil, 'KEYS_CHANGED'
+		end
+
+		if redis.call('EXISTS', videoKeyPrefix .. videoId) == 0 then
+			return nil, 'VIDEO_NOT_FOUND'
+		end
+
+		local startTime = tonumber(redis.call('HGET', videoKeyPrefix .. videoId, 'start_time') or '0') or 0
+		if startTime > 0 then
+			currentTime = startTime
+		end
+
+		local lastVideoId = redis.call('GET', lastVideoKey)
+		redis.call('ZREM', playlistKey, videoId)
+		if lastVideoId and lastVideoId ~= videoId then
+			redis.call('DEL', videoKeyPrefix .. lastVideoId)
+		end
+
+		redis.call('SET', videoEndedKey, '0')
+		redis.call('SET', lastVideoKey, currentVideoId)
+		redis.call('SET', currentVideoKey, videoId)
+		redis.call('HSET', playerKey,
+			'updated_at', updatedAt,
+			'is_playing', isPlaying,
+			'current_time', currentTime,
+			'playback_rate', playbackRate,
+			'waiting_for_ready', '1')
+		addToHistory(videoId, updatedAt, startTime)
+
+		for _, memberId in ipairs(redis.call('ZRANGE', memberListKey, 0, -1)) do
+			local memberKey = memberKeyPrefix .. memberId
+			if redis.call('EXISTS', memberKey) == 1 then
+				redis.call('HSET', memberKey, 'is_ready', '0', 'is_buffering', '0')
+			end
+		end
+		redis.call('INCR', membersVersionKey)
+
+		local playlistVersion = redis.call('INCR', playlistVersionKey)
+		local playerVersion = redis.call('INCR', playerVersionKey)
+		return {playerVersion, playlistVersion}
+	end
+`
+
+// ARGV[4:] player version, video id, is playing, current time, playback rate, updated at
+const updatePlayerStateScript = roomScriptHeader + `
+	if getVersion(playerVersionKey) ~= tonumber(ARGV[4]) then
+		return redis.error_reply('PLAYER_VERSION_MISMATCH')
+	end
+
+	if redis.call('GET', currentVideoKey) ~= ARGV[5] then
+		return redis.error_reply('CURRENT_VIDEO_MISMATCH')
+	end
+
+	local player = redis.call('HMGET', playerKey, 'is_playing', 'current_time', 'playback_rate', 'updated_at')
+	if player[1] == ARGV[6] and tonumber(player[2]) == tonumber(ARGV[7]) and tonumber(player[3]) == tonumber(ARGV[8]) then
+		return {0, getVersion(playerVersionKey), tonumber(player[4])}
+	end
+
+	redis.call('SET', videoEndedKey, '0')
+	redis.call('HSET', playerKey,
+		'is_playing', ARGV[6],
+		'current_time', ARGV[7],
+		'playback_rate', ARGV[8],
+		'updated_at', ARGV[9],
+		'waiting_for_ready', '0')
+
+	return {1, redis.call('INCR', playerVersionKey), tonumber(ARGV[9])}
+`
+
+// ARGV[4:] player version, video id, is playing, waiting for ready, current
+// time, updated at
+const setPlayerIsPlayingScript = roomScriptHeader + `
+	if getVersion(playerVersionKey) ~= tonumber(ARGV[4]) then
+		return redis.error_reply('PLAYER_VERSION_MISMATCH')
+	end
+
+	if redis.call('GET', currentVideoKey) ~= ARGV[5] then
+		return redis.error_reply('CURRENT_VIDEO_MISMATCH')
+	end
+
+	redis.call('HSET', playerKey,
+		'is_playing', ARGV[6],
+		'waiting_for_ready', ARGV[7],
+		'current_time', ARGV[8],
+		'updated_at', ARGV[9])
+
+	return redis.call('INCR', playerVersionKey)
+`
+
+// ARGV[4:] player version, video id, updated at, is playing, current time, playback rate
+const switchVideoScript = roomScriptHeader + `
+	if getVersion(playerVersionKey) ~= tonumber(ARGV[4]) then
+		return redis.error_reply('PLAYER_VERSION_MISMATCH')
+	end
+
+	local versions, err = switchVideo(ARGV[5], ARGV[6], ARGV[7], ARGV[8], ARGV[9])
+	if err then
+		return redis.error_reply(err)
+	end
+
+	return versions
+`
+
+// ARGV[4:] player version, updated at, is playing, current time, playback rate
+const endVideoScript = roomScriptHeader + `
+	if getVersion(playerVersionKey) ~= tonumber(ARGV[4]) then
+		return redis.error_reply('PLAYER_VERSION_MISMATCH')
+	end
+
+	if redis.call('GET', videoEndedKey) == '1' then
+		return redis.error_reply('VIDEO_ALREADY_ENDED')
+	end
+
+	local nextVideo = redis.call('ZRANGE', playlistKey, 0, 0)
+	if #nextVideo == 0 then
+		redis.call('SET', videoEndedKey, '1')
+		return {0, getVersion(playerVersionKey), getVersion(playlistVersionKey)}
+	end
+
+	local versions, err = switchVideo(nextVideo[1], ARGV[5], ARGV[6], ARGV[7], ARGV[8])
+	if err then
+		return redis.error_reply(err)
+	end
+
+	return {tonumber(nextVideo[1]), versions[1], versions[2]}
+`
+
+// ARGV[4:] player version, playlist version, playlist limit, video id, url,
+// title, author name, thumbnail url, updated at, is playing, current time,
+// playback rate, added by, start time, provider
+const addVideoScript = roomScriptHeader + `
+	if getVersion(playerVersionKey) ~= tonumber(ARGV[4]) then
+		return redis.error_reply('PLAYER_VERSION_MISMATCH')
+	end
+
+	if getVersion(playlistVersionKey) ~= tonumber(ARGV[5]) then
+		return redis.error_reply('PLAYLIST_VERSION_MISMATCH')
+	end
+
+	local videosLength = redis.call('ZCARD', playlistKey)
+	if videosLength >= tonumber(ARGV[6]) then
+		return redis.error_reply('PLAYLIST_LIMIT_REACHED')
+	end
+
+	local videoId = ARGV[7]
+	local makeCurrent = videosLength == 0 and redis.call('GET', videoEndedKey) == '1'
+	if makeCurrent and not canSwitchVideo(videoId) then
+		return redis.error_reply('KEYS_CHANGED')
+	end
+
+	redis.call('HSET', videoKeyPrefix .. videoId,
+		'url', ARGV[8],
+		'title', ARGV[9],
+		'author_name', ARGV[10],
+		'thumbnail_url', ARGV[11],
+		'added_by', ARGV[16],
+		'start_time', ARGV[17],
+		'provider', ARGV[18])
+
+	if makeCurrent then
+		local versions, err = switchVideo(videoId, ARGV[12], ARGV[13], ARGV[14], ARGV[15])
+		if err then
+			return redis.error_reply(err)
+		end
+
+		return {tonumber(videoId), 1, versions[1], versions[2]}
+	end
+
+	addToPlaylist(videoId)
+
+	return {tonumber(videoId), 0, getVersion(playerVersionKey), redis.call('INCR', playlistVersionKey)}
+`
+
+// ARGV[4:] playlist version, playlist limit, added by, updated at, is
+// playing, current time, playback rate, then video id, provider, url, title,
+// author name, thumbnail url of every video. Videos exceeding playlist limit
+// are not added. Like in addVideoScript the first video becomes current if
+// playlist is empty and current video ended.
+const addVideosScript = roomScriptHeader + `
+	if getVersion(playlistVersionKey) ~= tonumber(ARGV[4]) then
+		return redis.error_reply('PLAYLIST_VERSION_MISMATCH')
+	end
+
+	local videosLength = redis.call('ZCARD', playlistKey)
+	local available = tonumber(ARGV[5]) - videosLength
+	if available <= 0 then
+		return redis.error_reply('PLAYLIST_LIMIT_REACHED')
+	end
+
+	local firstVideoId = ARGV[11]
+	local makeCurrent = videosLength == 0 and redis.call('GET', videoEndedKey) == '1'
+	if makeCurrent then
+		if not canSwitchVideo(firstVideoId) then
+			return redis.error_reply('KEYS_CHANGED')
+		end
+
+		-- current video does not take playlist slot
+		available = available + 1
+	end
+
+	local videoIds = {}
+	for i = 11, #ARGV, 6 do
+		if #videoIds >= available then
+			break
+		end
+
+		local videoId = ARGV[i]
+		redis.call('HSET', videoKeyPrefix .. videoId,
+			'provider', ARGV[i + 1],
+			'url', ARGV[i + 2],
+			'title', ARGV[i + 3],
+			'author_name', ARGV[i + 4],
+			'thumbnail_url', ARGV[i + 5],
+			'added_by', ARGV[6])
+		if not (makeCurrent and videoId == firstVideoId) then
+			addToPlaylist(videoId)
+		end
+		table.insert(videoIds, tonumber(videoId))
+	end
+
+	local result
+	if makeCurrent then
+		local versions, err = switchVideo(firstVideoId, ARGV[7], ARGV[8], ARGV[9], ARGV[10])
+		if err then
+			return redis.error_reply(err)
+		end
+
+		result = {versions[2], versions[1], 1}
+	else
+		result = {redis.call('INCR', playlistVersionKey), getVersion(playerVersionKey), 0}
+	end
+
+	for _, videoId in ipairs(videoIds) do
+		table.insert(result, videoId)
+	end
+
+	return result
+`
+
+// ARGV[4:] player version, video id, url, title, author name, thumbnail url,
+// added by, updated at, is playing, current time, playback rate, provider,
+// start time
+const playVideoScript = roomScriptHeader + `
+	if getVersion(playerVersionKey) ~= tonumber(ARGV[4]) then
+		return redis.error_reply('PLAYER_VERSION_MISMATCH')
+	end
+
+	local videoId = ARGV[5]
+	if not canSwitchVideo(videoId) then
+		return redis.error_reply('KEYS_CHANGED')
+	end
+
+	redis.call('HSET', videoKeyPrefix .. videoId,
+		'url', ARGV[6],
+		'title', ARGV[7],
+		'author_name', ARGV[8],
+		'thumbnail_url', ARGV[9],
+		'added_by', ARGV[10],
+		'provider', ARGV[15],
+		'start_time', ARGV[16])
+
+	local versions, err = switchVideo(videoId, ARGV[11], ARGV[12], ARGV[13], ARGV[14])
+	if err then
+		return redis.error_reply(err)
+	end
+
+	return {tonumber(videoId), versions[1], versions[2]}
+`
+
+// ARGV[4:] playlist version, video id
+const removePlaylistVideoScript = roomScriptHeader + `
+	if getVersion(playlistVersionKey) ~= tonumber(ARGV[4]) then
+		return redis.error_reply('PLAYLIST_VERSION_MISMATCH')
+	end
+
+	if redis.call('ZREM', playlistKey, ARGV[5]) == 0 then
+		return redis.error_reply('VIDEO_NOT_FOUND')
+	end
+
+	redis.call('DEL', videoKeyPrefix .. ARGV[5])
+
+	return redis.call('INCR', playlistVersionKey)
+`
+
+// ARGV[4:] playlist version, video ids...
+const reorderPlaylistScript = roomScriptHeader + `
+	if getVersion(playlistVersionKey) ~= tonumber(ARGV[4]) then
+		return redis.error_reply('PLAYLIST_VERSION_MISMATCH')
+	end
+
+	local videoIds = {}
+	for i = 5, #ARGV do
+		table.insert(videoIds, ARGV[i])
+	end
+
+	if redis.call('ZCARD', playlistKey) ~= #videoIds then
+		return redis.error_reply('INVALID_VIDEO_IDS')
+	end
+
+	local seen = {}
+	for _, videoId in ipairs(videoIds) do
+		if seen[videoId] or not redis.call('ZSCORE', playlistKey, videoId) then
+			return redis.error_reply('INVALID_VIDEO_IDS')
+		end
+		seen[videoId] = true
+	end
+
+	for i, videoId in ipairs(videoIds) do
+		redis.call('ZADD', playlistKey, i, videoId)
+	end
+
+	return redis.call('INCR', playlistVersionKey)
+`
@@ -0,0 +1,636 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/sharetube/server/internal/repository/room"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRoomId = "room"
+
+func newTestRepo(t *testing.T) (*repo, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rc.Close() })
+
+	return NewRepo(rc, time.Hour), mr
+}
+
+// seedRoom creates room with members m1, m2 and current video. Playlist
+// videos with given titles are added.
+func seedRoom(t *testing.T, r *repo, playlist ...string) (currentVideoId int, videoIds []int) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, memberId := range []string{"m1", "m2"} {
+		require.NoError(t, r.SetMember(ctx, &room.SetMemberParams{
+			MemberId:    memberId,
+			Username:    memberId,
+			Color:       "#000000",
+			AvatarUrl:   nil,
+			IsMuted:     false,
+			IsAdmin:     memberId == "m1",
+			IsReady:     true,
+			IsBuffering: false,
+			RoomId:      testRoomId,
+		}))
+		require.NoError(t, r.AddMemberToList(ctx, &room.AddMemberToListParams{
+			MemberId: memberId,
+			RoomId:   testRoomId,
+		}))
+	}
+
+	require.NoError(t, r.SetPlayer(ctx, &room.SetPlayerParams{
+		IsPlaying:       true,
+		WaitingForReady: false,
+		CurrentTime:     10,
+		PlaybackRate:    1,
+		UpdatedAt:       100,
+		RoomId:          testRoomId,
+	}))
+
+	currentVideoId = seedVideo(t, r, "current")
+	require.NoError(t, r.SetCurrentVideoId(ctx, &room.SetCurrentVideoParams{
+		VideoId: currentVideoId,
+		RoomId:  testRoomId,
+	}))
+	require.NoError(t, r.SetVideoEnded(ctx, &room.SetVideoEndedParams{
+		RoomId:     testRoomId,
+		VideoEnded: false,
+	}))
+
+	for _, title := range playlist {
+		videoId := seedVideo(t, r, title)
+		require.NoError(t, r.AddVideoToList(ctx, &room.AddVideoToListParams{
+			RoomId:  testRoomId,
+			VideoId: videoId,
+		}))
+		videoIds = append(videoIds, videoId)
+	}
+
+	return currentVideoId, videoIds
+}
+
+func seedVideo(t *testing.T, r *repo, title string) int {
+	t.Helper()
+
+	videoId, err := r.SetVideo(context.Background(), &room.SetVideoParams{
+		RoomId:       testRoomId,
+		Provider:     "youtube",
+		Url:          "url " + title,
+		Title:        title,
+		AuthorName:   "author",
+		ThumbnailUrl: "thumbnail",
+		AddedBy:      "m1",
+		StartTime:    0,
+	})
+	require.NoError(t, err)
+
+	return videoId
+}
+
+func assertMembersReady(t *testing.T, r *repo, isReady bool) {
+	t.Helper()
+
+	for _, memberId := range []string{"m1", "m2"} {
+		member, err := r.GetMember(context.Background(), &room.GetMemberParams{
+			MemberId: memberId,
+			RoomId:   testRoomId,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, isReady, member.IsReady, memberId)
+	}
+}
+
+func TestUpdatePlayerStateScript(t *testing.T) {
+	r, _ := newTestRepo(t)
+	ctx := context.Background()
+	currentVideoId, _ := seedRoom(t, r)
+
+	params := &room.UpdatePlayerStateParams{
+		RoomId:        testRoomId,
+		PlayerVersion: 0,
+		VideoId:       currentVideoId,
+		IsPlaying:     false,
+		CurrentTime:   20,
+		PlaybackRate:  1,
+		UpdatedAt:     200,
+	}
+
+	res, err := r.UpdatePlayerState(ctx, params)
+	require.NoError(t, err)
+	assert.Equal(t, &room.UpdatePlayerStateResponse{
+		Updated:       true,
+		PlayerVersion: 1,
+		UpdatedAt:     200,
+	}, res)
+
+	player, err := r.GetPlayer(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.False(t, player.IsPlaying)
+	assert.Equal(t, 20, player.CurrentTime)
+
+	_, err = r.UpdatePlayerState(ctx, params)
+	assert.ErrorIs(t, err, room.ErrPlayerVersionMismatch)
+
+	params.PlayerVersion = 1
+	params.VideoId = currentVideoId + 1
+	_, err = r.UpdatePlayerState(ctx, params)
+	assert.ErrorIs(t, err, room.ErrCurrentVideoMismatch)
+}
+
+func TestSetPlayerIsPlayingScript(t *testing.T) {
+	r, _ := newTestRepo(t)
+	ctx := context.Background()
+	currentVideoId, _ := seedRoom(t, r)
+
+	params := &room.SetPlayerIsPlayingParams{
+		RoomId:          testRoomId,
+		PlayerVersion:   0,
+		VideoId:         currentVideoId,
+		IsPlaying:       false,
+		WaitingForReady: true,
+		CurrentTime:     30,
+		UpdatedAt:       200,
+	}
+
+	playerVersion, err := r.SetPlayerIsPlaying(ctx, params)
+	require.NoError(t, err)
+	assert.Equal(t, 1, playerVersion)
+
+	player, err := r.GetPlayer(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, room.Player{
+		IsPlaying:       false,
+		WaitingForReady: true,
+		CurrentTime:     30,
+		PlaybackRate:    1,
+		UpdatedAt:       200,
+	}, player)
+
+	// player updated since params were read is kept
+	_, err = r.SetPlayerIsPlaying(ctx, params)
+	assert.ErrorIs(t, err, room.ErrPlayerVersionMismatch)
+
+	params.PlayerVersion = 1
+	params.VideoId = currentVideoId + 1
+	_, err = r.SetPlayerIsPlaying(ctx, params)
+	assert.ErrorIs(t, err, room.ErrCurrentVideoMismatch)
+
+	player, err = r.GetPlayer(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, 200, player.UpdatedAt)
+}
+
+func TestUpdatePlayerStateScriptNoChange(t *testing.T) {
+	r, _ := newTestRepo(t)
+	ctx := context.Background()
+	currentVideoId, _ := seedRoom(t, r)
+
+	require.NoError(t, r.SetVideoEnded(ctx, &room.SetVideoEndedParams{
+		RoomId:     testRoomId,
+		VideoEnded: true,
+	}))
+
+	res, err := r.UpdatePlayerState(ctx, &room.UpdatePlayerStateParams{
+		RoomId:        testRoomId,
+		PlayerVersion: 0,
+		VideoId:       currentVideoId,
+		IsPlaying:     true,
+		CurrentTime:   10,
+		PlaybackRate:  1,
+		UpdatedAt:     200,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &room.UpdatePlayerStateResponse{
+		Updated:       false,
+		PlayerVersion: 0,
+		UpdatedAt:     100,
+	}, res)
+
+	videoEnded, err := r.GetVideoEnded(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.True(t, videoEnded)
+}
+
+func TestSwitchVideoScript(t *testing.T) {
+	r, _ := newTestRepo(t)
+	ctx := context.Background()
+	currentVideoId, videoIds := seedRoom(t, r, "first", "second")
+
+	params := &room.SwitchCurrentVideoParams{
+		RoomId:        testRoomId,
+		PlayerVersion: 0,
+		VideoId:       videoIds[1],
+		// microseconds, which cjson would encode in exponent notation
+		UpdatedAt:    1760000000000000,
+		IsPlaying:    true,
+		CurrentTime:  0,
+		PlaybackRate: 1,
+		HistoryLimit: 1,
+	}
+
+	res, err := r.SwitchCurrentVideo(ctx, params)
+	require.NoError(t, err)
+	assert.Equal(t, &room.SwitchCurrentVideoResponse{
+		PlayerVersion:   1,
+		PlaylistVersion: 1,
+	}, res)
+
+	current, err := r.GetCurrentVideoId(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, videoIds[1], current)
+
+	lastVideoId, err := r.GetLastVideoId(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, currentVideoId, *lastVideoId)
+
+	playlist, err := r.GetVideoIds(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, []int{videoIds[0]}, playlist)
+
+	assertMembersReady(t, r, false)
+
+	// switch is recorded in history atomically
+	history, err := r.GetHistory(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, []room.HistoryEntry{{
+		Id:           1,
+		Provider:     "youtube",
+		Url:          "url second",
+		Title:        "second",
+		AuthorName:   "author",
+		ThumbnailUrl: "thumbnail",
+		AddedBy:      "m1",
+		PlayedAt:     1760000000000000,
+		StartTime:    0,
+	}}, history)
+
+	_, err = r.SwitchCurrentVideo(ctx, params)
+	assert.ErrorIs(t, err, room.ErrPlayerVersionMismatch)
+
+	params.PlayerVersion = 1
+	_, err = r.SwitchCurrentVideo(ctx, params)
+	assert.ErrorIs(t, err, room.ErrVideoAlreadyPlaying)
+
+	params.VideoId = 100
+	_, err = r.SwitchCurrentVideo(ctx, params)
+	assert.ErrorIs(t, err, room.ErrVideoNotFound)
+
+	// previous last video is removed on the next switch
+	params.VideoId = videoIds[0]
+	_, err = r.SwitchCurrentVideo(ctx, params)
+	require.NoError(t, err)
+
+	_, err = r.GetVideo(ctx, &room.GetVideoParams{
+		VideoId: currentVideoId,
+		RoomId:  testRoomId,
+	})
+	assert.ErrorIs(t, err, room.ErrVideoNotFound)
+
+	// failed switches are not recorded, oldest entries are dropped over limit
+	history, err = r.GetHistory(ctx, testRoomId)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, 2, history[0].Id)
+	assert.Equal(t, "first", history[0].Title)
+}
+
+func TestEndVideoScript(t *testing.T) {
+	r, _ := newTestRepo(t)
+	ctx := context.Background()
+	_, videoIds := seedRoom(t, r, "next")
+
+	params := &room.EndVideoParams{
+		RoomId:        testRoomId,
+		PlayerVersion: 0,
+		UpdatedAt:     200,
+		IsPlaying:     true,
+		CurrentTime:   0,
+		PlaybackRate:  1,
+	}
+
+	res, err := r.EndVideo(ctx, params)
+	require.NoError(t, err)
+	assert.Equal(t, &room.EndVideoResponse{
+		NextVideoId:     &videoIds[0],
+		PlayerVersion:   1,
+		PlaylistVersion: 1,
+	}, res)
+	assertMembersReady(t, r, false)
+
+	_, err = r.EndVideo(ctx, params)
+	assert.ErrorIs(t, err, room.ErrPlayerVersionMismatch)
+
+	params.PlayerVersion = 1
+	res, err = r.EndVideo(ctx, params)
+	require.NoError(t, err)
+	assert.Equal(t, &room.EndVideoResponse{
+		NextVideoId:     nil,
+		PlayerVersion:   1,
+		PlaylistVersion: 1,
+	}, res)
+
+	_, err = r.EndVideo(ctx, params)
+	assert.ErrorIs(t, err, room.ErrVideoAlreadyEnded)
+}
+
+func newAddVideoParams(title string) *room.AddVideoParams {
+	return &room.AddVideoParams{
+		RoomId:          testRoomId,
+		PlayerVersion:   0,
+		PlaylistVersion: 0,
+		PlaylistLimit:   2,
+		Provider:        "youtube",
+		Url:             "url " + title,
+		Title:           title,
+		AuthorName:      "author",
+		ThumbnailUrl:    "thumbnail",
+		AddedBy:         "m2",
+		StartTime:       0,
+		UpdatedAt:       200,
+		IsPlaying:       true,
+		CurrentTime:     0,
+		PlaybackRate:    1,
+	}
+}
+
+func TestAddVideoScript(t *testing.T) {
+	r, _ := newTestRepo(t)
+	ctx := context.Background()
+	seedRoom(t, r, "first")
+
+	params := newAddVideoParams("second")
+	res, err := r.AddVideo(ctx, params)
+	require.NoError(t, err)
+	assert.False(t, res.IsCurrent)
+	assert.Equal(t, 0, res.PlayerVersion)
+	assert.Equal(t, 1, res.PlaylistVersion)
+
+	video, err := r.GetVideo(ctx, &room.GetVideoParams{
+		VideoId: res.VideoId,
+		RoomId:  testRoomId,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "second", video.Title)
+	assert.Equal(t, "m2", video.AddedBy)
+
+	_, err = r.AddVideo(ctx, params)
+	assert.ErrorIs(t, err, room.ErrPlaylistVersionMismatch)
+
+	params.PlayerVersion = 1
+	_, err = r.AddVideo(ctx, params)
+	assert.ErrorIs(t, err, room.ErrPlayerVersionMismatch)
+
+	params.PlayerVersion = 0
+	params.PlaylistVersion = 1
+	_, err = r.AddVideo(ctx, params)
+	assert.ErrorIs(t, err, room.ErrPlaylistLimitReached)
+}
+
+func TestAddVideoScriptAfterVideoEnded(t *testing.T) {
+	r, _ := newTestRepo(t)
+	ctx := context.Background()
+	seedRoom(t, r)
+
+	require.NoError(t, r.SetVideoEnded(ctx, &room.SetVideoEndedParams{
+		RoomId:     testRoomId,
+		VideoEnded: true,
+	}))
+
+	res, err := r.AddVideo(ctx, newAddVideoParams("next"))
+	require.NoError(t, err)
+	assert.True(t, res.IsCurrent)
+	assert.Equal(t, 1, res.PlayerVersion)
+	assert.Equal(t, 1, res.PlaylistVersion)
+
+	current, err := r.GetCurrentVideoId(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, res.VideoId, current)
+	assertMembersReady(t, r, false)
+}
+
+func newAddVideosParams(playlistLimit int) *room.AddVideosParams {
+	return &room.AddVideosParams{
+		RoomId:          testRoomId,
+		PlaylistVersion: 0,
+		PlaylistLimit:   playlistLimit,
+		AddedBy:         "m2",
+		Videos: []room.AddedVideo{
+			{Provider: "youtube", Url: "url a", Title: "a", AuthorName: "author", ThumbnailUrl: "thumbnail"},
+			{Provider: "youtube", Url: "url b", Title: "b", AuthorName: "author", ThumbnailUrl: "thumbnail"},
+			{Provider: "youtube", Url: "url c", Title: "c", AuthorName: "author", ThumbnailUrl: "thumbnail"},
+		},
+		UpdatedAt:    200,
+		IsPlaying:    true,
+		CurrentTime:  0,
+		PlaybackRate: 1,
+	}
+}
+
+func TestAddVideosScript(t *testing.T) {
+	r, _ := newTestRepo(t)
+	ctx := context.Background()
+	_, videoIds := seedRoom(t, r, "first")
+
+	params := newAddVideosParams(3)
+	res, err := r.AddVideos(ctx, params)
+	require.NoError(t, err)
+	assert.False(t, res.IsCurrent)
+	assert.Equal(t, 0, res.PlayerVersion)
+	assert.Equal(t, 1, res.PlaylistVersion)
+	require.Len(t, res.VideoIds, 2)
+
+	playlist, err := r.GetVideoIds(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, append(videoIds, res.VideoIds...), playlist)
+
+	video, err := r.GetVideo(ctx, &room.GetVideoParams{
+		VideoId: res.VideoIds[1],
+		RoomId:  testRoomId,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "b", video.Title)
+	assert.Equal(t, "m2", video.AddedBy)
+
+	_, err = r.AddVideos(ctx, params)
+	assert.ErrorIs(t, err, room.ErrPlaylistVersionMismatch)
+
+	params.PlaylistVersion = 1
+	_, err = r.AddVideos(ctx, params)
+	assert.ErrorIs(t, err, room.ErrPlaylistLimitReached)
+}
+
+func TestAddVideosScriptAfterVideoEnded(t *testing.T) {
+	r, _ := newTestRepo(t)
+	ctx := context.Background()
+	seedRoom(t, r)
+
+	require.NoError(t, r.SetVideoEnded(ctx, &room.SetVideoEndedParams{
+		RoomId:     testRoomId,
+		VideoEnded: true,
+	}))
+
+	res, err := r.AddVideos(ctx, newAddVideosParams(2))
+	require.NoError(t, err)
+	assert.True(t, res.IsCurrent)
+	assert.Equal(t, 1, res.PlayerVersion)
+	assert.Equal(t, 1, res.PlaylistVersion)
+	require.Len(t, res.VideoIds, 3)
+
+	current, err := r.GetCurrentVideoId(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, res.VideoIds[0], current)
+
+	playlist, err := r.GetVideoIds(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, res.VideoIds[1:], playlist)
+	assertMembersReady(t, r, false)
+}
+
+func TestPlayVideoScript(t *testing.T) {
+	r, _ := newTestRepo(t)
+	ctx := context.Background()
+	currentVideoId, videoIds := seedRoom(t, r, "queued")
+
+	params := &room.PlayVideoParams{
+		RoomId:        testRoomId,
+		PlayerVersion: 0,
+		Provider:      "youtube",
+		Url:           "url previous",
+		Title:         "previous",
+		AuthorName:    "author",
+		ThumbnailUrl:  "thumbnail",
+		AddedBy:       "m1",
+		StartTime:     5000000,
+		UpdatedAt:     200,
+		IsPlaying:     true,
+		CurrentTime:   0,
+		PlaybackRate:  1,
+	}
+
+	res, err := r.PlayVideo(ctx, params)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.PlayerVersion)
+	assert.Equal(t, 1, res.PlaylistVersion)
+
+	current, err := r.GetCurrentVideoId(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, res.VideoId, current)
+
+	// playback starts at start time of video
+	player, err := r.GetPlayer(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, 5000000, player.CurrentTime)
+
+	lastVideoId, err := r.GetLastVideoId(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, currentVideoId, *lastVideoId)
+
+	// playlist is bypassed
+	playlist, err := r.GetVideoIds(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, videoIds, playlist)
+
+	_, err = r.PlayVideo(ctx, params)
+	assert.ErrorIs(t, err, room.ErrPlayerVersionMismatch)
+}
+
+func TestRemovePlaylistVideoScript(t *testing.T) {
+	r, _ := newTestRepo(t)
+	ctx := context.Background()
+	_, videoIds := seedRoom(t, r, "first", "second")
+
+	params := &room.RemovePlaylistVideoParams{
+		RoomId:          testRoomId,
+		VideoId:         videoIds[0],
+		PlaylistVersion: 0,
+	}
+
+	playlistVersion, err := r.RemovePlaylistVideo(ctx, params)
+	require.NoError(t, err)
+	assert.Equal(t, 1, playlistVersion)
+
+	playlist, err := r.GetVideoIds(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, videoIds[1:], playlist)
+
+	_, err = r.GetVideo(ctx, &room.GetVideoParams{
+		VideoId: videoIds[0],
+		RoomId:  testRoomId,
+	})
+	assert.ErrorIs(t, err, room.ErrVideoNotFound)
+
+	_, err = r.RemovePlaylistVideo(ctx, params)
+	assert.ErrorIs(t, err, room.ErrPlaylistVersionMismatch)
+
+	params.PlaylistVersion = 1
+	_, err = r.RemovePlaylistVideo(ctx, params)
+	assert.ErrorIs(t, err, room.ErrVideoNotFound)
+}
+
+func TestReorderPlaylistScript(t *testing.T) {
+	r, _ := newTestRepo(t)
+	ctx := context.Background()
+	_, videoIds := seedRoom(t, r, "first", "second", "third")
+
+	params := &room.ReorderPlaylistParams{
+		RoomId:          testRoomId,
+		VideoIds:        []int{videoIds[2], videoIds[0], videoIds[1]},
+		PlaylistVersion: 0,
+	}
+
+	playlistVersion, err := r.ReorderPlaylist(ctx, params)
+	require.NoError(t, err)
+	assert.Equal(t, 1, playlistVersion)
+
+	playlist, err := r.GetVideoIds(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, params.VideoIds, playlist)
+
+	_, err = r.ReorderPlaylist(ctx, params)
+	assert.ErrorIs(t, err, room.ErrPlaylistVersionMismatch)
+
+	params.PlaylistVersion = 1
+	for _, invalidVideoIds := range [][]int{
+		{videoIds[0], videoIds[1]},
+		{videoIds[0], videoIds[0], videoIds[1]},
+		{videoIds[0], videoIds[1], 100},
+	} {
+		params.VideoIds = invalidVideoIds
+		_, err = r.ReorderPlaylist(ctx, params)
+		assert.ErrorIs(t, err, room.ErrInvalidVideoIds)
+	}
+}
+
+func TestRoomScriptKeysChanged(t *testing.T) {
+	r, _ := newTestRepo(t)
+	ctx := context.Background()
+	_, videoIds := seedRoom(t, r, "next")
+
+	// member m2 key is not declared
+	keys := append(r.getRoomScriptKeys(testRoomId),
+		r.getMemberKey(testRoomId, "m1"),
+		r.getVideoKey(testRoomId, videoIds[0]),
+	)
+	_, err := r.runRoomScript(ctx, r.switchVideoScript, keys,
+		r.getMemberKeyPrefix(testRoomId),
+		r.getVideoKeyPrefix(testRoomId),
+		10,
+		0, videoIds[0], 200, true, 0, 1,
+	)
+	assert.ErrorIs(t, err, errRoomKeysChanged)
+
+	playerVersion, err := r.GetPlayerVersion(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Equal(t, 0, playerVersion)
+	assertMembersReady(t, r, true)
+}
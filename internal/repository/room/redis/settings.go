@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sharetube/server/internal/repository/room"
+)
+
+const (
+	isPersistentKey     = "is_persistent"
+	pauseOnBufferingKey = "pause_on_buffering"
+)
+
+func (r repo) getSettingsKey(roomId string) string {
+	return fmt.Sprintf("room:%s:settings", roomId)
+}
+
+func (r repo) getRoomKeysPattern(roomId string) string {
+	return fmt.Sprintf("room:%s:*", roomId)
+}
+
+func (r repo) SetSettings(ctx context.Context, params *room.SetSettingsParams) error {
+	return r.rc.HSet(ctx, r.getSettingsKey(params.RoomId), map[string]any{
+		isPersistentKey:     params.IsPersistent,
+		pauseOnBufferingKey: params.PauseOnBuffering,
+	}).Err()
+}
+
+// GetSettings returns default settings if room has none, e.g. it was created
+// before settings were introduced.
+func (r repo) GetSettings(ctx context.Context, roomId string) (room.Settings, error) {
+	res, err := r.rc.HGetAll(ctx, r.getSettingsKey(roomId)).Result()
+	if err != nil {
+		return room.Settings{}, err
+	}
+
+	isPersistent, ok := res[isPersistentKey]
+	pauseOnBuffering, pauseOnBufferingOk := res[pauseOnBufferingKey]
+
+	return room.Settings{
+		IsPersistent:     ok && r.fieldToBool(isPersistent),
+		PauseOnBuffering: pauseOnBufferingOk && r.fieldToBool(pauseOnBuffering),
+	}, nil
+}
+
+// ExpireRoom sets expiration of every room key.
+func (r repo) ExpireRoom(ctx context.Context, params *room.ExpireRoomParams) error {
+	return r.expireKeysWithPrefix(ctx, r.rc, r.getRoomKeysPattern(params.RoomId), params.ExpireAt).Err()
+}
+
+// PersistRoom removes expiration of room state keys, e.g. when empty room is
+// reopened. Broker keys and keys of disconnected members keep their own
+// expiration. Keys of members who left are persisted once they rejoin, see
+// AddMemberToList. Videos may only leave the room meanwhile, new ones are
+// created without expiration.
+func (r repo) PersistRoom(ctx context.Context, roomId string) error {
+	pipe := r.rc.Pipeline()
+	memberIdsCmd := pipe.ZRange(ctx, r.getMemberListKey(roomId), 0, -1)
+	videoIdsCmd := pipe.ZRange(ctx, r.getPlaylistKey(roomId), 0, -1)
+	currentVideoIdCmd := pipe.Get(ctx, r.getCurrentVideoKey(roomId))
+	lastVideoIdCmd := pipe.Get(ctx, r.getLastVideoKey(roomId))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	keys := append(r.getRoomScriptKeys(roomId), r.getSettingsKey(roomId), r.getLastIdKey(roomId))
+	for _, memberId := range memberIdsCmd.Val() {
+		keys = append(keys, r.getMemberKey(roomId, memberId))
+	}
+
+	videoIds := videoIdsCmd.Val()
+	for _, cmd := range []*redis.StringCmd{currentVideoIdCmd, lastVideoIdCmd} {
+		if videoId, err := cmd.Result(); err == nil && videoId != "" {
+			videoIds = append(videoIds, videoId)
+		}
+	}
+	for _, videoId := range videoIds {
+		keys = append(keys, r.getVideoKeyPrefix(roomId)+videoId)
+	}
+
+	pipe = r.rc.TxPipeline()
+	for _, key := range keys {
+		pipe.Persist(ctx, key)
+	}
+
+	return r.executePipe(ctx, pipe)
+}
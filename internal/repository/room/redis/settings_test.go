@@ -0,0 +1,79 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sharetube/server/internal/repository/room"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistRoom(t *testing.T) {
+	r, mr := newTestRepo(t)
+	ctx := context.Background()
+
+	currentVideoId, videoIds := seedRoom(t, r, "v1")
+	require.NoError(t, r.SetSettings(ctx, &room.SetSettingsParams{
+		IsPersistent:     true,
+		PauseOnBuffering: false,
+		RoomId:           testRoomId,
+	}))
+	_, err := r.AddHistoryEntry(ctx, &room.AddHistoryEntryParams{
+		Provider:     "youtube",
+		Url:          "url",
+		Title:        "title",
+		AuthorName:   "author",
+		ThumbnailUrl: "thumbnail",
+		AddedBy:      "m1",
+		PlayedAt:     100,
+		StartTime:    0,
+		HistoryLimit: 10,
+		RoomId:       testRoomId,
+	})
+	require.NoError(t, err)
+
+	// m2 left before room became empty
+	require.NoError(t, r.RemoveMemberFromList(ctx, &room.RemoveMemberFromListParams{
+		MemberId: "m2",
+		RoomId:   testRoomId,
+	}))
+	// keys which are not room state, e.g. broker ones
+	mr.Set("room:"+testRoomId+":seq", "3")
+	mr.SetTTL("room:"+testRoomId+":seq", time.Hour)
+	mr.Set("room:"+testRoomId+":disconnected-member:m1", "token")
+	mr.SetTTL("room:"+testRoomId+":disconnected-member:m1", time.Minute)
+
+	require.NoError(t, r.ExpireRoom(ctx, &room.ExpireRoomParams{
+		RoomId:   testRoomId,
+		ExpireAt: time.Now().Add(5 * time.Minute),
+	}))
+	require.NoError(t, r.PersistRoom(ctx, testRoomId))
+
+	for _, key := range append(r.getRoomScriptKeys(testRoomId),
+		r.getSettingsKey(testRoomId),
+		r.getLastIdKey(testRoomId),
+		r.getHistoryKey(testRoomId),
+		r.getHistoryLastIdKey(testRoomId),
+		r.getMemberKey(testRoomId, "m1"),
+		r.getVideoKey(testRoomId, currentVideoId),
+		r.getVideoKey(testRoomId, videoIds[0]),
+	) {
+		if mr.Exists(key) {
+			assert.Zero(t, mr.TTL(key), key)
+		}
+	}
+
+	assert.NotZero(t, mr.TTL("room:"+testRoomId+":seq"))
+	assert.NotZero(t, mr.TTL("room:"+testRoomId+":disconnected-member:m1"))
+
+	// left member is persisted once it rejoins
+	m2Key := r.getMemberKey(testRoomId, "m2")
+	assert.NotZero(t, mr.TTL(m2Key))
+	require.NoError(t, r.AddMemberToList(ctx, &room.AddMemberToListParams{
+		MemberId: "m2",
+		RoomId:   testRoomId,
+	}))
+	assert.Zero(t, mr.TTL(m2Key))
+}
@@ -12,16 +12,23 @@ import (
 )
 
 const (
+	providerKey     = "provider"
 	urlKey          = "url"
 	titleKey        = "title"
 	authorNameKey   = "author_name"
 	thumbnailUrlKey = "thumbnail_url"
+	addedByKey      = "added_by"
+	startTimeKey    = "start_time"
 )
 
 func (r repo) getVideoKey(roomId string, videoId int) string {
 	return fmt.Sprintf("room:%s:video:%d", roomId, videoId)
 }
 
+func (r repo) getVideoKeyPrefix(roomId string) string {
+	return fmt.Sprintf("room:%s:video:", roomId)
+}
+
 func (r repo) getLastIdKey(roomId string) string {
 	return fmt.Sprintf("room:%s:video-last-id", roomId)
 }
@@ -113,10 +120,13 @@ func (r repo) SetVideo(ctx context.Context, params *room.SetVideoParams) (int, e
 	videoKey := r.getVideoKey(params.RoomId, videoId)
 
 	pipe.HSet(ctx, videoKey, maps.OmitNilPointers(map[string]any{
+		providerKey:     params.Provider,
 		urlKey:          params.Url,
 		titleKey:        params.Title,
 		authorNameKey:   params.AuthorName,
 		thumbnailUrlKey: params.ThumbnailUrl,
+		addedByKey:      params.AddedBy,
+		startTimeKey:    params.StartTime,
 	}))
 	// pipe.Expire(ctx, videoKey, r.maxExpireDuration)
 
@@ -142,10 +152,13 @@ func (r repo) GetVideo(ctx context.Context, params *room.GetVideoParams) (room.V
 	// r.rc.Expire(ctx, videoKey, r.maxExpireDuration)
 
 	return room.Video{
+		Provider:     videoMap[providerKey],
 		Url:          videoMap[urlKey],
 		Title:        videoMap[titleKey],
 		AuthorName:   videoMap[authorNameKey],
 		ThumbnailUrl: videoMap[thumbnailUrlKey],
+		AddedBy:      videoMap[addedByKey],
+		StartTime:    r.fieldToInt(videoMap[startTimeKey]),
 	}, nil
 }
 
@@ -320,3 +333,175 @@ func (r repo) SetCurrentVideoId(ctx context.Context, params *room.SetCurrentVide
 	currentVideoKey := r.getCurrentVideoKey(params.RoomId)
 	return r.rc.Set(ctx, currentVideoKey, params.VideoId, 0).Err()
 }
+
+func (r repo) AddVideo(ctx context.Context, params *room.AddVideoParams) (*room.AddVideoResponse, error) {
+	videoIds, err := r.allocVideoIds(ctx, params.RoomId, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	scriptKeys := &roomScriptKeys{
+		videoIds:       videoIds,
+		switchesVideo:  true,
+		switchesToNext: false,
+		historyLimit:   params.HistoryLimit,
+	}
+
+	res, err := r.evalRoomScript(ctx, r.addVideoScript, params.RoomId, scriptKeys,
+		params.PlayerVersion,
+		params.PlaylistVersion,
+		params.PlaylistLimit,
+		videoIds[0],
+		params.Url,
+		params.Title,
+		params.AuthorName,
+		params.ThumbnailUrl,
+		params.UpdatedAt,
+		params.IsPlaying,
+		params.CurrentTime,
+		params.PlaybackRate,
+		params.AddedBy,
+		params.StartTime,
+		params.Provider,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &room.AddVideoResponse{
+		VideoId:         int(res[0]),
+		IsCurrent:       res[1] == 1,
+		PlayerVersion:   int(res[2]),
+		PlaylistVersion: int(res[3]),
+	}, nil
+}
+
+// AddVideos appends videos to playlist with single playlist version bump.
+// The first video becomes current instead if playlist is empty and current
+// video ended.
+func (r repo) AddVideos(ctx context.Context, params *room.AddVideosParams) (*room.AddVideosResponse, error) {
+	videoIds, err := r.allocVideoIds(ctx, params.RoomId, len(params.Videos))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]any, 0, len(params.Videos)*6+7)
+	args = append(args,
+		params.PlaylistVersion,
+		params.PlaylistLimit,
+		params.AddedBy,
+		params.UpdatedAt,
+		params.IsPlaying,
+		params.CurrentTime,
+		params.PlaybackRate,
+	)
+	for i, video := range params.Videos {
+		args = append(args, videoIds[i], video.Provider, video.Url, video.Title, video.AuthorName, video.ThumbnailUrl)
+	}
+
+	scriptKeys := &roomScriptKeys{
+		videoIds:       videoIds,
+		switchesVideo:  true,
+		switchesToNext: false,
+		historyLimit:   params.HistoryLimit,
+	}
+
+	res, err := r.evalRoomScript(ctx, r.addVideosScript, params.RoomId, scriptKeys, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	addedVideoIds := make([]int, 0, len(res)-3)
+	for _, videoId := range res[3:] {
+		addedVideoIds = append(addedVideoIds, int(videoId))
+	}
+
+	return &room.AddVideosResponse{
+		VideoIds:        addedVideoIds,
+		IsCurrent:       res[2] == 1,
+		PlayerVersion:   int(res[1]),
+		PlaylistVersion: int(res[0]),
+	}, nil
+}
+
+func (r repo) RemovePlaylistVideo(ctx context.Context, params *room.RemovePlaylistVideoParams) (int, error) {
+	scriptKeys := &roomScriptKeys{
+		videoIds:       []int{params.VideoId},
+		switchesVideo:  false,
+		switchesToNext: false,
+		historyLimit:   0,
+	}
+
+	res, err := r.evalRoomScript(ctx, r.removePlaylistVideoScript, params.RoomId, scriptKeys,
+		params.PlaylistVersion,
+		params.VideoId,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(res[0]), nil
+}
+
+func (r repo) ReorderPlaylist(ctx context.Context, params *room.ReorderPlaylistParams) (int, error) {
+	args := make([]any, 0, len(params.VideoIds)+1)
+	args = append(args, params.PlaylistVersion)
+	for _, videoId := range params.VideoIds {
+		args = append(args, videoId)
+	}
+
+	scriptKeys := &roomScriptKeys{
+		videoIds:       nil,
+		switchesVideo:  false,
+		switchesToNext: false,
+		historyLimit:   0,
+	}
+
+	res, err := r.evalRoomScript(ctx, r.reorderPlaylistScript, params.RoomId, scriptKeys, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(res[0]), nil
+}
+
+// PlayVideo adds video and makes it current bypassing playlist, e.g. to jump
+// back to video from history.
+func (r repo) PlayVideo(ctx context.Context, params *room.PlayVideoParams) (*room.PlayVideoResponse, error) {
+	videoIds, err := r.allocVideoIds(ctx, params.RoomId, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	scriptKeys := &roomScriptKeys{
+		videoIds:       videoIds,
+		switchesVideo:  true,
+		switchesToNext: false,
+		historyLimit:   params.HistoryLimit,
+	}
+
+	res, err := r.evalRoomScript(ctx, r.playVideoScript, params.RoomId, scriptKeys,
+		params.PlayerVersion,
+		videoIds[0],
+		params.Url,
+		params.Title,
+		params.AuthorName,
+		params.ThumbnailUrl,
+		params.AddedBy,
+		params.UpdatedAt,
+		params.IsPlaying,
+		params.CurrentTime,
+		params.PlaybackRate,
+		params.Provider,
+		params.StartTime,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &room.PlayVideoResponse{
+		VideoId:         int(res[0]),
+		PlayerVersion:   int(res[1]),
+		PlaylistVersion: int(res[2]),
+	}, nil
+}
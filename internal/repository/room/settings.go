@@ -0,0 +1,20 @@
+package room
+
+import "time"
+
+type Settings struct {
+	IsPersistent bool
+	// player is paused while any member is buffering
+	PauseOnBuffering bool
+}
+
+type SetSettingsParams struct {
+	RoomId           string
+	IsPersistent     bool
+	PauseOnBuffering bool
+}
+
+type ExpireRoomParams struct {
+	RoomId   string
+	ExpireAt time.Time
+}
@@ -3,10 +3,17 @@ package room
 import "time"
 
 type Video struct {
+	// name of video provider, empty for videos added before it was stored,
+	// which are youtube videos
+	Provider     string
 	Url          string
 	Title        string
 	AuthorName   string
 	ThumbnailUrl string
+	// id of member which queued video, empty for videos added before it was stored
+	AddedBy string
+	// position in microseconds playback starts at when video becomes current
+	StartTime int
 }
 
 type RemoveVideoParams struct {
@@ -42,10 +49,13 @@ type AddVideoToListParams struct {
 
 type SetVideoParams struct {
 	RoomId       string
+	Provider     string
 	Url          string
 	Title        string
 	AuthorName   string
 	ThumbnailUrl string
+	AddedBy      string
+	StartTime    int
 }
 
 type SetLastVideoParams struct {
@@ -72,3 +82,96 @@ type GetVideoParams struct {
 	VideoId int
 	RoomId  string
 }
+
+type AddVideoParams struct {
+	RoomId          string
+	PlayerVersion   int
+	PlaylistVersion int
+	PlaylistLimit   int
+	Provider        string
+	Url             string
+	Title           string
+	AuthorName      string
+	ThumbnailUrl    string
+	AddedBy         string
+	StartTime       int
+	UpdatedAt       int
+	IsPlaying       bool
+	CurrentTime     int
+	PlaybackRate    float64
+	HistoryLimit    int
+}
+
+type AddVideoResponse struct {
+	VideoId int
+	// true if video became current because previous one ended
+	IsCurrent       bool
+	PlayerVersion   int
+	PlaylistVersion int
+}
+
+type AddedVideo struct {
+	Provider     string
+	Url          string
+	Title        string
+	AuthorName   string
+	ThumbnailUrl string
+}
+
+type AddVideosParams struct {
+	RoomId          string
+	PlaylistVersion int
+	PlaylistLimit   int
+	AddedBy         string
+	Videos          []AddedVideo
+	// player state if the first video becomes current
+	UpdatedAt    int
+	IsPlaying    bool
+	CurrentTime  int
+	PlaybackRate float64
+	HistoryLimit int
+}
+
+type AddVideosResponse struct {
+	// ids of added videos in playlist order, videos over limit are not added
+	VideoIds []int
+	// true if the first video became current because previous one ended
+	IsCurrent       bool
+	PlayerVersion   int
+	PlaylistVersion int
+}
+
+type RemovePlaylistVideoParams struct {
+	RoomId          string
+	VideoId         int
+	PlaylistVersion int
+}
+
+type ReorderPlaylistParams struct {
+	RoomId          string
+	VideoIds        []int
+	PlaylistVersion int
+}
+
+type PlayVideoParams struct {
+	RoomId        string
+	PlayerVersion int
+	Provider      string
+	Url           string
+	Title         string
+	AuthorName    string
+	ThumbnailUrl  string
+	AddedBy       string
+	StartTime     int
+	UpdatedAt     int
+	IsPlaying     bool
+	CurrentTime   int
+	PlaybackRate  float64
+	HistoryLimit  int
+}
+
+type PlayVideoResponse struct {
+	VideoId         int
+	PlayerVersion   int
+	PlaylistVersion int
+}
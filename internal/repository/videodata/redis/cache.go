@@ -0,0 +1,120 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sharetube/server/pkg/ytvideodata"
+)
+
+const (
+	resultFound         = "found"
+	resultNotFound      = "not_found"
+	resultNotEmbeddable = "not_embeddable"
+)
+
+type iFetcher interface {
+	GetVideoData(ctx context.Context, videoId string) (*ytvideodata.VideoData, error)
+}
+
+type cachedVideoData struct {
+	Result string                 `json:"result"`
+	Data   *ytvideodata.VideoData `json:"data,omitempty"`
+}
+
+type cache struct {
+	rc      *redis.Client
+	fetcher iFetcher
+	ttl     time.Duration
+	// ttl of not found and not embeddable results, zero disables their caching
+	negativeTtl time.Duration
+	logger      *slog.Logger
+}
+
+// NewCache decorates fetcher with cache shared by all rooms. Cache is best
+// effort, fetcher is called if redis fails.
+func NewCache(rc *redis.Client, fetcher iFetcher, ttl, negativeTtl time.Duration, logger *slog.Logger) *cache {
+	return &cache{
+		rc:          rc,
+		fetcher:     fetcher,
+		ttl:         ttl,
+		negativeTtl: negativeTtl,
+		logger:      logger,
+	}
+}
+
+func (c cache) getVideoDataKey(videoId string) string {
+	return fmt.Sprintf("video-data:youtube:%s", videoId)
+}
+
+func (c cache) GetVideoData(ctx context.Context, videoId string) (*ytvideodata.VideoData, error) {
+	key := c.getVideoDataKey(videoId)
+
+	cached, err := c.get(ctx, key)
+	if err != nil {
+		c.logger.WarnContext(ctx, "failed to get cached video data", "video_id", videoId, "error", err)
+	}
+
+	if cached != nil {
+		switch cached.Result {
+		case resultFound:
+			return cached.Data, nil
+		case resultNotFound:
+			return nil, ytvideodata.ErrVideoNotFound
+		case resultNotEmbeddable:
+			return nil, ytvideodata.ErrVideoNotEmbeddable
+		}
+	}
+
+	// only unwrapped sentinels are confirmed by upstream response, wrapped
+	// ones may come with transport or page failures and must be retried
+	videoData, err := c.fetcher.GetVideoData(ctx, videoId)
+	switch err {
+	case nil:
+		c.set(ctx, key, &cachedVideoData{Result: resultFound, Data: videoData}, c.ttl)
+	case ytvideodata.ErrVideoNotEmbeddable:
+		c.set(ctx, key, &cachedVideoData{Result: resultNotEmbeddable, Data: nil}, c.negativeTtl)
+	case ytvideodata.ErrVideoNotFound:
+		c.set(ctx, key, &cachedVideoData{Result: resultNotFound, Data: nil}, c.negativeTtl)
+	}
+
+	return videoData, err
+}
+
+func (c cache) get(ctx context.Context, key string) (*cachedVideoData, error) {
+	data, err := c.rc.Get(ctx, key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var cached cachedVideoData
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, err
+	}
+
+	return &cached, nil
+}
+
+func (c cache) set(ctx context.Context, key string, cached *cachedVideoData, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	data, err := json.Marshal(cached)
+	if err != nil {
+		c.logger.WarnContext(ctx, "failed to marshal video data", "key", key, "error", err)
+		return
+	}
+
+	if err := c.rc.Set(ctx, key, data, ttl).Err(); err != nil {
+		c.logger.WarnContext(ctx, "failed to cache video data", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,129 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/sharetube/server/pkg/ytvideodata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeFetcher struct {
+	results map[string]error
+	calls   map[string]int
+}
+
+func (f *fakeFetcher) GetVideoData(_ context.Context, videoId string) (*ytvideodata.VideoData, error) {
+	f.calls[videoId]++
+	if err := f.results[videoId]; err != nil {
+		return nil, err
+	}
+
+	return &ytvideodata.VideoData{
+		Title:        "title " + videoId,
+		AuthorName:   "author " + videoId,
+		ThumbnailUrl: "thumbnail " + videoId,
+	}, nil
+}
+
+func newTestCache(t *testing.T, negativeTtl time.Duration) (*cache, *fakeFetcher, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rc.Close() })
+
+	fetcher := &fakeFetcher{
+		results: map[string]error{
+			"notfound000": ytvideodata.ErrVideoNotFound,
+			"noembed0000": ytvideodata.ErrVideoNotEmbeddable,
+			"failing0000": errors.New("connection reset"),
+			"wrapped0000": fmt.Errorf("failed to get video data from page: %w: %w", ytvideodata.ErrVideoNotEmbeddable, errors.New("connection reset")),
+		},
+		calls: map[string]int{},
+	}
+
+	return NewCache(rc, fetcher, time.Hour, negativeTtl, slog.Default()), fetcher, mr
+}
+
+func TestCacheFound(t *testing.T) {
+	c, fetcher, mr := newTestCache(t, time.Minute)
+	ctx := context.Background()
+
+	for range 2 {
+		videoData, err := c.GetVideoData(ctx, "dQw4w9WgXcQ")
+		require.NoError(t, err)
+		assert.Equal(t, "title dQw4w9WgXcQ", videoData.Title)
+	}
+	assert.Equal(t, 1, fetcher.calls["dQw4w9WgXcQ"])
+
+	mr.FastForward(time.Hour)
+
+	_, err := c.GetVideoData(ctx, "dQw4w9WgXcQ")
+	require.NoError(t, err)
+	assert.Equal(t, 2, fetcher.calls["dQw4w9WgXcQ"])
+}
+
+func TestCacheNegative(t *testing.T) {
+	c, fetcher, mr := newTestCache(t, time.Minute)
+	ctx := context.Background()
+
+	for range 2 {
+		_, err := c.GetVideoData(ctx, "notfound000")
+		assert.ErrorIs(t, err, ytvideodata.ErrVideoNotFound)
+
+		_, err = c.GetVideoData(ctx, "noembed0000")
+		assert.ErrorIs(t, err, ytvideodata.ErrVideoNotEmbeddable)
+	}
+	assert.Equal(t, 1, fetcher.calls["notfound000"])
+	assert.Equal(t, 1, fetcher.calls["noembed0000"])
+
+	mr.FastForward(time.Minute)
+
+	_, err := c.GetVideoData(ctx, "notfound000")
+	assert.ErrorIs(t, err, ytvideodata.ErrVideoNotFound)
+	assert.Equal(t, 2, fetcher.calls["notfound000"])
+}
+
+func TestCacheNegativeDisabled(t *testing.T) {
+	c, fetcher, _ := newTestCache(t, 0)
+	ctx := context.Background()
+
+	for range 2 {
+		_, err := c.GetVideoData(ctx, "noembed0000")
+		assert.ErrorIs(t, err, ytvideodata.ErrVideoNotEmbeddable)
+	}
+	assert.Equal(t, 2, fetcher.calls["noembed0000"])
+}
+
+func TestCacheDoesNotCacheErrors(t *testing.T) {
+	c, fetcher, _ := newTestCache(t, time.Minute)
+	ctx := context.Background()
+
+	for range 2 {
+		_, err := c.GetVideoData(ctx, "failing0000")
+		assert.Error(t, err)
+
+		_, err = c.GetVideoData(ctx, "wrapped0000")
+		assert.ErrorIs(t, err, ytvideodata.ErrVideoNotEmbeddable)
+	}
+	assert.Equal(t, 2, fetcher.calls["failing0000"])
+	assert.Equal(t, 2, fetcher.calls["wrapped0000"])
+}
+
+func TestCacheFallsBackToFetcher(t *testing.T) {
+	c, fetcher, mr := newTestCache(t, time.Minute)
+	mr.Close()
+
+	videoData, err := c.GetVideoData(context.Background(), "dQw4w9WgXcQ")
+	require.NoError(t, err)
+	assert.Equal(t, "title dQw4w9WgXcQ", videoData.Title)
+	assert.Equal(t, 1, fetcher.calls["dQw4w9WgXcQ"])
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/sharetube/server/internal/repository/room"
+)
+
+// maxReportedAtSkew bounds difference between reported at and server time,
+// it covers clock sync error and delivery of report.
+const maxReportedAtSkew = 10 * time.Second
+
+type ReportPositionParams struct {
+	VideoId     int `json:"video_id"`
+	CurrentTime int `json:"current_time"`
+	// server time current time was measured at
+	ReportedAt int    `json:"reported_at"`
+	SenderId   string `json:"sender_id"`
+	RoomId     string `json:"room_id"`
+}
+
+type ReportPositionResponse struct {
+	// reported position minus expected one
+	Drift int
+	// set if member drifted beyond tolerance and has to seek to it
+	Resync *PlayerPosition
+}
+
+// ReportPosition compares position reported by member with position of
+// authoritative player at the time it was measured.
+func (s service) ReportPosition(ctx context.Context, params *ReportPositionParams) (*ReportPositionResponse, error) {
+	if _, err := s.roomRepo.GetMember(ctx, &room.GetMemberParams{
+		MemberId: params.SenderId,
+		RoomId:   params.RoomId,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to get member: %w", err)
+	}
+
+	now := s.getServerTime()
+	if err := validateStruct(ctx, params,
+		validation.Field(&params.VideoId, VideoIdRule...),
+		validation.Field(&params.CurrentTime, CurrentTimeRule...),
+		validation.Field(&params.ReportedAt,
+			validation.Min(now-int(maxReportedAtSkew.Microseconds())).Error("must be close to server time"),
+			validation.Max(now+int(maxReportedAtSkew.Microseconds())).Error("must be close to server time"),
+		),
+	); err != nil {
+		return nil, err
+	}
+
+	position, err := s.getPlayerPosition(ctx, params.RoomId, params.ReportedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get player position: %w", err)
+	}
+
+	drift := params.CurrentTime - position.CurrentTime
+	if params.VideoId == position.VideoId && abs(drift) <= position.DriftTolerance {
+		return &ReportPositionResponse{
+			Drift:  drift,
+			Resync: nil,
+		}, nil
+	}
+
+	// reported position may be measured in the past, member seeks to current one
+	resyncPosition, err := s.getPlayerPosition(ctx, params.RoomId, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get player position: %w", err)
+	}
+
+	return &ReportPositionResponse{
+		Drift:  drift,
+		Resync: resyncPosition,
+	}, nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
@@ -4,11 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/sharetube/server/internal/repository/room"
+	"github.com/sharetube/server/pkg/videoprovider"
 )
 
+// getServerTime returns server time in microseconds, player state is always
+// stamped with it, so timeline does not depend on member clocks.
+func (s service) getServerTime() int {
+	return int(time.Now().UnixMicro())
+}
+
 func (s service) getDefaultPlayerPlaybackRate() float64 {
 	return 1.0
 }
@@ -25,6 +33,10 @@ func (s service) getDefaultPlayerWaitingForReady() bool {
 	return false
 }
 
+func (s service) getDefaultRoomIsPersistent() bool {
+	return false
+}
+
 func (s service) getDefaultMemberIsMuted() bool {
 	return false
 }
@@ -33,6 +45,14 @@ func (s service) getDefaultMemberIsReady() bool {
 	return false
 }
 
+func (s service) getDefaultMemberIsBuffering() bool {
+	return false
+}
+
+func (s service) getDefaultRoomPauseOnBuffering() bool {
+	return false
+}
+
 func (s service) checkIfMemberAdmin(ctx context.Context, roomId, memberId string) error {
 	isAdmin, err := s.roomRepo.GetMemberIsAdmin(ctx, roomId, memberId)
 	if err != nil {
@@ -46,112 +66,193 @@ func (s service) checkIfMemberAdmin(ctx context.Context, roomId, memberId string
 	return nil
 }
 
-type updatePlayerVideoResponse struct {
-	Player   Player
-	Members  []Member
-	Playlist Playlist
-	Conns    []*websocket.Conn
+// mapRoomError maps repo errors caused by outdated or invalid client state to
+// service errors. Other errors are returned as is.
+func (s service) mapRoomError(err error) error {
+	switch {
+	case errors.Is(err, room.ErrPlayerVersionMismatch),
+		errors.Is(err, room.ErrPlaylistVersionMismatch),
+		errors.Is(err, room.ErrCurrentVideoMismatch),
+		errors.Is(err, room.ErrVideoAlreadyPlaying),
+		errors.Is(err, room.ErrVideoAlreadyEnded):
+		return fmt.Errorf("%w: %w", ErrVersionMismatch, err)
+	case errors.Is(err, room.ErrVideoNotFound):
+		return newFieldValidationError("video_id", err)
+	case errors.Is(err, room.ErrInvalidVideoIds):
+		return newFieldValidationError("video_ids", err)
+	case errors.Is(err, room.ErrPlaylistLimitReached):
+		return ErrPlaylistLimitReached
+	case errors.Is(err, room.ErrHistoryEntryNotFound):
+		return newFieldValidationError("history_id", err)
+	}
+
+	return err
 }
 
-func (s service) updatePlayerVideo(ctx context.Context, roomId string, videoId int, updatedAt int) (*updatePlayerVideoResponse, error) {
-	currentVideoId, err := s.roomRepo.GetCurrentVideoId(ctx, roomId)
+// resolveVideo resolves video url with video provider, field is name of url
+// param used in validation errors.
+func (s service) resolveVideo(ctx context.Context, field, videoUrl string) (*videoprovider.Video, error) {
+	video, err := s.videoProvider.Resolve(ctx, videoUrl)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get current video id: %w", err)
+		switch {
+		case errors.Is(err, videoprovider.ErrUnsupportedUrl):
+			return nil, newFieldValidationError(field, videoprovider.ErrUnsupportedUrl)
+		case errors.Is(err, videoprovider.ErrVideoNotFound):
+			return nil, newFieldValidationError(field, videoprovider.ErrVideoNotFound)
+		case errors.Is(err, videoprovider.ErrVideoNotEmbeddable):
+			return nil, fmt.Errorf("%w: %w", ErrVideoNotEmbeddable, err)
+		}
+
+		return nil, fmt.Errorf("failed to resolve video: %w", err)
 	}
 
-	if currentVideoId == videoId {
-		return nil, errors.New("video is already playing")
+	return video, nil
+}
+
+// getVideoStartTime returns start offset of resolved video in microseconds,
+// offset beyond known duration is ignored.
+func (s service) getVideoStartTime(video *videoprovider.Video) int {
+	if video.Duration > 0 && video.StartTime >= video.Duration {
+		return 0
 	}
 
-	video, err := s.roomRepo.GetVideo(ctx, &room.GetVideoParams{
-		VideoId: videoId,
-		RoomId:  roomId,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get video: %w", err)
+	return int(video.StartTime.Microseconds())
+}
+
+// getVideoProvider returns provider of stored video or history entry, videos
+// stored before provider are youtube videos.
+func (s service) getVideoProvider(provider string) string {
+	if provider == "" {
+		return videoprovider.YouTube
 	}
 
-	lastVideoId, err := s.roomRepo.GetLastVideoId(ctx, roomId)
+	return provider
+}
+
+// updatePlayerIsPlaying pauses or resumes player, position is frozen on pause,
+// so resumed player continues from it. Only player paused for ready members
+// is resumed, nil is returned if player is not changed, e.g. video ended.
+// Player changed concurrently is not overwritten, ErrVersionMismatch is
+// returned instead.
+func (s service) updatePlayerIsPlaying(ctx context.Context, roomId string, isPlaying, waitingForReady bool) (*Player, error) {
+	// read before player, so any change of it fails version check
+	playerVersion, err := s.roomRepo.GetPlayerVersion(ctx, roomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get last video id: %w", err)
+		return nil, fmt.Errorf("failed to get player version: %w", err)
 	}
 
-	if err := s.roomRepo.RemoveVideoFromList(ctx, &room.RemoveVideoFromListParams{
-		VideoId: videoId,
-		RoomId:  roomId,
-	}); err != nil && err != room.ErrVideoNotFound {
-		return nil, fmt.Errorf("failed to remove video from list: %w", err)
-	}
-	if lastVideoId != nil && *lastVideoId != videoId {
-		if err := s.roomRepo.RemoveVideo(ctx, &room.RemoveVideoParams{
-			VideoId: *lastVideoId,
-			RoomId:  roomId,
-		}); err != nil {
-			return nil, fmt.Errorf("failed to remove video: %w", err)
-		}
+	currentVideoId, err := s.roomRepo.GetCurrentVideoId(ctx, roomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current video id: %w", err)
 	}
 
-	if err := s.roomRepo.SetVideoEnded(ctx, &room.SetVideoEndedParams{
-		RoomId:     roomId,
-		VideoEnded: false,
-	}); err != nil {
-		return nil, fmt.Errorf("failed to set video ended: %w", err)
+	player, err := s.roomRepo.GetPlayer(ctx, roomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get player: %w", err)
 	}
 
-	// updating last video
-	if err := s.roomRepo.SetLastVideo(ctx, &room.SetLastVideoParams{
-		VideoId: currentVideoId,
-		RoomId:  roomId,
-	}); err != nil {
-		return nil, fmt.Errorf("failed to set last video: %w", err)
+	isEnded, err := s.roomRepo.GetVideoEnded(ctx, roomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get video ended: %w", err)
 	}
 
-	if err := s.roomRepo.SetCurrentVideoId(ctx, &room.SetCurrentVideoParams{
-		VideoId: videoId,
-		RoomId:  roomId,
-	}); err != nil {
-		return nil, fmt.Errorf("failed to update current video id: %w", err)
+	if isEnded || player.IsPlaying == isPlaying || (isPlaying && !player.WaitingForReady) {
+		return nil, nil
 	}
 
-	if err := s.roomRepo.UpdatePlayerUpdatedAt(ctx, roomId, updatedAt); err != nil {
-		return nil, fmt.Errorf("failed to update player updated at: %w", err)
+	now := s.getServerTime()
+	player.CurrentTime = s.getExpectedTime(&player, isEnded, now)
+	player.IsPlaying = isPlaying
+	player.UpdatedAt = now
+
+	playerVersion, err = s.roomRepo.SetPlayerIsPlaying(ctx, &room.SetPlayerIsPlayingParams{
+		RoomId:          roomId,
+		PlayerVersion:   playerVersion,
+		VideoId:         currentVideoId,
+		IsPlaying:       player.IsPlaying,
+		WaitingForReady: waitingForReady,
+		CurrentTime:     player.CurrentTime,
+		UpdatedAt:       player.UpdatedAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set player is playing: %w", s.mapRoomError(err))
 	}
 
-	isPlaying := s.getDefaultPlayerIsPlaying()
-	if err := s.roomRepo.UpdatePlayerIsPlaying(ctx, roomId, isPlaying); err != nil {
-		return nil, fmt.Errorf("failed to update player is playing: %w", err)
-	}
+	return &Player{
+		State: PlayerState{
+			CurrentTime:  player.CurrentTime,
+			IsPlaying:    player.IsPlaying,
+			PlaybackRate: player.PlaybackRate,
+			UpdatedAt:    player.UpdatedAt,
+		},
+		IsEnded: isEnded,
+		Version: playerVersion,
+	}, nil
+}
+
+// player changed concurrently is re-read at most that many times on resume
+const resumeAttempts = 3
 
-	currentTime := s.getDefaultPlayerCurrentTime()
-	if err := s.roomRepo.UpdatePlayerCurrentTime(ctx, roomId, currentTime); err != nil {
-		return nil, fmt.Errorf("failed to update player current time: %w", err)
+// resumeIfAllReady resumes player waiting for ready members once all of
+// members are ready, nil is returned if player is not changed. Resume is
+// a side effect of member update, so player changed meanwhile is re-read
+// instead of failing the update.
+func (s service) resumeIfAllReady(ctx context.Context, roomId string, members []Member) (*Player, error) {
+	if len(members) == 0 {
+		return nil, nil
 	}
 
-	playbackRate := s.getDefaultPlayerPlaybackRate()
-	if err := s.roomRepo.UpdatePlayerPlaybackRate(ctx, roomId, playbackRate); err != nil {
-		return nil, fmt.Errorf("failed to update player playback rate: %w", err)
+	for _, member := range members {
+		if !member.IsReady {
+			return nil, nil
+		}
 	}
 
-	if err := s.roomRepo.UpdatePlayerWaitingForReady(ctx, roomId, true); err != nil {
-		return nil, fmt.Errorf("failed to update player waiting for ready: %w", err)
+	for attempt := 1; ; attempt++ {
+		player, err := s.updatePlayerIsPlaying(ctx, roomId, true, false)
+		if errors.Is(err, ErrVersionMismatch) && attempt < resumeAttempts {
+			continue
+		}
+
+		return player, err
 	}
+}
 
-	playlistVersion, err := s.roomRepo.IncrPlaylistVersion(ctx, roomId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to incr playlist version: %w", err)
+type updatePlayerVideoResponse struct {
+	Player         Player
+	Members        []Member
+	MembersVersion int
+	Playlist       Playlist
+	MemberIds      []string
+}
+
+func (s service) updatePlayerVideo(ctx context.Context, roomId string, playerVersion int, videoId int) (*updatePlayerVideoResponse, error) {
+	if _, err := s.roomRepo.SwitchCurrentVideo(ctx, &room.SwitchCurrentVideoParams{
+		RoomId:        roomId,
+		PlayerVersion: playerVersion,
+		VideoId:       videoId,
+		UpdatedAt:     s.getServerTime(),
+		IsPlaying:     s.getDefaultPlayerIsPlaying(),
+		CurrentTime:   s.getDefaultPlayerCurrentTime(),
+		PlaybackRate:  s.getDefaultPlayerPlaybackRate(),
+		HistoryLimit:  s.historyLimit,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to switch current video: %w", s.mapRoomError(err))
 	}
 
-	videos, err := s.getVideos(ctx, roomId)
+	return s.getUpdatePlayerVideoResponse(ctx, roomId)
+}
+
+// getUpdatePlayerVideoResponse returns room state after current video was switched.
+func (s service) getUpdatePlayerVideoResponse(ctx context.Context, roomId string) (*updatePlayerVideoResponse, error) {
+	player, err := s.getPlayer(ctx, roomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get videos: %w", err)
+		return nil, fmt.Errorf("failed to get player: %w", err)
 	}
 
-	lastVideo, err := s.roomRepo.GetVideo(ctx, &room.GetVideoParams{
-		VideoId: currentVideoId,
-		RoomId:  roomId,
-	})
+	playlist, err := s.getPlaylist(ctx, roomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get last video: %w", err)
+		return nil, fmt.Errorf("failed to get playlist: %w", err)
 	}
 
 	memberIds, err := s.roomRepo.GetMemberIds(ctx, roomId)
@@ -159,67 +260,54 @@ func (s service) updatePlayerVideo(ctx context.Context, roomId string, videoId i
 		return nil, fmt.Errorf("failed to get member ids: %w", err)
 	}
 
-	for _, memberId := range memberIds {
-		if err := s.roomRepo.UpdateMemberIsReady(ctx, roomId, memberId, false); err != nil {
-			return nil, fmt.Errorf("failed to update member is ready: %w", err)
-		}
-	}
-
 	members, err := s.mapMembers(ctx, roomId, memberIds)
 	if err != nil {
 		return nil, fmt.Errorf("failed to map members: %w", err)
 	}
 
-	conns := make([]*websocket.Conn, 0, len(memberIds))
-	for _, memberId := range memberIds {
-		conn, err := s.connRepo.GetConn(memberId)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get conn: %w", err)
-		}
+	// incremented by switch script, which resets members ready state
+	membersVersion, err := s.roomRepo.GetMembersVersion(ctx, roomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get members version: %w", err)
+	}
+
+	return &updatePlayerVideoResponse{
+		Player:         *player,
+		Members:        members,
+		MembersVersion: membersVersion,
+		Playlist:       *playlist,
+		MemberIds:      memberIds,
+	}, nil
+}
 
-		conns = append(conns, conn)
+// findMovedVideo returns id and new index of video if newIds differ from
+// prevIds by moving single video.
+func (s service) findMovedVideo(prevIds, newIds []int) (int, int, bool) {
+	if len(prevIds) != len(newIds) {
+		return 0, 0, false
 	}
 
-	playerVersion, err := s.roomRepo.IncrPlayerVersion(ctx, roomId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to incr player version: %w", err)
+	first, last := 0, len(prevIds)-1
+	for first <= last && prevIds[first] == newIds[first] {
+		first++
+	}
+	for last >= first && prevIds[last] == newIds[last] {
+		last--
 	}
 
-	isEnded, err := s.roomRepo.GetVideoEnded(ctx, roomId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get video ended: %w", err)
+	if first >= last {
+		return 0, 0, false
 	}
 
-	return &updatePlayerVideoResponse{
-		Player: Player{
-			State: PlayerState{
-				CurrentTime:  currentTime,
-				IsPlaying:    isPlaying,
-				PlaybackRate: playbackRate,
-				UpdatedAt:    updatedAt,
-			},
-			IsEnded: isEnded,
-			Version: playerVersion,
-		},
-		Members: members,
-		Playlist: Playlist{
-			Videos: videos,
-			LastVideo: &Video{
-				Id:           currentVideoId,
-				Url:          lastVideo.Url,
-				Title:        lastVideo.Title,
-				AuthorName:   lastVideo.AuthorName,
-				ThumbnailUrl: lastVideo.ThumbnailUrl,
-			},
-			CurrentVideo: Video{
-				Id:           videoId,
-				Url:          video.Url,
-				Title:        video.Title,
-				AuthorName:   video.AuthorName,
-				ThumbnailUrl: video.ThumbnailUrl,
-			},
-			Version: playlistVersion,
-		},
-		Conns: conns,
-	}, nil
+	// moved forward from first to last
+	if newIds[last] == prevIds[first] && slices.Equal(prevIds[first+1:last+1], newIds[first:last]) {
+		return prevIds[first], last, true
+	}
+
+	// moved backward from last to first
+	if newIds[first] == prevIds[last] && slices.Equal(prevIds[first:last], newIds[first+1:last+1]) {
+		return prevIds[last], first, true
+	}
+
+	return 0, 0, false
 }
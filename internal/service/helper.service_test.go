@@ -0,0 +1,96 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindMovedVideo(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		prevIds []int
+		newIds  []int
+		videoId int
+		index   int
+		ok      bool
+	}{
+		{
+			name:    "forward",
+			prevIds: []int{1, 2, 3, 4, 5},
+			newIds:  []int{1, 3, 4, 2, 5},
+			videoId: 2,
+			index:   3,
+			ok:      true,
+		},
+		{
+			name:    "forward to end",
+			prevIds: []int{1, 2, 3},
+			newIds:  []int{2, 3, 1},
+			videoId: 1,
+			index:   2,
+			ok:      true,
+		},
+		{
+			name:    "backward",
+			prevIds: []int{1, 2, 3, 4, 5},
+			newIds:  []int{1, 4, 2, 3, 5},
+			videoId: 4,
+			index:   1,
+			ok:      true,
+		},
+		{
+			name:    "backward to start",
+			prevIds: []int{1, 2, 3},
+			newIds:  []int{3, 1, 2},
+			videoId: 3,
+			index:   0,
+			ok:      true,
+		},
+		{
+			name:    "adjacent swap",
+			prevIds: []int{1, 2, 3},
+			newIds:  []int{2, 1, 3},
+			videoId: 1,
+			index:   1,
+			ok:      true,
+		},
+		{
+			name:    "no-op",
+			prevIds: []int{1, 2, 3},
+			newIds:  []int{1, 2, 3},
+			ok:      false,
+		},
+		{
+			name:    "empty",
+			prevIds: []int{},
+			newIds:  []int{},
+			ok:      false,
+		},
+		{
+			name:    "multi-move",
+			prevIds: []int{1, 2, 3, 4, 5},
+			newIds:  []int{2, 1, 3, 5, 4},
+			ok:      false,
+		},
+		{
+			name:    "reversed",
+			prevIds: []int{1, 2, 3, 4},
+			newIds:  []int{4, 3, 2, 1},
+			ok:      false,
+		},
+		{
+			name:    "different length",
+			prevIds: []int{1, 2, 3},
+			newIds:  []int{1, 2},
+			ok:      false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			videoId, index, ok := service{}.findMovedVideo(tc.prevIds, tc.newIds)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.videoId, videoId)
+			assert.Equal(t, tc.index, index)
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sharetube/server/internal/repository/room"
+)
+
+func (s service) GetHistory(ctx context.Context, roomId string) ([]HistoryEntry, error) {
+	if err := s.checkIfRoomExists(ctx, roomId); err != nil {
+		return nil, err
+	}
+
+	history, err := s.roomRepo.GetHistory(ctx, roomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history: %w", err)
+	}
+
+	entries := make([]HistoryEntry, 0, len(history))
+	for _, entry := range history {
+		entries = append(entries, HistoryEntry{
+			Id:           entry.Id,
+			Provider:     s.getVideoProvider(entry.Provider),
+			Url:          entry.Url,
+			Title:        entry.Title,
+			AuthorName:   entry.AuthorName,
+			ThumbnailUrl: entry.ThumbnailUrl,
+			AddedBy:      entry.AddedBy,
+			PlayedAt:     entry.PlayedAt,
+			StartTime:    entry.StartTime,
+		})
+	}
+
+	return entries, nil
+}
+
+type RequeueHistoryVideoParams struct {
+	HistoryId       int    `json:"history_id"`
+	PlaylistVersion int    `json:"playlist_version"`
+	PlayerVersion   int    `json:"player_version"`
+	SenderId        string `json:"sender_id"`
+	RoomId          string `json:"room_id"`
+}
+
+// RequeueHistoryVideo adds video of history entry to the end of playlist as
+// queued by sender.
+func (s service) RequeueHistoryVideo(ctx context.Context, params *RequeueHistoryVideoParams) (*AddVideoResponse, error) {
+	if err := s.checkIfMemberAdmin(ctx, params.RoomId, params.SenderId); err != nil {
+		return nil, err
+	}
+
+	entry, err := s.roomRepo.GetHistoryEntry(ctx, &room.GetHistoryEntryParams{
+		RoomId:  params.RoomId,
+		EntryId: params.HistoryId,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history entry: %w", s.mapRoomError(err))
+	}
+
+	return s.addVideo(ctx, params.SenderId, &room.AddVideoParams{
+		RoomId:          params.RoomId,
+		PlayerVersion:   params.PlayerVersion,
+		PlaylistVersion: params.PlaylistVersion,
+		PlaylistLimit:   s.playlistLimit,
+		Provider:        s.getVideoProvider(entry.Provider),
+		Url:             entry.Url,
+		Title:           entry.Title,
+		AuthorName:      entry.AuthorName,
+		ThumbnailUrl:    entry.ThumbnailUrl,
+		AddedBy:         params.SenderId,
+		StartTime:       entry.StartTime,
+		UpdatedAt:       s.getServerTime(),
+		IsPlaying:       s.getDefaultPlayerIsPlaying(),
+		CurrentTime:     s.getDefaultPlayerCurrentTime(),
+		PlaybackRate:    s.getDefaultPlayerPlaybackRate(),
+		HistoryLimit:    s.historyLimit,
+	})
+}
+
+type PlayHistoryVideoParams struct {
+	HistoryId     int    `json:"history_id"`
+	PlayerVersion int    `json:"player_version"`
+	SenderId      string `json:"sender_id"`
+	RoomId        string `json:"room_id"`
+}
+
+// PlayHistoryVideo jumps back to video of history entry, playlist is kept.
+func (s service) PlayHistoryVideo(ctx context.Context, params *PlayHistoryVideoParams) (*UpdatePlayerVideoResponse, error) {
+	if err := s.checkIfMemberAdmin(ctx, params.RoomId, params.SenderId); err != nil {
+		return nil, err
+	}
+
+	entry, err := s.roomRepo.GetHistoryEntry(ctx, &room.GetHistoryEntryParams{
+		RoomId:  params.RoomId,
+		EntryId: params.HistoryId,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history entry: %w", s.mapRoomError(err))
+	}
+
+	if _, err := s.roomRepo.PlayVideo(ctx, &room.PlayVideoParams{
+		RoomId:        params.RoomId,
+		PlayerVersion: params.PlayerVersion,
+		Provider:      s.getVideoProvider(entry.Provider),
+		Url:           entry.Url,
+		Title:         entry.Title,
+		AuthorName:    entry.AuthorName,
+		ThumbnailUrl:  entry.ThumbnailUrl,
+		AddedBy:       params.SenderId,
+		StartTime:     entry.StartTime,
+		UpdatedAt:     s.getServerTime(),
+		IsPlaying:     s.getDefaultPlayerIsPlaying(),
+		CurrentTime:   s.getDefaultPlayerCurrentTime(),
+		PlaybackRate:  s.getDefaultPlayerPlaybackRate(),
+		HistoryLimit:  s.historyLimit,
+	}); err != nil {
+		if errors.Is(err, room.ErrPlayerVersionMismatch) {
+			player, err := s.getPlayer(ctx, params.RoomId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get player: %w", err)
+			}
+
+			return &UpdatePlayerVideoResponse{
+				MemberIds: []string{params.SenderId},
+				PlayerVersionMismatchResponse: &PlayerVersionMismatchResponse{
+					Player: *player,
+				},
+				PlayerVideoUpdatedResponse: nil,
+			}, nil
+		}
+
+		return nil, fmt.Errorf("failed to play video: %w", s.mapRoomError(err))
+	}
+
+	updatePlayerVideoRes, err := s.getUpdatePlayerVideoResponse(ctx, params.RoomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get update player video response: %w", err)
+	}
+
+	return &UpdatePlayerVideoResponse{
+		MemberIds: updatePlayerVideoRes.MemberIds,
+		PlayerVideoUpdatedResponse: &PlayerVideoUpdatedResponse{
+			Playlist:       updatePlayerVideoRes.Playlist,
+			Player:         updatePlayerVideoRes.Player,
+			Members:        updatePlayerVideoRes.Members,
+			MembersVersion: updatePlayerVideoRes.MembersVersion,
+		},
+		PlayerVersionMismatchResponse: nil,
+	}, nil
+}
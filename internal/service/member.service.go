@@ -4,10 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
-	"github.com/gorilla/websocket"
+	"github.com/google/uuid"
 	"github.com/sharetube/server/internal/repository/room"
 	"github.com/skewb1k/goutils/optional"
 )
@@ -26,13 +27,14 @@ func (s service) mapMembers(ctx context.Context, roomId string, memberIds []stri
 		}
 
 		members = append(members, Member{
-			Id:        memberId,
-			Username:  member.Username,
-			Color:     member.Color,
-			AvatarUrl: member.AvatarUrl,
-			IsMuted:   member.IsMuted,
-			IsAdmin:   member.IsAdmin,
-			IsReady:   member.IsReady,
+			Id:          memberId,
+			Username:    member.Username,
+			Color:       member.Color,
+			AvatarUrl:   member.AvatarUrl,
+			IsMuted:     member.IsMuted,
+			IsAdmin:     member.IsAdmin,
+			IsReady:     member.IsReady,
+			IsBuffering: member.IsBuffering,
 		})
 	}
 
@@ -55,9 +57,9 @@ type RemoveMemberParams struct {
 }
 
 type RemoveMemberResponse struct {
-	Conn    *websocket.Conn
-	Conns   []*websocket.Conn
-	Members []Member
+	MemberIds      []string
+	Members        []Member
+	MembersVersion int
 }
 
 func (s service) RemoveMember(ctx context.Context, params *RemoveMemberParams) (*RemoveMemberResponse, error) {
@@ -65,7 +67,7 @@ func (s service) RemoveMember(ctx context.Context, params *RemoveMemberParams) (
 		return nil, err
 	}
 
-	if err := validation.ValidateStructWithContext(ctx, params,
+	if err := validateStruct(ctx, params,
 		validation.Field(&params.RemovedMemberId, MemberIdRule...),
 	); err != nil {
 		return nil, err
@@ -85,14 +87,14 @@ func (s service) RemoveMember(ctx context.Context, params *RemoveMemberParams) (
 		return nil, fmt.Errorf("failed to remove member: %w", err)
 	}
 
-	conn, err := s.connRepo.RemoveByMemberId(params.RemovedMemberId)
+	membersVersion, err := s.roomRepo.IncrMembersVersion(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to remove conn: %w", err)
+		return nil, fmt.Errorf("failed to incr members version: %w", err)
 	}
 
-	conns, err := s.getConns(ctx, params.RoomId)
+	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get conns: %w", err)
+		return nil, fmt.Errorf("failed to get member ids: %w", err)
 	}
 
 	members, err := s.getMembers(ctx, params.RoomId)
@@ -101,9 +103,9 @@ func (s service) RemoveMember(ctx context.Context, params *RemoveMemberParams) (
 	}
 
 	return &RemoveMemberResponse{
-		Conn:    conn,
-		Conns:   conns,
-		Members: members,
+		MemberIds:      memberIds,
+		Members:        members,
+		MembersVersion: membersVersion,
 	}, nil
 }
 
@@ -114,10 +116,10 @@ type PromoteMemberParams struct {
 }
 
 type PromoteMemberResponse struct {
-	PromotedMember     Member
-	PromotedMemberConn *websocket.Conn
-	Members            []Member
-	Conns              []*websocket.Conn
+	PromotedMember Member
+	Members        []Member
+	MembersVersion int
+	MemberIds      []string
 }
 
 func (s service) PromoteMember(ctx context.Context, params *PromoteMemberParams) (*PromoteMemberResponse, error) {
@@ -125,7 +127,7 @@ func (s service) PromoteMember(ctx context.Context, params *PromoteMemberParams)
 		return nil, err
 	}
 
-	if err := validation.ValidateStructWithContext(ctx, params,
+	if err := validateStruct(ctx, params,
 		validation.Field(&params.PromotedMemberId, MemberIdRule...),
 	); err != nil {
 		return nil, err
@@ -150,167 +152,183 @@ func (s service) PromoteMember(ctx context.Context, params *PromoteMemberParams)
 	}
 	member.IsAdmin = updatedMemberIsAdmin
 
-	// todo: refactor by do not use getConnsByRoomId to save conn inside for
-	conns, err := s.getConns(ctx, params.RoomId)
+	membersVersion, err := s.roomRepo.IncrMembersVersion(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get conns: %w", err)
+		return nil, fmt.Errorf("failed to incr members version: %w", err)
 	}
 
-	members, err := s.getMembers(ctx, params.RoomId)
+	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get members: %w", err)
+		return nil, fmt.Errorf("failed to get member ids: %w", err)
 	}
 
-	promotedMemberConn, err := s.connRepo.GetConn(params.PromotedMemberId)
+	members, err := s.getMembers(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get conn: %w", err)
+		return nil, fmt.Errorf("failed to get members: %w", err)
 	}
 
 	return &PromoteMemberResponse{
-		Conns:              conns,
-		PromotedMemberConn: promotedMemberConn,
+		MemberIds: memberIds,
 		PromotedMember: Member{
-			Id:        params.PromotedMemberId,
-			Username:  member.Username,
-			Color:     member.Color,
-			AvatarUrl: member.AvatarUrl,
-			IsMuted:   member.IsMuted,
-			IsAdmin:   member.IsAdmin,
-			IsReady:   member.IsReady,
+			Id:          params.PromotedMemberId,
+			Username:    member.Username,
+			Color:       member.Color,
+			AvatarUrl:   member.AvatarUrl,
+			IsMuted:     member.IsMuted,
+			IsAdmin:     member.IsAdmin,
+			IsReady:     member.IsReady,
+			IsBuffering: member.IsBuffering,
 		},
-		Members: members,
+		Members:        members,
+		MembersVersion: membersVersion,
 	}, nil
 }
 
-type ConnectMemberParams struct {
-	Conn     *websocket.Conn
+type SuspendMemberParams struct {
 	MemberId string
+	RoomId   string
+	// conn being closed, see JoinRoomResponse.ConnId
+	ConnId string
 }
 
-func (s service) ConnectMember(ctx context.Context, params *ConnectMemberParams) error {
-	if err := s.connRepo.Add(params.Conn, params.MemberId); err != nil {
-		return fmt.Errorf("failed to connect member: %w", err)
+type SuspendMemberResponse struct {
+	// passed to DisconnectMember after grace period
+	Token       string
+	GracePeriod time.Duration
+	MemberIds   []string
+	// set if player waited for suspended member only and was resumed
+	Player *Player
+	// member has already joined with newer conn, nothing was changed
+	IsMemberReconnected bool
+}
+
+// SuspendMember keeps disconnected member in the room for reconnect grace
+// period. Member slot, ready state and admin role are restored if member joins
+// with its jwt before DisconnectMember is called with returned token.
+// Suspended member does not hold player waiting for ready members.
+func (s service) SuspendMember(ctx context.Context, params *SuspendMemberParams) (*SuspendMemberResponse, error) {
+	if s.reconnectGracePeriod == 0 {
+		return &SuspendMemberResponse{
+			Token:               "",
+			GracePeriod:         0,
+			MemberIds:           nil,
+			Player:              nil,
+			IsMemberReconnected: false,
+		}, nil
+	}
+
+	token := uuid.NewString()
+	suspended, err := s.roomRepo.SetMemberDisconnected(ctx, &room.SetMemberDisconnectedParams{
+		MemberId: params.MemberId,
+		RoomId:   params.RoomId,
+		ConnId:   params.ConnId,
+		Token:    token,
+		// outlives grace period, so it is never expired before DisconnectMember
+		ExpireAt: time.Now().Add(s.reconnectGracePeriod + s.roomExp),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set member disconnected: %w", err)
+	}
+
+	if !suspended {
+		return &SuspendMemberResponse{
+			IsMemberReconnected: true,
+		}, nil
+	}
+
+	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get member ids: %w", err)
+	}
+
+	members, err := s.getMembers(ctx, params.RoomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get members: %w", err)
 	}
 
-	return nil
+	members = slices.DeleteFunc(members, func(member Member) bool {
+		return member.Id == params.MemberId
+	})
+
+	player, err := s.resumeIfAllReady(ctx, params.RoomId, members)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SuspendMemberResponse{
+		Token:       token,
+		GracePeriod: s.reconnectGracePeriod,
+		MemberIds:   memberIds,
+		Player:      player,
+	}, nil
 }
 
 type DisconnectMemberParams struct {
 	MemberId string
 	RoomId   string
+	// conn being closed, see JoinRoomResponse.ConnId
+	ConnId string
+	// token from SuspendMember, empty if member was not suspended
+	Token string
 }
 
 type DisconnectMemberResponse struct {
-	Conns              []*websocket.Conn
-	Members            []Member
-	PromotedMemberConn *websocket.Conn
-	IsRoomDeleted      bool
+	MemberIds        []string
+	Members          []Member
+	MembersVersion   int
+	PromotedMemberId string
+	IsRoomDeleted    bool
+	// member has reconnected during grace period or joined with newer conn
+	// before closed one was suspended, nothing was changed
+	IsMemberReconnected bool
+	// set if player waited for removed member only and was resumed
+	Player *Player
 }
 
 func (s service) DisconnectMember(ctx context.Context, params *DisconnectMemberParams) (*DisconnectMemberResponse, error) {
-	if err := s.roomRepo.RemoveMemberFromList(ctx, &room.RemoveMemberFromListParams{
+	disconnected, err := s.roomRepo.DisconnectMember(ctx, &room.DisconnectMemberParams{
 		MemberId: params.MemberId,
 		RoomId:   params.RoomId,
-	}); err != nil {
-		return nil, fmt.Errorf("failed to remove member from list: %w", err)
+		ConnId:   params.ConnId,
+		Token:    params.Token,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to disconnect member: %w", err)
 	}
 
-	expireAt := time.Now().Add(s.roomExp)
-
-	//?
-	// if err := s.roomRepo.ExpireMember(ctx, &room.ExpireMemberParams{
-	// 	MemberId: params.MemberId,
-	// 	RoomId:   params.RoomId,
-	// 	ExpireAt: expireAt,
-	// }); err != nil {
-	// 	return nil, fmt.Errorf("failed to expire member: %w", err)
-	// }
-
-	if _, err := s.connRepo.RemoveByMemberId(params.MemberId); err != nil {
-		return nil, fmt.Errorf("failed to remove conn: %w", err)
+	if !disconnected {
+		return &DisconnectMemberResponse{
+			IsMemberReconnected: true,
+		}, nil
 	}
 
+	expireAt := time.Now().Add(s.roomExp)
+
 	members, err := s.getMembers(ctx, params.RoomId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get members: %w", err)
 	}
 
-	// delete room if no member left
+	// delete room if no member left, persistent room is kept to be reopened later
 	if len(members) == 0 {
-		videoIds, err := s.roomRepo.GetVideoIds(ctx, params.RoomId)
+		settings, err := s.roomRepo.GetSettings(ctx, params.RoomId)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get video ids: %w", err)
+			return nil, fmt.Errorf("failed to get settings: %w", err)
 		}
 
-		lastVideoId, err := s.roomRepo.GetLastVideoId(ctx, params.RoomId)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get last video id: %w", err)
-		}
-
-		if lastVideoId != nil {
-			videoIds = append(videoIds, *lastVideoId)
-		}
-
-		playerVideoId, err := s.roomRepo.GetCurrentVideoId(ctx, params.RoomId)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get player video id: %w", err)
+		if settings.IsPersistent {
+			expireAt = time.Time{}
+			if s.persistentRoomExp != 0 {
+				expireAt = time.Now().Add(s.persistentRoomExp)
+			}
 		}
 
-		videoIds = append(videoIds, playerVideoId)
-
-		for _, videoId := range videoIds {
-			if err := s.roomRepo.ExpireVideo(ctx, &room.ExpireVideoParams{
-				VideoId:  videoId,
+		if !expireAt.IsZero() {
+			if err := s.roomRepo.ExpireRoom(ctx, &room.ExpireRoomParams{
 				RoomId:   params.RoomId,
 				ExpireAt: expireAt,
 			}); err != nil {
-				return nil, fmt.Errorf("failed to expire video: %w", err)
-			}
-		}
-
-		if err := s.roomRepo.ExpireMembers(ctx, &room.ExpireMembersParams{
-			RoomId:   params.RoomId,
-			ExpireAt: expireAt,
-		}); err != nil {
-			return nil, fmt.Errorf("failed to expire members: %w", err)
-		}
-
-		if err := s.roomRepo.ExpirePlayer(ctx, &room.ExpirePlayerParams{
-			RoomId:   params.RoomId,
-			ExpireAt: expireAt,
-		}); err != nil {
-			return nil, fmt.Errorf("failed to expire player: %w", err)
-		}
-
-		if err := s.roomRepo.ExpireVideoEnded(ctx, &room.ExpireVideoEndedParams{
-			RoomId:   params.RoomId,
-			ExpireAt: expireAt,
-		}); err != nil {
-			return nil, fmt.Errorf("failed to expire video ended: %w", err)
-		}
-
-		if err := s.roomRepo.ExpirePlayerVersion(ctx, &room.ExpirePlayerVersionParams{
-			RoomId:   params.RoomId,
-			ExpireAt: expireAt,
-		}); err != nil {
-			return nil, fmt.Errorf("failed to expire player version: %w", err)
-		}
-
-		if err := s.roomRepo.ExpireLastVideo(ctx, &room.ExpireLastVideoParams{
-			RoomId:   params.RoomId,
-			ExpireAt: expireAt,
-		}); err != nil {
-			if err != room.ErrLastVideoNotFound {
-				return nil, fmt.Errorf("failed to expire last video: %w", err)
-			}
-		}
-
-		if err := s.roomRepo.ExpirePlaylist(ctx, &room.ExpirePlaylistParams{
-			RoomId:   params.RoomId,
-			ExpireAt: expireAt,
-		}); err != nil {
-			if err != room.ErrPlaylistNotFound {
-				return nil, fmt.Errorf("failed to expire playlist: %w", err)
+				return nil, fmt.Errorf("failed to expire room: %w", err)
 			}
 		}
 
@@ -319,30 +337,39 @@ func (s service) DisconnectMember(ctx context.Context, params *DisconnectMemberP
 		}, nil
 	}
 
-	conns, err := s.getConns(ctx, params.RoomId)
+	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get conns: %w", err)
+		return nil, fmt.Errorf("failed to get member ids: %w", err)
 	}
 
 	// promote single left member to admin
+	promotedMemberId := ""
 	if len(members) == 1 && !members[0].IsAdmin {
+		promotedMemberId = members[0].Id
 		if err := s.roomRepo.UpdateMemberIsAdmin(ctx, params.RoomId, members[0].Id, true); err != nil {
 			return nil, fmt.Errorf("failed to update member is admin: %w", err)
 		}
 		members[0].IsAdmin = true
+	}
 
-		return &DisconnectMemberResponse{
-			PromotedMemberConn: conns[0],
-			Conns:              conns,
-			Members:            members,
-			IsRoomDeleted:      false,
-		}, nil
+	// single version covers both removal and promotion
+	membersVersion, err := s.roomRepo.IncrMembersVersion(ctx, params.RoomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to incr members version: %w", err)
+	}
+
+	player, err := s.resumeIfAllReady(ctx, params.RoomId, members)
+	if err != nil {
+		return nil, err
 	}
 
 	return &DisconnectMemberResponse{
-		Conns:         conns,
-		Members:       members,
-		IsRoomDeleted: false,
+		PromotedMemberId: promotedMemberId,
+		MemberIds:        memberIds,
+		Members:          members,
+		MembersVersion:   membersVersion,
+		IsRoomDeleted:    false,
+		Player:           player,
 	}, nil
 }
 
@@ -355,13 +382,14 @@ type UpdateProfileParams struct {
 }
 
 type UpdateProfileResponse struct {
-	Conns         []*websocket.Conn
-	UpdatedMember Member
-	Members       []Member
+	MemberIds      []string
+	UpdatedMember  Member
+	Members        []Member
+	MembersVersion int
 }
 
 func (s service) UpdateProfile(ctx context.Context, params *UpdateProfileParams) (*UpdateProfileResponse, error) {
-	if err := validation.ValidateStructWithContext(ctx, params,
+	if err := validateStruct(ctx, params,
 		validation.Field(&params.Username, UsernameRule...),
 		validation.Field(&params.Color, ColorRule...),
 		// todo: add validation
@@ -403,9 +431,9 @@ func (s service) UpdateProfile(ctx context.Context, params *UpdateProfileParams)
 	}
 
 	// todo: fix double get ids
-	conns, err := s.getConns(ctx, params.RoomId)
+	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get conns: %w", err)
+		return nil, fmt.Errorf("failed to get member ids: %w", err)
 	}
 
 	members, err := s.getMembers(ctx, params.RoomId)
@@ -413,33 +441,40 @@ func (s service) UpdateProfile(ctx context.Context, params *UpdateProfileParams)
 		return nil, fmt.Errorf("failed to get members: %w", err)
 	}
 
+	membersVersion, err := s.roomRepo.IncrMembersVersion(ctx, params.RoomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to incr members version: %w", err)
+	}
+
 	return &UpdateProfileResponse{
-		Conns: conns,
+		MemberIds: memberIds,
 		UpdatedMember: Member{
-			Id:        params.SenderId,
-			Username:  member.Username,
-			Color:     member.Color,
-			AvatarUrl: member.AvatarUrl,
-			IsMuted:   member.IsMuted,
-			IsAdmin:   member.IsAdmin,
-			IsReady:   member.IsReady,
+			Id:          params.SenderId,
+			Username:    member.Username,
+			Color:       member.Color,
+			AvatarUrl:   member.AvatarUrl,
+			IsMuted:     member.IsMuted,
+			IsAdmin:     member.IsAdmin,
+			IsReady:     member.IsReady,
+			IsBuffering: member.IsBuffering,
 		},
-		Members: members,
+		Members:        members,
+		MembersVersion: membersVersion,
 	}, nil
 }
 
 type UpdateIsReadyParams struct {
-	SenderConn *websocket.Conn `json:"sender_conn"`
-	IsReady    bool            `json:"is_ready"`
-	SenderId   string          `json:"sender_id"`
-	RoomId     string          `json:"room_id"`
+	IsReady  bool   `json:"is_ready"`
+	SenderId string `json:"sender_id"`
+	RoomId   string `json:"room_id"`
 }
 
 type UpdateIsReadyResponse struct {
-	Conns         []*websocket.Conn
-	UpdatedMember Member
-	Members       []Member
-	Player        *Player
+	MemberIds      []string
+	UpdatedMember  Member
+	Members        []Member
+	MembersVersion int
+	Player         *Player
 }
 
 func (s service) UpdateIsReady(ctx context.Context, params *UpdateIsReadyParams) (*UpdateIsReadyResponse, error) {
@@ -457,18 +492,25 @@ func (s service) UpdateIsReady(ctx context.Context, params *UpdateIsReadyParams)
 			return nil, fmt.Errorf("failed to get members: %w", err)
 		}
 
+		membersVersion, err := s.roomRepo.GetMembersVersion(ctx, params.RoomId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get members version: %w", err)
+		}
+
 		return &UpdateIsReadyResponse{
 			UpdatedMember: Member{
-				Id:        params.SenderId,
-				Username:  member.Username,
-				Color:     member.Color,
-				AvatarUrl: member.AvatarUrl,
-				IsMuted:   member.IsMuted,
-				IsAdmin:   member.IsAdmin,
-				IsReady:   member.IsReady,
+				Id:          params.SenderId,
+				Username:    member.Username,
+				Color:       member.Color,
+				AvatarUrl:   member.AvatarUrl,
+				IsMuted:     member.IsMuted,
+				IsAdmin:     member.IsAdmin,
+				IsReady:     member.IsReady,
+				IsBuffering: member.IsBuffering,
 			},
-			Members: members,
-			Conns:   []*websocket.Conn{params.SenderConn},
+			Members:        members,
+			MembersVersion: membersVersion,
+			MemberIds:      []string{params.SenderId},
 		}, nil
 	}
 
@@ -476,10 +518,22 @@ func (s service) UpdateIsReady(ctx context.Context, params *UpdateIsReadyParams)
 		return nil, fmt.Errorf("failed to update member is ready: %w", err)
 	}
 
+	// member which is ready again is no longer buffering
+	if member.IsBuffering && params.IsReady {
+		if err := s.roomRepo.UpdateMemberIsBuffering(ctx, params.RoomId, params.SenderId, false); err != nil {
+			return nil, fmt.Errorf("failed to update member is buffering: %w", err)
+		}
+	}
+
+	membersVersion, err := s.roomRepo.IncrMembersVersion(ctx, params.RoomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to incr members version: %w", err)
+	}
+
 	// todo: fix double get ids
-	conns, err := s.getConns(ctx, params.RoomId)
+	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get conns: %w", err)
+		return nil, fmt.Errorf("failed to get member ids: %w", err)
 	}
 
 	members, err := s.getMembers(ctx, params.RoomId)
@@ -488,99 +542,143 @@ func (s service) UpdateIsReady(ctx context.Context, params *UpdateIsReadyParams)
 	}
 
 	updatedMember := Member{
-		Id:        params.SenderId,
-		Username:  member.Username,
-		Color:     member.Color,
-		AvatarUrl: member.AvatarUrl,
-		IsMuted:   member.IsMuted,
-		IsAdmin:   member.IsAdmin,
-		IsReady:   params.IsReady,
-	}
-
-	neededIsReady := members[0].IsReady
-	if neededIsReady {
-		ok := true
-		for i := 1; i < len(members); i++ {
-			if members[i].IsReady != neededIsReady {
-				ok = false
-				break
-			}
+		Id:          params.SenderId,
+		Username:    member.Username,
+		Color:       member.Color,
+		AvatarUrl:   member.AvatarUrl,
+		IsMuted:     member.IsMuted,
+		IsAdmin:     member.IsAdmin,
+		IsReady:     params.IsReady,
+		IsBuffering: member.IsBuffering && !params.IsReady,
+	}
+
+	player, err := s.resumeIfAllReady(ctx, params.RoomId, members)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UpdateIsReadyResponse{
+		MemberIds:      memberIds,
+		UpdatedMember:  updatedMember,
+		Members:        members,
+		MembersVersion: membersVersion,
+		Player:         player,
+	}, nil
+}
+
+type StartBufferingParams struct {
+	SenderId string `json:"sender_id"`
+	RoomId   string `json:"room_id"`
+}
+
+type StartBufferingResponse struct {
+	MemberIds      []string
+	UpdatedMember  Member
+	Members        []Member
+	MembersVersion int
+	// set if player was paused, see RoomSettings.PauseOnBuffering
+	Player *Player
+}
+
+// StartBuffering marks member as buffering and not ready. If room pauses on
+// buffering, player waits for ready members and resumes as after video switch,
+// see UpdateIsReady.
+func (s service) StartBuffering(ctx context.Context, params *StartBufferingParams) (*StartBufferingResponse, error) {
+	member, err := s.roomRepo.GetMember(ctx, &room.GetMemberParams{
+		MemberId: params.SenderId,
+		RoomId:   params.RoomId,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get member: %w", err)
+	}
+
+	updatedMember := Member{
+		Id:          params.SenderId,
+		Username:    member.Username,
+		Color:       member.Color,
+		AvatarUrl:   member.AvatarUrl,
+		IsMuted:     member.IsMuted,
+		IsAdmin:     member.IsAdmin,
+		IsReady:     false,
+		IsBuffering: true,
+	}
+
+	if member.IsBuffering {
+		members, err := s.getMembers(ctx, params.RoomId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get members: %w", err)
 		}
 
-		if ok {
-			player, err := s.roomRepo.GetPlayer(ctx, params.RoomId)
-			if err != nil {
-				return nil, fmt.Errorf("failed to get player: %w", err)
-			}
+		membersVersion, err := s.roomRepo.GetMembersVersion(ctx, params.RoomId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get members version: %w", err)
+		}
 
-			if player.IsPlaying == neededIsReady {
-				return &UpdateIsReadyResponse{
-					Conns:         conns,
-					UpdatedMember: updatedMember,
-					Members:       members,
-				}, nil
-			}
+		return &StartBufferingResponse{
+			MemberIds:      []string{params.SenderId},
+			UpdatedMember:  updatedMember,
+			Members:        members,
+			MembersVersion: membersVersion,
+			Player:         nil,
+		}, nil
+	}
 
-			if player.WaitingForReady == neededIsReady {
-				player.IsPlaying = neededIsReady
-				player.UpdatedAt = int(time.Now().UnixMicro())
-
-				// todo: check isEnded
-				if err := s.roomRepo.UpdatePlayerWaitingForReady(ctx, params.RoomId, !player.WaitingForReady); err != nil {
-					return nil, fmt.Errorf("failed to update player waiting for ready: %w", err)
-				}
-
-				if err := s.roomRepo.UpdatePlayerIsPlaying(ctx, params.RoomId, params.IsReady); err != nil {
-					return nil, fmt.Errorf("failed to update player is playing: %w", err)
-				}
-
-				playerVersion, err := s.roomRepo.IncrPlayerVersion(ctx, params.RoomId)
-				if err != nil {
-					return nil, fmt.Errorf("failed to incr player version: %w", err)
-				}
-
-				isEnded, err := s.roomRepo.GetVideoEnded(ctx, params.RoomId)
-				if err != nil {
-					return nil, fmt.Errorf("failed to get video ended: %w", err)
-				}
-
-				return &UpdateIsReadyResponse{
-					Conns:         conns,
-					UpdatedMember: updatedMember,
-					Members:       members,
-					Player: &Player{
-						State: PlayerState{
-							CurrentTime:  player.CurrentTime,
-							IsPlaying:    player.IsPlaying,
-							PlaybackRate: player.PlaybackRate,
-							UpdatedAt:    player.UpdatedAt,
-						},
-						IsEnded: isEnded,
-						Version: playerVersion,
-					},
-				}, nil
-			}
+	if err := s.roomRepo.UpdateMemberIsBuffering(ctx, params.RoomId, params.SenderId, true); err != nil {
+		return nil, fmt.Errorf("failed to update member is buffering: %w", err)
+	}
+
+	if err := s.roomRepo.UpdateMemberIsReady(ctx, params.RoomId, params.SenderId, false); err != nil {
+		return nil, fmt.Errorf("failed to update member is ready: %w", err)
+	}
+
+	membersVersion, err := s.roomRepo.IncrMembersVersion(ctx, params.RoomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to incr members version: %w", err)
+	}
+
+	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get member ids: %w", err)
+	}
+
+	members, err := s.getMembers(ctx, params.RoomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get members: %w", err)
+	}
+
+	settings, err := s.roomRepo.GetSettings(ctx, params.RoomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get settings: %w", err)
+	}
+
+	var updatedPlayer *Player
+	if settings.PauseOnBuffering {
+		updatedPlayer, err = s.updatePlayerIsPlaying(ctx, params.RoomId, false, true)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return &UpdateIsReadyResponse{
-		Conns:         conns,
-		UpdatedMember: updatedMember,
-		Members:       members,
+	return &StartBufferingResponse{
+		MemberIds:      memberIds,
+		UpdatedMember:  updatedMember,
+		Members:        members,
+		MembersVersion: membersVersion,
+		Player:         updatedPlayer,
 	}, nil
 }
 
 type UpdateIsMutedParams struct {
-	SenderConn *websocket.Conn `json:"sender_conn"`
-	IsMuted    bool            `json:"is_muted"`
-	SenderId   string          `json:"sender_id"`
-	RoomId     string          `json:"room_id"`
+	IsMuted  bool   `json:"is_muted"`
+	SenderId string `json:"sender_id"`
+	RoomId   string `json:"room_id"`
 }
 
 type UpdateIsMutedResponse struct {
-	Conns         []*websocket.Conn
-	UpdatedMember Member
-	Members       []Member
+	MemberIds      []string
+	UpdatedMember  Member
+	Members        []Member
+	MembersVersion int
 }
 
 func (s service) UpdateIsMuted(ctx context.Context, params *UpdateIsMutedParams) (*UpdateIsMutedResponse, error) {
@@ -598,18 +696,25 @@ func (s service) UpdateIsMuted(ctx context.Context, params *UpdateIsMutedParams)
 			return nil, fmt.Errorf("failed to get members: %w", err)
 		}
 
+		membersVersion, err := s.roomRepo.GetMembersVersion(ctx, params.RoomId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get members version: %w", err)
+		}
+
 		return &UpdateIsMutedResponse{
 			UpdatedMember: Member{
-				Id:        params.SenderId,
-				Username:  member.Username,
-				Color:     member.Color,
-				AvatarUrl: member.AvatarUrl,
-				IsMuted:   member.IsMuted,
-				IsAdmin:   member.IsAdmin,
-				IsReady:   member.IsReady,
+				Id:          params.SenderId,
+				Username:    member.Username,
+				Color:       member.Color,
+				AvatarUrl:   member.AvatarUrl,
+				IsMuted:     member.IsMuted,
+				IsAdmin:     member.IsAdmin,
+				IsReady:     member.IsReady,
+				IsBuffering: member.IsBuffering,
 			},
-			Members: members,
-			Conns:   []*websocket.Conn{params.SenderConn},
+			Members:        members,
+			MembersVersion: membersVersion,
+			MemberIds:      []string{params.SenderId},
 		}, nil
 	}
 
@@ -617,9 +722,14 @@ func (s service) UpdateIsMuted(ctx context.Context, params *UpdateIsMutedParams)
 		return nil, fmt.Errorf("failed to update member is muted: %w", err)
 	}
 
-	conns, err := s.getConns(ctx, params.RoomId)
+	membersVersion, err := s.roomRepo.IncrMembersVersion(ctx, params.RoomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to incr members version: %w", err)
+	}
+
+	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get conns: %w", err)
+		return nil, fmt.Errorf("failed to get member ids: %w", err)
 	}
 
 	members, err := s.getMembers(ctx, params.RoomId)
@@ -628,16 +738,18 @@ func (s service) UpdateIsMuted(ctx context.Context, params *UpdateIsMutedParams)
 	}
 
 	return &UpdateIsMutedResponse{
-		Conns: conns,
+		MemberIds: memberIds,
 		UpdatedMember: Member{
-			Id:        params.SenderId,
-			Username:  member.Username,
-			Color:     member.Color,
-			AvatarUrl: member.AvatarUrl,
-			IsMuted:   params.IsMuted,
-			IsAdmin:   member.IsAdmin,
-			IsReady:   member.IsReady,
+			Id:          params.SenderId,
+			Username:    member.Username,
+			Color:       member.Color,
+			AvatarUrl:   member.AvatarUrl,
+			IsMuted:     params.IsMuted,
+			IsAdmin:     member.IsAdmin,
+			IsReady:     member.IsReady,
+			IsBuffering: member.IsBuffering,
 		},
-		Members: members,
+		Members:        members,
+		MembersVersion: membersVersion,
 	}, nil
 }
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/sharetube/server/internal/repository/room"
+	roomredis "github.com/sharetube/server/internal/repository/room/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRoomId = "room0001"
+
+// newTestService returns service with redis repo and empty room testRoomId.
+func newTestService(t *testing.T) *service {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rc.Close() })
+
+	roomRepo := roomredis.NewRepo(rc, time.Hour)
+	require.NoError(t, roomRepo.SetPlayer(context.Background(), &room.SetPlayerParams{
+		IsPlaying:       false,
+		WaitingForReady: false,
+		CurrentTime:     0,
+		PlaybackRate:    1,
+		UpdatedAt:       0,
+		RoomId:          testRoomId,
+	}))
+
+	return New(roomRepo, nil, nil, slog.Default(), &Config{
+		MembersLimit:         9,
+		PlaylistLimit:        25,
+		HistoryLimit:         10,
+		Secret:               "secret",
+		RoomExp:              time.Hour,
+		ReconnectGracePeriod: time.Minute,
+		PersistentRoomExp:    0,
+		DriftTolerance:       time.Second,
+	})
+}
+
+func joinTestRoom(t *testing.T, s *service, jwt string) *JoinRoomResponse {
+	t.Helper()
+
+	resp, err := s.JoinRoom(context.Background(), &JoinRoomParams{
+		JWT:       jwt,
+		Username:  "member",
+		Color:     "#000000",
+		AvatarUrl: nil,
+		RoomId:    testRoomId,
+	})
+	require.NoError(t, err)
+
+	return resp
+}
+
+func assertMemberIds(t *testing.T, s *service, memberIds ...string) {
+	t.Helper()
+
+	members, err := s.GetMembers(context.Background(), testRoomId)
+	require.NoError(t, err)
+
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, member.Id)
+	}
+	assert.Equal(t, memberIds, ids)
+}
+
+func TestReconnectBeforeOldConnIsClosed(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	other := joinTestRoom(t, s, "")
+	first := joinTestRoom(t, s, "")
+	memberId := first.JoinedMember.Id
+
+	// member joins again while old conn is still open, e.g. its read deadline
+	// has not passed yet
+	second := joinTestRoom(t, s, first.JWT)
+	assert.True(t, second.IsRestored)
+	assert.Equal(t, memberId, second.JoinedMember.Id)
+	assert.NotEqual(t, first.ConnId, second.ConnId)
+
+	// cleanup of old conn does not suspend nor remove reconnected member
+	suspendResp, err := s.SuspendMember(ctx, &SuspendMemberParams{
+		MemberId: memberId,
+		RoomId:   testRoomId,
+		ConnId:   first.ConnId,
+	})
+	require.NoError(t, err)
+	assert.True(t, suspendResp.IsMemberReconnected)
+
+	disconnectResp, err := s.DisconnectMember(ctx, &DisconnectMemberParams{
+		MemberId: memberId,
+		RoomId:   testRoomId,
+		ConnId:   first.ConnId,
+		Token:    "",
+	})
+	require.NoError(t, err)
+	assert.True(t, disconnectResp.IsMemberReconnected)
+	assertMemberIds(t, s, other.JoinedMember.Id, memberId)
+
+	// closing the latest conn disconnects member after grace period
+	suspendResp, err = s.SuspendMember(ctx, &SuspendMemberParams{
+		MemberId: memberId,
+		RoomId:   testRoomId,
+		ConnId:   second.ConnId,
+	})
+	require.NoError(t, err)
+	require.False(t, suspendResp.IsMemberReconnected)
+
+	disconnectResp, err = s.DisconnectMember(ctx, &DisconnectMemberParams{
+		MemberId: memberId,
+		RoomId:   testRoomId,
+		ConnId:   second.ConnId,
+		Token:    suspendResp.Token,
+	})
+	require.NoError(t, err)
+	assert.False(t, disconnectResp.IsMemberReconnected)
+	assertMemberIds(t, s, other.JoinedMember.Id)
+}
+
+func TestReconnectWithinGracePeriod(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	joinTestRoom(t, s, "")
+	first := joinTestRoom(t, s, "")
+	memberId := first.JoinedMember.Id
+
+	suspendResp, err := s.SuspendMember(ctx, &SuspendMemberParams{
+		MemberId: memberId,
+		RoomId:   testRoomId,
+		ConnId:   first.ConnId,
+	})
+	require.NoError(t, err)
+	require.False(t, suspendResp.IsMemberReconnected)
+
+	second := joinTestRoom(t, s, first.JWT)
+	assert.True(t, second.IsRestored)
+
+	// grace period timer of old conn does nothing
+	disconnectResp, err := s.DisconnectMember(ctx, &DisconnectMemberParams{
+		MemberId: memberId,
+		RoomId:   testRoomId,
+		ConnId:   first.ConnId,
+		Token:    suspendResp.Token,
+	})
+	require.NoError(t, err)
+	assert.True(t, disconnectResp.IsMemberReconnected)
+
+	members, err := s.GetMembers(ctx, testRoomId)
+	require.NoError(t, err)
+	assert.Len(t, members, 2)
+}
+
+func TestBufferingPausesAndResumesPlayer(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	require.NoError(t, s.roomRepo.SetSettings(ctx, &room.SetSettingsParams{
+		IsPersistent:     false,
+		PauseOnBuffering: true,
+		RoomId:           testRoomId,
+	}))
+	require.NoError(t, s.roomRepo.SetCurrentVideoId(ctx, &room.SetCurrentVideoParams{
+		VideoId: 1,
+		RoomId:  testRoomId,
+	}))
+	require.NoError(t, s.roomRepo.SetVideoEnded(ctx, &room.SetVideoEndedParams{
+		RoomId:     testRoomId,
+		VideoEnded: false,
+	}))
+	require.NoError(t, s.roomRepo.SetPlayer(ctx, &room.SetPlayerParams{
+		IsPlaying:       true,
+		WaitingForReady: false,
+		CurrentTime:     0,
+		PlaybackRate:    1,
+		UpdatedAt:       s.getServerTime(),
+		RoomId:          testRoomId,
+	}))
+
+	memberId := joinTestRoom(t, s, "").JoinedMember.Id
+
+	bufferingResp, err := s.StartBuffering(ctx, &StartBufferingParams{
+		SenderId: memberId,
+		RoomId:   testRoomId,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, bufferingResp.Player)
+	assert.False(t, bufferingResp.Player.State.IsPlaying)
+	assert.Equal(t, 1, bufferingResp.Player.Version)
+
+	readyResp, err := s.UpdateIsReady(ctx, &UpdateIsReadyParams{
+		IsReady:  true,
+		SenderId: memberId,
+		RoomId:   testRoomId,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, readyResp.Player)
+	assert.True(t, readyResp.Player.State.IsPlaying)
+	assert.Equal(t, 2, readyResp.Player.Version)
+
+	// player which was not paused for ready members is not resumed
+	_, err = s.roomRepo.SetPlayerIsPlaying(ctx, &room.SetPlayerIsPlayingParams{
+		RoomId:          testRoomId,
+		PlayerVersion:   2,
+		VideoId:         1,
+		IsPlaying:       false,
+		WaitingForReady: false,
+		CurrentTime:     0,
+		UpdatedAt:       s.getServerTime(),
+	})
+	require.NoError(t, err)
+	player, err := s.resumeIfAllReady(ctx, testRoomId, readyResp.Members)
+	require.NoError(t, err)
+	assert.Nil(t, player)
+}
@@ -2,6 +2,7 @@ package service
 
 type Video struct {
 	Id           int    `json:"id"`
+	Provider     string `json:"provider"`
 	Url          string `json:"url"`
 	Title        string `json:"title"`
 	AuthorName   string `json:"author_name"`
@@ -9,13 +10,14 @@ type Video struct {
 }
 
 type Member struct {
-	Id        string  `json:"id"`
-	Username  string  `json:"username"`
-	Color     string  `json:"color"`
-	AvatarUrl *string `json:"avatar_url"`
-	IsMuted   bool    `json:"is_muted"`
-	IsAdmin   bool    `json:"is_admin"`
-	IsReady   bool    `json:"is_ready"`
+	Id          string  `json:"id"`
+	Username    string  `json:"username"`
+	Color       string  `json:"color"`
+	AvatarUrl   *string `json:"avatar_url"`
+	IsMuted     bool    `json:"is_muted"`
+	IsAdmin     bool    `json:"is_admin"`
+	IsReady     bool    `json:"is_ready"`
+	IsBuffering bool    `json:"is_buffering"`
 }
 
 type Playlist struct {
@@ -25,6 +27,19 @@ type Playlist struct {
 	Version      int     `json:"version"`
 }
 
+// HistoryEntry is video played in room, times are in microseconds.
+type HistoryEntry struct {
+	Id           int    `json:"id"`
+	Provider     string `json:"provider"`
+	Url          string `json:"url"`
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	ThumbnailUrl string `json:"thumbnail_url"`
+	AddedBy      string `json:"added_by"`
+	PlayedAt     int    `json:"played_at"`
+	StartTime    int    `json:"start_time"`
+}
+
 type PlayerState struct {
 	CurrentTime  int     `json:"current_time"`
 	IsPlaying    bool    `json:"is_playing"`
@@ -32,15 +47,34 @@ type PlayerState struct {
 	UpdatedAt    int     `json:"updated_at"`
 }
 
+// PlayerPosition is player state extrapolated to ServerTime, times are in
+// microseconds.
+type PlayerPosition struct {
+	VideoId        int     `json:"video_id"`
+	CurrentTime    int     `json:"current_time"`
+	IsPlaying      bool    `json:"is_playing"`
+	PlaybackRate   float64 `json:"playback_rate"`
+	ServerTime     int     `json:"server_time"`
+	DriftTolerance int     `json:"drift_tolerance"`
+	PlayerVersion  int     `json:"player_version"`
+}
+
 type Player struct {
 	State   PlayerState `json:"state"`
 	IsEnded bool        `json:"is_ended"`
 	Version int         `json:"version"`
 }
 
+type RoomSettings struct {
+	IsPersistent     bool `json:"is_persistent"`
+	PauseOnBuffering bool `json:"pause_on_buffering"`
+}
+
 type Room struct {
-	Id       string   `json:"id"`
-	Player   Player   `json:"player"`
-	Members  []Member `json:"members"`
-	Playlist Playlist `json:"playlist"`
+	Id             string       `json:"id"`
+	Player         Player       `json:"player"`
+	Members        []Member     `json:"members"`
+	MembersVersion int          `json:"members_version"`
+	Playlist       Playlist     `json:"playlist"`
+	Settings       RoomSettings `json:"settings"`
 }
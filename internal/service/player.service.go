@@ -6,20 +6,17 @@ import (
 	"fmt"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
-	"github.com/gorilla/websocket"
 	"github.com/sharetube/server/internal/repository/room"
 )
 
 type UpdatePlayerStateParams struct {
-	VideoId       int             `json:"video_id"`
-	IsPlaying     bool            `json:"is_playing"`
-	CurrentTime   int             `json:"current_time"`
-	PlaybackRate  float64         `json:"playback_rate"`
-	UpdatedAt     int             `json:"updated_at"`
-	PlayerVersion int             `json:"player_version"`
-	SenderId      string          `json:"sender_id"`
-	SenderConn    *websocket.Conn `json:"sender_conn"`
-	RoomId        string          `json:"room_id"`
+	VideoId       int     `json:"video_id"`
+	IsPlaying     bool    `json:"is_playing"`
+	CurrentTime   int     `json:"current_time"`
+	PlaybackRate  float64 `json:"playback_rate"`
+	PlayerVersion int     `json:"player_version"`
+	SenderId      string  `json:"sender_id"`
+	RoomId        string  `json:"room_id"`
 }
 
 type PlayerStateUpdatedResponse struct {
@@ -27,7 +24,7 @@ type PlayerStateUpdatedResponse struct {
 }
 
 type UpdatePlayerStateResponse struct {
-	Conns                         []*websocket.Conn
+	MemberIds                     []string
 	PlayerStateUpdatedResponse    *PlayerStateUpdatedResponse
 	PlayerVersionMismatchResponse *PlayerVersionMismatchResponse
 }
@@ -38,99 +35,55 @@ func (s service) UpdatePlayerState(ctx context.Context, params *UpdatePlayerStat
 	}
 	//? add validation
 
-	playerVersion, err := s.roomRepo.GetPlayerVersion(ctx, params.RoomId)
+	updatePlayerStateRes, err := s.roomRepo.UpdatePlayerState(ctx, &room.UpdatePlayerStateParams{
+		RoomId:        params.RoomId,
+		PlayerVersion: params.PlayerVersion,
+		VideoId:       params.VideoId,
+		IsPlaying:     params.IsPlaying,
+		CurrentTime:   params.CurrentTime,
+		PlaybackRate:  params.PlaybackRate,
+		UpdatedAt:     s.getServerTime(),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get player version: %w", err)
-	}
-
-	if playerVersion != params.PlayerVersion {
-		player, err := s.getPlayer(ctx, params.RoomId)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get player: %w", err)
-		}
-
-		return &UpdatePlayerStateResponse{
-			PlayerVersionMismatchResponse: &PlayerVersionMismatchResponse{
-				Player: *player,
-			},
-			Conns:                      []*websocket.Conn{params.SenderConn},
-			PlayerStateUpdatedResponse: nil,
-		}, nil
-	}
-
-	currentVideoId, err := s.roomRepo.GetCurrentVideoId(ctx, params.RoomId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get current video id: %w", err)
-	}
-
-	if currentVideoId != params.VideoId {
-		return nil, errors.New("video id is not equal")
-	}
-
-	updated := false
-	player, err := s.roomRepo.GetPlayer(ctx, params.RoomId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get player: %w", err)
-	}
-
-	// todo: wrap in transaction
-	if player.CurrentTime != params.CurrentTime {
-		updated = true
-		if err := s.roomRepo.UpdatePlayerCurrentTime(ctx, params.RoomId, params.CurrentTime); err != nil {
-			return nil, fmt.Errorf("failed to update player current time: %w", err)
-		}
-	}
-
-	if player.IsPlaying != params.IsPlaying {
-		updated = true
-		if err := s.roomRepo.UpdatePlayerIsPlaying(ctx, params.RoomId, params.IsPlaying); err != nil {
-			return nil, fmt.Errorf("failed to update player is playing: %w", err)
+		if errors.Is(err, room.ErrPlayerVersionMismatch) {
+			player, err := s.getPlayer(ctx, params.RoomId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get player: %w", err)
+			}
+
+			return &UpdatePlayerStateResponse{
+				PlayerVersionMismatchResponse: &PlayerVersionMismatchResponse{
+					Player: *player,
+				},
+				MemberIds:                  []string{params.SenderId},
+				PlayerStateUpdatedResponse: nil,
+			}, nil
 		}
-	}
 
-	if player.PlaybackRate != params.PlaybackRate {
-		updated = true
-		if err := s.roomRepo.UpdatePlayerPlaybackRate(ctx, params.RoomId, params.PlaybackRate); err != nil {
-			return nil, fmt.Errorf("failed to update player playback rate: %w", err)
-		}
+		return nil, fmt.Errorf("failed to update player state: %w", s.mapRoomError(err))
 	}
 
-	if err := s.roomRepo.SetVideoEnded(ctx, &room.SetVideoEndedParams{
-		RoomId:     params.RoomId,
-		VideoEnded: false,
-	}); err != nil {
-		return nil, fmt.Errorf("failed to update video ended: %w", err)
+	player := Player{
+		State: PlayerState{
+			IsPlaying:    params.IsPlaying,
+			CurrentTime:  params.CurrentTime,
+			PlaybackRate: params.PlaybackRate,
+			UpdatedAt:    updatePlayerStateRes.UpdatedAt,
+		},
+		IsEnded: false,
+		Version: updatePlayerStateRes.PlayerVersion,
 	}
 
-	if !updated {
+	if !updatePlayerStateRes.Updated {
 		return &UpdatePlayerStateResponse{
 			PlayerStateUpdatedResponse: &PlayerStateUpdatedResponse{
-				Player: Player{
-					State: PlayerState{
-						IsPlaying:    player.IsPlaying,
-						CurrentTime:  player.CurrentTime,
-						PlaybackRate: player.PlaybackRate,
-						UpdatedAt:    player.UpdatedAt,
-					},
-					IsEnded: false,
-					Version: playerVersion,
-				},
+				Player: player,
 			},
-			Conns:                         []*websocket.Conn{params.SenderConn},
+			MemberIds:                     []string{params.SenderId},
 			PlayerVersionMismatchResponse: nil,
 		}, nil
 	}
 
-	if err := s.roomRepo.UpdatePlayerUpdatedAt(ctx, params.RoomId, params.UpdatedAt); err != nil {
-		return nil, fmt.Errorf("failed to update player updated at: %w", err)
-	}
-
-	if player.WaitingForReady {
-		if err := s.roomRepo.UpdatePlayerWaitingForReady(ctx, params.RoomId, false); err != nil {
-			return nil, fmt.Errorf("failed to update player waiting for ready: %w", err)
-		}
-	}
-
 	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get member ids: %w", err)
@@ -143,46 +96,25 @@ func (s service) UpdatePlayerState(ctx context.Context, params *UpdatePlayerStat
 		}
 	}
 
-	conns, err := s.getConnsFromMemberIds(ctx, memberIds)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get conns from member ids: %w", err)
-	}
-
-	playerVersion, err = s.roomRepo.IncrPlayerVersion(ctx, params.RoomId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to incr player version: %w", err)
-	}
-
 	return &UpdatePlayerStateResponse{
 		PlayerStateUpdatedResponse: &PlayerStateUpdatedResponse{
-			Player: Player{
-				State: PlayerState{
-					IsPlaying:    params.IsPlaying,
-					CurrentTime:  params.CurrentTime,
-					PlaybackRate: params.PlaybackRate,
-					UpdatedAt:    params.UpdatedAt,
-				},
-				IsEnded: false,
-				Version: playerVersion,
-			},
+			Player: player,
 		},
-		Conns:                         conns,
+		MemberIds:                     memberIds,
 		PlayerVersionMismatchResponse: nil,
 	}, nil
 }
 
 type UpdatePlayerVideoParams struct {
-	VideoId         int             `json:"video_id"`
-	UpdatedAt       int             `json:"updated_at"`
-	SenderId        string          `json:"sender_id"`
-	SenderConn      *websocket.Conn `json:"-"`
-	RoomId          string          `json:"room_id"`
-	PlayerVersion   int             `json:"player_version"`
-	PlaylistVersion int             `json:"playlist_version"`
+	VideoId         int    `json:"video_id"`
+	SenderId        string `json:"sender_id"`
+	RoomId          string `json:"room_id"`
+	PlayerVersion   int    `json:"player_version"`
+	PlaylistVersion int    `json:"playlist_version"`
 }
 
 type UpdatePlayerVideoResponse struct {
-	Conns                         []*websocket.Conn
+	MemberIds                     []string
 	PlayerVideoUpdatedResponse    *PlayerVideoUpdatedResponse
 	PlayerVersionMismatchResponse *PlayerVersionMismatchResponse
 }
@@ -192,44 +124,91 @@ func (s service) UpdatePlayerVideo(ctx context.Context, params *UpdatePlayerVide
 		return nil, err
 	}
 
-	if err := validation.ValidateStructWithContext(ctx, params,
+	if err := validateStruct(ctx, params,
 		validation.Field(&params.VideoId, VideoIdRule...),
 	); err != nil {
 		return nil, err
 	}
 
-	playerVersion, err := s.roomRepo.GetPlayerVersion(ctx, params.RoomId)
+	updatePlayerVideoRes, err := s.updatePlayerVideo(ctx, params.RoomId, params.PlayerVersion, params.VideoId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get player version: %w", err)
-	}
-
-	if playerVersion != params.PlayerVersion {
-		player, err := s.getPlayer(ctx, params.RoomId)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get player: %w", err)
+		if errors.Is(err, room.ErrPlayerVersionMismatch) {
+			player, err := s.getPlayer(ctx, params.RoomId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get player: %w", err)
+			}
+
+			return &UpdatePlayerVideoResponse{
+				MemberIds: []string{params.SenderId},
+				PlayerVersionMismatchResponse: &PlayerVersionMismatchResponse{
+					Player: *player,
+				},
+				PlayerVideoUpdatedResponse: nil,
+			}, nil
 		}
 
-		return &UpdatePlayerVideoResponse{
-			Conns: []*websocket.Conn{params.SenderConn},
-			PlayerVersionMismatchResponse: &PlayerVersionMismatchResponse{
-				Player: *player,
-			},
-			PlayerVideoUpdatedResponse: nil,
-		}, nil
-	}
-
-	updatePlayerVideoRes, err := s.updatePlayerVideo(ctx, params.RoomId, params.VideoId, params.UpdatedAt)
-	if err != nil {
 		return nil, err
 	}
 
 	return &UpdatePlayerVideoResponse{
-		Conns: updatePlayerVideoRes.Conns,
+		MemberIds: updatePlayerVideoRes.MemberIds,
 		PlayerVideoUpdatedResponse: &PlayerVideoUpdatedResponse{
-			Playlist: updatePlayerVideoRes.Playlist,
-			Player:   updatePlayerVideoRes.Player,
-			Members:  updatePlayerVideoRes.Members,
+			Playlist:       updatePlayerVideoRes.Playlist,
+			Player:         updatePlayerVideoRes.Player,
+			Members:        updatePlayerVideoRes.Members,
+			MembersVersion: updatePlayerVideoRes.MembersVersion,
 		},
 		PlayerVersionMismatchResponse: nil,
 	}, nil
 }
+
+// getExpectedTime returns position of player at server time now. Playing
+// player advances by elapsed time scaled with playback rate.
+func (s service) getExpectedTime(player *room.Player, isEnded bool, now int) int {
+	if !player.IsPlaying || isEnded || now <= player.UpdatedAt {
+		return player.CurrentTime
+	}
+
+	return player.CurrentTime + int(float64(now-player.UpdatedAt)*player.PlaybackRate)
+}
+
+func (s service) GetPlayerPosition(ctx context.Context, roomId string) (*PlayerPosition, error) {
+	if err := s.checkIfRoomExists(ctx, roomId); err != nil {
+		return nil, err
+	}
+
+	return s.getPlayerPosition(ctx, roomId, s.getServerTime())
+}
+
+// getPlayerPosition returns player position expected at server time now.
+func (s service) getPlayerPosition(ctx context.Context, roomId string, now int) (*PlayerPosition, error) {
+	player, err := s.roomRepo.GetPlayer(ctx, roomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get player: %w", err)
+	}
+
+	isEnded, err := s.roomRepo.GetVideoEnded(ctx, roomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get video ended: %w", err)
+	}
+
+	playerVersion, err := s.roomRepo.GetPlayerVersion(ctx, roomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get player version: %w", err)
+	}
+
+	currentVideoId, err := s.roomRepo.GetCurrentVideoId(ctx, roomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current video id: %w", err)
+	}
+
+	return &PlayerPosition{
+		VideoId:        currentVideoId,
+		CurrentTime:    s.getExpectedTime(&player, isEnded, now),
+		IsPlaying:      player.IsPlaying && !isEnded,
+		PlaybackRate:   player.PlaybackRate,
+		ServerTime:     now,
+		DriftTolerance: int(s.driftTolerance.Microseconds()),
+		PlayerVersion:  playerVersion,
+	}, nil
+}
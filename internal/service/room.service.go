@@ -4,37 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"time"
+	"slices"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 	"github.com/sharetube/server/internal/repository/room"
-	"github.com/sharetube/server/pkg/ytvideodata"
 )
 
-func (s service) getConnsFromMemberIds(_ context.Context, memberIds []string) ([]*websocket.Conn, error) {
-	conns := make([]*websocket.Conn, 0, len(memberIds))
-	for _, memberId := range memberIds {
-		conn, err := s.connRepo.GetConn(memberId)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get conn: %w", err)
-		}
-		conns = append(conns, conn)
-	}
-
-	return conns, nil
-}
-
-func (s service) getConns(ctx context.Context, roomId string) ([]*websocket.Conn, error) {
-	memberIds, err := s.roomRepo.GetMemberIds(ctx, roomId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get member ids: %w", err)
-	}
-
-	return s.getConnsFromMemberIds(ctx, memberIds)
-}
-
 type CreateRoomParams struct {
 	Username        string  `json:"username"`
 	Color           string  `json:"color"`
@@ -46,10 +22,12 @@ type CreateRoomResponse struct {
 	RoomId       string
 	JoinedMember Member
 	JWT          string
+	// passed to SuspendMember and DisconnectMember once conn is closed
+	ConnId string
 }
 
 func (s service) CreateRoom(ctx context.Context, params *CreateRoomParams) (*CreateRoomResponse, error) {
-	if err := validation.ValidateStructWithContext(ctx, params,
+	if err := validateStruct(ctx, params,
 		validation.Field(&params.Username, UsernameRule...),
 		validation.Field(&params.Color, ColorRule...),
 		validation.Field(&params.AvatarUrl, AvatarUrlRule...),
@@ -58,23 +36,24 @@ func (s service) CreateRoom(ctx context.Context, params *CreateRoomParams) (*Cre
 		return nil, err
 	}
 
-	videoData, err := ytvideodata.Get(params.InitialVideoUrl)
+	video, err := s.resolveVideo(ctx, "initial_video_url", params.InitialVideoUrl)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get video data: %w", err)
+		return nil, err
 	}
 
 	roomId := s.generator.GenerateRandomString(8)
 
 	memberId := uuid.NewString()
 	setMemberParams := room.SetMemberParams{
-		MemberId:  memberId,
-		Username:  params.Username,
-		Color:     params.Color,
-		AvatarUrl: params.AvatarUrl,
-		IsMuted:   s.getDefaultMemberIsMuted(),
-		IsAdmin:   true,
-		IsReady:   s.getDefaultMemberIsReady(),
-		RoomId:    roomId,
+		MemberId:    memberId,
+		Username:    params.Username,
+		Color:       params.Color,
+		AvatarUrl:   params.AvatarUrl,
+		IsMuted:     s.getDefaultMemberIsMuted(),
+		IsAdmin:     true,
+		IsReady:     s.getDefaultMemberIsReady(),
+		IsBuffering: s.getDefaultMemberIsBuffering(),
+		RoomId:      roomId,
 	}
 	if err := s.roomRepo.SetMember(ctx, &setMemberParams); err != nil {
 		return nil, fmt.Errorf("failed to set member: %w", err)
@@ -87,6 +66,11 @@ func (s service) CreateRoom(ctx context.Context, params *CreateRoomParams) (*Cre
 		return nil, fmt.Errorf("failed to add member to list: %w", err)
 	}
 
+	connId, err := s.setMemberConnId(ctx, roomId, memberId)
+	if err != nil {
+		return nil, err
+	}
+
 	jwt, err := s.generateJWT(memberId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate jwt: %w", err)
@@ -94,10 +78,13 @@ func (s service) CreateRoom(ctx context.Context, params *CreateRoomParams) (*Cre
 
 	videoId, err := s.roomRepo.SetVideo(ctx, &room.SetVideoParams{
 		RoomId:       roomId,
-		Url:          params.InitialVideoUrl,
-		Title:        videoData.Title,
-		ThumbnailUrl: videoData.ThumbnailUrl,
-		AuthorName:   videoData.AuthorName,
+		Provider:     video.Provider,
+		Url:          video.Id,
+		Title:        video.Title,
+		ThumbnailUrl: video.ThumbnailUrl,
+		AuthorName:   video.AuthorName,
+		AddedBy:      memberId,
+		StartTime:    s.getVideoStartTime(video),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to set video: %w", err)
@@ -110,12 +97,27 @@ func (s service) CreateRoom(ctx context.Context, params *CreateRoomParams) (*Cre
 		return nil, fmt.Errorf("failed to set current video id: %w", err)
 	}
 
+	if _, err := s.roomRepo.AddHistoryEntry(ctx, &room.AddHistoryEntryParams{
+		RoomId:       roomId,
+		Provider:     video.Provider,
+		Url:          video.Id,
+		Title:        video.Title,
+		AuthorName:   video.AuthorName,
+		ThumbnailUrl: video.ThumbnailUrl,
+		AddedBy:      memberId,
+		PlayedAt:     s.getServerTime(),
+		StartTime:    s.getVideoStartTime(video),
+		HistoryLimit: s.historyLimit,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to add history entry: %w", err)
+	}
+
 	if err := s.roomRepo.SetPlayer(ctx, &room.SetPlayerParams{
 		IsPlaying:       s.getDefaultPlayerIsPlaying(),
 		WaitingForReady: s.getDefaultPlayerWaitingForReady(),
-		CurrentTime:     s.getDefaultPlayerCurrentTime(),
+		CurrentTime:     s.getVideoStartTime(video),
 		PlaybackRate:    s.getDefaultPlayerPlaybackRate(),
-		UpdatedAt:       int(time.Now().UnixMicro()),
+		UpdatedAt:       s.getServerTime(),
 		RoomId:          roomId,
 	}); err != nil {
 		return nil, fmt.Errorf("failed to set player: %w", err)
@@ -128,17 +130,27 @@ func (s service) CreateRoom(ctx context.Context, params *CreateRoomParams) (*Cre
 		return nil, fmt.Errorf("failed to set video ended: %w", err)
 	}
 
+	if err := s.roomRepo.SetSettings(ctx, &room.SetSettingsParams{
+		RoomId:           roomId,
+		IsPersistent:     s.getDefaultRoomIsPersistent(),
+		PauseOnBuffering: s.getDefaultRoomPauseOnBuffering(),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to set settings: %w", err)
+	}
+
 	return &CreateRoomResponse{
 		JWT:    jwt,
 		RoomId: roomId,
+		ConnId: connId,
 		JoinedMember: Member{
-			Id:        memberId,
-			Username:  setMemberParams.Username,
-			Color:     setMemberParams.Color,
-			AvatarUrl: setMemberParams.AvatarUrl,
-			IsMuted:   setMemberParams.IsMuted,
-			IsAdmin:   setMemberParams.IsAdmin,
-			IsReady:   setMemberParams.IsReady,
+			Id:          memberId,
+			Username:    setMemberParams.Username,
+			Color:       setMemberParams.Color,
+			AvatarUrl:   setMemberParams.AvatarUrl,
+			IsMuted:     setMemberParams.IsMuted,
+			IsAdmin:     setMemberParams.IsAdmin,
+			IsReady:     setMemberParams.IsReady,
+			IsBuffering: setMemberParams.IsBuffering,
 		},
 	}, nil
 }
@@ -168,13 +180,14 @@ func (s service) getMemberByJWT(ctx context.Context, roomId, jwt string) (*Membe
 	}
 
 	return &Member{
-		Id:        claims.MemberId,
-		Username:  member.Username,
-		Color:     member.Color,
-		AvatarUrl: member.AvatarUrl,
-		IsMuted:   member.IsMuted,
-		IsAdmin:   member.IsAdmin,
-		IsReady:   member.IsReady,
+		Id:          claims.MemberId,
+		Username:    member.Username,
+		Color:       member.Color,
+		AvatarUrl:   member.AvatarUrl,
+		IsMuted:     member.IsMuted,
+		IsAdmin:     member.IsAdmin,
+		IsReady:     member.IsReady,
+		IsBuffering: member.IsBuffering,
 	}, nil
 }
 
@@ -187,14 +200,35 @@ type JoinRoomParams struct {
 }
 
 type JoinRoomResponse struct {
-	JWT          string
-	JoinedMember Member
-	Members      []Member
-	Conns        []*websocket.Conn
+	JWT            string
+	JoinedMember   Member
+	Members        []Member
+	MembersVersion int
+	MemberIds      []string
+	// member reconnected within grace period or while its previous conn was
+	// still open, other members were not notified about disconnect
+	IsRestored bool
+	// passed to SuspendMember and DisconnectMember once conn is closed
+	ConnId string
+}
+
+// setMemberConnId makes new conn of member the latest one, so cleanup of its
+// previous conn does nothing.
+func (s service) setMemberConnId(ctx context.Context, roomId, memberId string) (string, error) {
+	connId := uuid.NewString()
+	if err := s.roomRepo.SetMemberConnId(ctx, &room.SetMemberConnIdParams{
+		MemberId: memberId,
+		RoomId:   roomId,
+		ConnId:   connId,
+	}); err != nil {
+		return "", fmt.Errorf("failed to set member conn id: %w", err)
+	}
+
+	return connId, nil
 }
 
 func (s service) JoinRoom(ctx context.Context, params *JoinRoomParams) (*JoinRoomResponse, error) {
-	if err := validation.ValidateStructWithContext(ctx, params,
+	if err := validateStruct(ctx, params,
 		validation.Field(&params.Username, UsernameRule...),
 		validation.Field(&params.Color, ColorRule...),
 		validation.Field(&params.AvatarUrl, AvatarUrlRule...),
@@ -203,13 +237,8 @@ func (s service) JoinRoom(ctx context.Context, params *JoinRoomParams) (*JoinRoo
 		return nil, err
 	}
 
-	conns, err := s.getConns(ctx, params.RoomId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get conns: %w", err)
-	}
-
-	if len(conns) >= s.membersLimit {
-		return nil, errors.New("room is full")
+	if err := s.checkIfRoomExists(ctx, params.RoomId); err != nil {
+		return nil, err
 	}
 
 	jwt := params.JWT
@@ -218,24 +247,62 @@ func (s service) JoinRoom(ctx context.Context, params *JoinRoomParams) (*JoinRoo
 		return nil, fmt.Errorf("failed to get member by jwt: %w", err)
 	}
 
-	if member == nil {
-		//? check if room exists
-		_, err := s.roomRepo.GetCurrentVideoId(ctx, params.RoomId)
+	// member reconnected during grace period still has its slot. Conn id is set
+	// first, so previous conn closed meanwhile is not suspended afterwards.
+	isRestored := false
+	connId := ""
+	if member != nil {
+		connId, err = s.setMemberConnId(ctx, params.RoomId, member.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		isRestored, err = s.roomRepo.RemoveMemberDisconnected(ctx, &room.RemoveMemberDisconnectedParams{
+			MemberId: member.Id,
+			RoomId:   params.RoomId,
+			Token:    "",
+		})
 		if err != nil {
-			return nil, errors.New("room not found")
+			return nil, fmt.Errorf("failed to remove member disconnected: %w", err)
+		}
+	}
+
+	// read after conn id is set, so member is not removed by previous conn since
+	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get member ids: %w", err)
+	}
+
+	// previous conn is not closed yet, e.g. its read deadline has not passed
+	if member != nil && slices.Contains(memberIds, member.Id) {
+		isRestored = true
+	}
+
+	if !isRestored && len(memberIds) >= s.membersLimit {
+		return nil, ErrRoomIsFull
+	}
+
+	// reopening empty room, which is kept until it expires or forever if persistent
+	isReopened := len(memberIds) == 0
+	if isReopened {
+		if err := s.roomRepo.PersistRoom(ctx, params.RoomId); err != nil {
+			return nil, fmt.Errorf("failed to persist room: %w", err)
 		}
+	}
 
+	if member == nil {
 		// member not found, creating new one
 		memberId := uuid.NewString()
 		setMemberParams := room.SetMemberParams{
-			MemberId:  memberId,
-			Username:  params.Username,
-			Color:     params.Color,
-			AvatarUrl: params.AvatarUrl,
-			IsMuted:   false,
-			IsAdmin:   false,
-			IsReady:   false,
-			RoomId:    params.RoomId,
+			MemberId:    memberId,
+			Username:    params.Username,
+			Color:       params.Color,
+			AvatarUrl:   params.AvatarUrl,
+			IsMuted:     false,
+			IsAdmin:     false,
+			IsReady:     false,
+			IsBuffering: false,
+			RoomId:      params.RoomId,
 		}
 		if err := s.roomRepo.SetMember(ctx, &setMemberParams); err != nil {
 			return nil, fmt.Errorf("failed to set member: %w", err)
@@ -248,14 +315,20 @@ func (s service) JoinRoom(ctx context.Context, params *JoinRoomParams) (*JoinRoo
 			return nil, fmt.Errorf("failed to add member to list: %w", err)
 		}
 
+		connId, err = s.setMemberConnId(ctx, params.RoomId, memberId)
+		if err != nil {
+			return nil, err
+		}
+
 		member = &Member{
-			Id:        memberId,
-			Username:  params.Username,
-			Color:     params.Color,
-			AvatarUrl: params.AvatarUrl,
-			IsMuted:   false,
-			IsAdmin:   false,
-			IsReady:   false,
+			Id:          memberId,
+			Username:    params.Username,
+			Color:       params.Color,
+			AvatarUrl:   params.AvatarUrl,
+			IsMuted:     false,
+			IsAdmin:     false,
+			IsReady:     false,
+			IsBuffering: false,
 		}
 
 		jwt, err = s.generateJWT(member.Id)
@@ -264,11 +337,13 @@ func (s service) JoinRoom(ctx context.Context, params *JoinRoomParams) (*JoinRoo
 		}
 	} else {
 		// member found, updating
-		if err := s.roomRepo.AddMemberToList(ctx, &room.AddMemberToListParams{
-			RoomId:   params.RoomId,
-			MemberId: member.Id,
-		}); err != nil {
-			return nil, fmt.Errorf("failed to add member to list: %w", err)
+		if !isRestored {
+			if err := s.roomRepo.AddMemberToList(ctx, &room.AddMemberToListParams{
+				RoomId:   params.RoomId,
+				MemberId: member.Id,
+			}); err != nil {
+				return nil, fmt.Errorf("failed to add member to list: %w", err)
+			}
 		}
 
 		if member.Username != params.Username {
@@ -293,24 +368,108 @@ func (s service) JoinRoom(ctx context.Context, params *JoinRoomParams) (*JoinRoo
 		}
 	}
 
+	// room must have an admin, previous one may never come back. It is not
+	// recognized anyway, member keys are removed once member leaves. Room id is
+	// the only credential of the room, so whoever knows it may reopen it as admin.
+	if isReopened && !member.IsAdmin {
+		if err := s.roomRepo.UpdateMemberIsAdmin(ctx, params.RoomId, member.Id, true); err != nil {
+			return nil, fmt.Errorf("failed to update member is admin: %w", err)
+		}
+		member.IsAdmin = true
+	}
+
+	// restored member is not announced to others, so version is kept
+	var membersVersion int
+	if isRestored {
+		membersVersion, err = s.roomRepo.GetMembersVersion(ctx, params.RoomId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get members version: %w", err)
+		}
+	} else {
+		membersVersion, err = s.roomRepo.IncrMembersVersion(ctx, params.RoomId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to incr members version: %w", err)
+		}
+	}
+
 	members, err := s.getMembers(ctx, params.RoomId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get members: %w", err)
 	}
+
 	return &JoinRoomResponse{
-		JWT:          jwt,
-		Conns:        conns,
-		Members:      members,
-		JoinedMember: *member,
+		JWT:            jwt,
+		MemberIds:      memberIds,
+		Members:        members,
+		MembersVersion: membersVersion,
+		JoinedMember:   *member,
+		IsRestored:     isRestored,
+		ConnId:         connId,
 	}, nil
 }
 
+// checkIfRoomExists returns ErrRoomNotFound if room id is invalid or room is
+// expired. Player is set on room creation and lives as long as the room.
+func (s service) checkIfRoomExists(ctx context.Context, roomId string) error {
+	if err := validation.Validate(roomId, RoomIdRule...); err != nil {
+		return ErrRoomNotFound
+	}
+
+	exists, err := s.roomRepo.IsPlayerExists(ctx, roomId)
+	if err != nil {
+		return fmt.Errorf("failed to check if player exists: %w", err)
+	}
+
+	if !exists {
+		return ErrRoomNotFound
+	}
+
+	return nil
+}
+
+func (s service) IsRoomExists(ctx context.Context, roomId string) (bool, error) {
+	if err := s.checkIfRoomExists(ctx, roomId); err != nil {
+		if errors.Is(err, ErrRoomNotFound) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s service) GetMembers(ctx context.Context, roomId string) ([]Member, error) {
+	if err := s.checkIfRoomExists(ctx, roomId); err != nil {
+		return nil, err
+	}
+
+	return s.getMembers(ctx, roomId)
+}
+
+func (s service) GetPlaylist(ctx context.Context, roomId string) (*Playlist, error) {
+	if err := s.checkIfRoomExists(ctx, roomId); err != nil {
+		return nil, err
+	}
+
+	return s.getPlaylist(ctx, roomId)
+}
+
 func (s service) GetRoom(ctx context.Context, roomId string) (*Room, error) {
+	if err := s.checkIfRoomExists(ctx, roomId); err != nil {
+		return nil, err
+	}
+
 	members, err := s.getMembers(ctx, roomId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get members: %w", err)
 	}
 
+	membersVersion, err := s.roomRepo.GetMembersVersion(ctx, roomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get members version: %w", err)
+	}
+
 	playlist, err := s.getPlaylist(ctx, roomId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get playlist: %w", err)
@@ -321,10 +480,72 @@ func (s service) GetRoom(ctx context.Context, roomId string) (*Room, error) {
 		return nil, fmt.Errorf("failed to get player: %w", err)
 	}
 
+	settings, err := s.roomRepo.GetSettings(ctx, roomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get settings: %w", err)
+	}
+
 	return &Room{
-		Id:       roomId,
-		Player:   *player,
-		Members:  members,
-		Playlist: *playlist,
+		Id:             roomId,
+		Player:         *player,
+		Members:        members,
+		MembersVersion: membersVersion,
+		Playlist:       *playlist,
+		Settings: RoomSettings{
+			IsPersistent:     settings.IsPersistent,
+			PauseOnBuffering: settings.PauseOnBuffering,
+		},
+	}, nil
+}
+
+type UpdateRoomSettingsParams struct {
+	IsPersistent     *bool  `json:"is_persistent"`
+	PauseOnBuffering *bool  `json:"pause_on_buffering"`
+	SenderId         string `json:"sender_id"`
+	RoomId           string `json:"room_id"`
+}
+
+type UpdateRoomSettingsResponse struct {
+	MemberIds []string
+	Settings  RoomSettings
+}
+
+func (s service) UpdateRoomSettings(ctx context.Context, params *UpdateRoomSettingsParams) (*UpdateRoomSettingsResponse, error) {
+	if err := s.checkIfMemberAdmin(ctx, params.RoomId, params.SenderId); err != nil {
+		return nil, err
+	}
+
+	settings, err := s.roomRepo.GetSettings(ctx, params.RoomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get settings: %w", err)
+	}
+
+	if params.IsPersistent != nil {
+		settings.IsPersistent = *params.IsPersistent
+	}
+
+	if params.PauseOnBuffering != nil {
+		settings.PauseOnBuffering = *params.PauseOnBuffering
+	}
+
+	if err := s.roomRepo.SetSettings(ctx, &room.SetSettingsParams{
+		RoomId:           params.RoomId,
+		IsPersistent:     settings.IsPersistent,
+		PauseOnBuffering: settings.PauseOnBuffering,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to set settings: %w", err)
+	}
+
+	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get member ids: %w", err)
+	}
+
+	return &UpdateRoomSettingsResponse{
+		MemberIds: memberIds,
+		Settings: RoomSettings{
+			IsPersistent:     settings.IsPersistent,
+			PauseOnBuffering: settings.PauseOnBuffering,
+		},
 	}, nil
 }
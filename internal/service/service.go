@@ -3,10 +3,12 @@ package service
 import (
 	"context"
 	"errors"
+	"log/slog"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/sharetube/server/internal/repository/room"
+	"github.com/sharetube/server/pkg/videoprovider"
+	"github.com/sharetube/server/pkg/ytvideodata"
 	"github.com/skewb1k/goutils/randstr"
 )
 
@@ -15,6 +17,9 @@ var (
 	ErrMemberNotFound       = errors.New("member not found")
 	ErrPlaylistLimitReached = errors.New("playlist limit reached")
 	ErrRoomNotFound         = errors.New("room not found")
+	ErrRoomIsFull           = errors.New("room is full")
+	ErrVersionMismatch      = errors.New("version mismatch")
+	ErrVideoNotEmbeddable   = errors.New("video is not embeddable")
 )
 
 type iRoomRepo interface {
@@ -22,7 +27,6 @@ type iRoomRepo interface {
 	SetMember(context.Context, *room.SetMemberParams) error
 	AddMemberToList(context.Context, *room.AddMemberToListParams) error
 	RemoveMember(context.Context, *room.RemoveMemberParams) error
-	ExpireMembers(context.Context, *room.ExpireMembersParams) error
 	RemoveMemberFromList(context.Context, *room.RemoveMemberFromListParams) error
 	GetMember(context.Context, *room.GetMemberParams) (room.Member, error)
 	GetMemberIds(context.Context, string) ([]string, error)
@@ -31,52 +35,64 @@ type iRoomRepo interface {
 	UpdateMemberIsAdmin(ctx context.Context, roomId string, memberId string, isAdmin bool) error
 	UpdateMemberIsMuted(ctx context.Context, roomId string, memberId string, isMuted bool) error
 	UpdateMemberIsReady(ctx context.Context, roomId string, memberId string, isReady bool) error
+	UpdateMemberIsBuffering(ctx context.Context, roomId string, memberId string, isBuffering bool) error
 	UpdateMemberUsername(ctx context.Context, roomId string, memberId string, username string) error
 	UpdateMemberColor(ctx context.Context, roomId string, memberId string, color string) error
 	UpdateMemberAvatarUrl(ctx context.Context, roomId string, memberId string, avatarUrl *string) error
+	SetMemberConnId(context.Context, *room.SetMemberConnIdParams) error
+	SetMemberDisconnected(context.Context, *room.SetMemberDisconnectedParams) (bool, error)
+	RemoveMemberDisconnected(context.Context, *room.RemoveMemberDisconnectedParams) (bool, error)
+	DisconnectMember(context.Context, *room.DisconnectMemberParams) (bool, error)
+	IncrMembersVersion(context.Context, string) (int, error)
+	GetMembersVersion(context.Context, string) (int, error)
 	// video
 	SetVideo(context.Context, *room.SetVideoParams) (int, error)
-	RemoveVideo(context.Context, *room.RemoveVideoParams) error
-	ExpireVideo(context.Context, *room.ExpireVideoParams) error
-	RemoveVideoFromList(context.Context, *room.RemoveVideoFromListParams) error
-	SetLastVideo(context.Context, *room.SetLastVideoParams) error
-	ExpireLastVideo(context.Context, *room.ExpireLastVideoParams) error
 	GetPlaylistVersion(context.Context, string) (int, error)
-	IncrPlaylistVersion(context.Context, string) (int, error)
-	ExpirePlaylist(context.Context, *room.ExpirePlaylistParams) error
 	GetVideoIds(context.Context, string) ([]int, error)
 	GetVideo(context.Context, *room.GetVideoParams) (room.Video, error)
-	GetVideosLength(context.Context, string) (int, error)
 	GetLastVideoId(context.Context, string) (*int, error)
-	ReorderList(context.Context, *room.ReorderListParams) error
-	AddVideoToList(context.Context, *room.AddVideoToListParams) error
 	SetCurrentVideoId(context.Context, *room.SetCurrentVideoParams) error
 	GetCurrentVideoId(context.Context, string) (int, error)
+	AddVideo(context.Context, *room.AddVideoParams) (*room.AddVideoResponse, error)
+	AddVideos(context.Context, *room.AddVideosParams) (*room.AddVideosResponse, error)
+	RemovePlaylistVideo(context.Context, *room.RemovePlaylistVideoParams) (int, error)
+	ReorderPlaylist(context.Context, *room.ReorderPlaylistParams) (int, error)
+	PlayVideo(context.Context, *room.PlayVideoParams) (*room.PlayVideoResponse, error)
+	// history
+	AddHistoryEntry(context.Context, *room.AddHistoryEntryParams) (int, error)
+	GetHistory(context.Context, string) ([]room.HistoryEntry, error)
+	GetHistoryEntry(context.Context, *room.GetHistoryEntryParams) (room.HistoryEntry, error)
+	// room
+	SetSettings(context.Context, *room.SetSettingsParams) error
+	GetSettings(context.Context, string) (room.Settings, error)
+	ExpireRoom(context.Context, *room.ExpireRoomParams) error
+	PersistRoom(context.Context, string) error
 	// player
 	SetPlayer(context.Context, *room.SetPlayerParams) error
 	GetPlayer(context.Context, string) (room.Player, error)
 	GetPlayerVersion(context.Context, string) (int, error)
 	IncrPlayerVersion(context.Context, string) (int, error)
 	IsPlayerExists(context.Context, string) (bool, error)
-	ExpirePlayerVersion(context.Context, *room.ExpirePlayerVersionParams) error
 	RemovePlayer(context.Context, string) error
-	ExpirePlayer(context.Context, *room.ExpirePlayerParams) error
 	SetVideoEnded(context.Context, *room.SetVideoEndedParams) error
 	GetVideoEnded(context.Context, string) (bool, error)
-	ExpireVideoEnded(context.Context, *room.ExpireVideoEndedParams) error
 	UpdatePlayerIsPlaying(ctx context.Context, roomId string, isPlaying bool) error
+	SetPlayerIsPlaying(context.Context, *room.SetPlayerIsPlayingParams) (int, error)
 	UpdatePlayerWaitingForReady(ctx context.Context, roomId string, waitingForReady bool) error
-	UpdatePlayerCurrentTime(ctx context.Context, roomId string, currentTime int) error
-	UpdatePlayerPlaybackRate(ctx context.Context, roomId string, playbackRate float64) error
-	UpdatePlayerUpdatedAt(ctx context.Context, roomId string, updatedAt int) error
+	UpdatePlayerState(context.Context, *room.UpdatePlayerStateParams) (*room.UpdatePlayerStateResponse, error)
+	SwitchCurrentVideo(context.Context, *room.SwitchCurrentVideoParams) (*room.SwitchCurrentVideoResponse, error)
+	EndVideo(context.Context, *room.EndVideoParams) (*room.EndVideoResponse, error)
 }
 
-type iConnRepo interface {
-	Add(*websocket.Conn, string) error
-	RemoveByMemberId(string) (*websocket.Conn, error)
-	RemoveByConn(*websocket.Conn) (string, error)
-	GetConn(string) (*websocket.Conn, error)
-	GetMemberId(*websocket.Conn) (string, error)
+// VideoProvider resolves url of video added to room, see videoprovider.Provider.
+type VideoProvider interface {
+	Resolve(ctx context.Context, videoUrl string) (*videoprovider.Video, error)
+}
+
+// PlaylistFetcher resolves videos of YouTube playlist, see
+// ytvideodata.PlaylistFetcher.
+type PlaylistFetcher interface {
+	GetPlaylist(ctx context.Context, playlistId string, limit int) (*ytvideodata.Playlist, error)
 }
 
 type iGenerator interface {
@@ -84,32 +100,51 @@ type iGenerator interface {
 }
 
 type service struct {
-	roomRepo      iRoomRepo
-	connRepo      iConnRepo
-	generator     iGenerator
-	membersLimit  int
-	playlistLimit int
-	secret        []byte
-	roomExp       time.Duration
+	roomRepo        iRoomRepo
+	generator       iGenerator
+	videoProvider   VideoProvider
+	playlistFetcher PlaylistFetcher
+	logger          *slog.Logger
+	membersLimit    int
+	playlistLimit   int
+	historyLimit    int
+	secret          []byte
+	roomExp         time.Duration
+	// how long disconnected member keeps its slot, zero disables grace period
+	reconnectGracePeriod time.Duration
+	// expiration of persistent room after last member left, zero means never
+	persistentRoomExp time.Duration
+	// allowed difference between member and expected player position
+	driftTolerance time.Duration
 }
 
 type Config struct {
-	MembersLimit  int
-	PlaylistLimit int
-	Secret        string
-	RoomExp       time.Duration
+	MembersLimit         int
+	PlaylistLimit        int
+	HistoryLimit         int
+	Secret               string
+	RoomExp              time.Duration
+	ReconnectGracePeriod time.Duration
+	PersistentRoomExp    time.Duration
+	DriftTolerance       time.Duration
 }
 
-func New(redisRepo iRoomRepo, connRepo iConnRepo, cfg *Config) *service {
+func New(redisRepo iRoomRepo, videoProvider VideoProvider, playlistFetcher PlaylistFetcher, logger *slog.Logger, cfg *Config) *service {
 	letterBytes := []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 
 	return &service{
-		roomRepo:      redisRepo,
-		connRepo:      connRepo,
-		membersLimit:  cfg.MembersLimit,
-		playlistLimit: cfg.PlaylistLimit,
-		secret:        []byte(cfg.Secret),
-		generator:     randstr.New(letterBytes),
-		roomExp:       cfg.RoomExp,
+		roomRepo:             redisRepo,
+		membersLimit:         cfg.MembersLimit,
+		playlistLimit:        cfg.PlaylistLimit,
+		historyLimit:         cfg.HistoryLimit,
+		secret:               []byte(cfg.Secret),
+		generator:            randstr.New(letterBytes),
+		videoProvider:        videoProvider,
+		playlistFetcher:      playlistFetcher,
+		logger:               logger,
+		roomExp:              cfg.RoomExp,
+		reconnectGracePeriod: cfg.ReconnectGracePeriod,
+		persistentRoomExp:    cfg.PersistentRoomExp,
+		driftTolerance:       cfg.DriftTolerance,
 	}
 }
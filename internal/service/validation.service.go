@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"regexp"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
@@ -23,13 +25,22 @@ var AvatarUrlRule = []validation.Rule{
 
 var VideoUrlRule = []validation.Rule{
 	validation.Required,
-	validation.Match(regexp.MustCompile("^[a-zA-Z0-9_-]{11}$")),
+	validation.Length(0, 2048),
+}
+
+var PlaylistIdRule = []validation.Rule{
+	validation.Required,
+	validation.Match(regexp.MustCompile("^[a-zA-Z0-9_-]{2,64}$")),
 }
 
 var VideoIdRule = []validation.Rule{
 	validation.Required,
 }
 
+var CurrentTimeRule = []validation.Rule{
+	validation.Min(0),
+}
+
 var RoomIdRule = []validation.Rule{
 	validation.Required,
 	validation.Match(regexp.MustCompile("^[a-zA-Z0-9.-]{8}$")),
@@ -39,3 +50,50 @@ var MemberIdRule = []validation.Rule{
 	validation.Required,
 	is.UUIDv4,
 }
+
+// ValidationError is returned when params are invalid. Fields maps json field
+// names to error messages.
+type ValidationError struct {
+	Fields map[string]string
+	err    error
+}
+
+func (e *ValidationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.err
+}
+
+func newFieldValidationError(field string, err error) *ValidationError {
+	return &ValidationError{
+		Fields: map[string]string{
+			field: err.Error(),
+		},
+		err: validation.Errors{
+			field: err,
+		},
+	}
+}
+
+// validateStruct is validation.ValidateStructWithContext returning
+// *ValidationError for invalid params.
+func validateStruct(ctx context.Context, structPtr any, fields ...*validation.FieldRules) error {
+	err := validation.ValidateStructWithContext(ctx, structPtr, fields...)
+
+	var errs validation.Errors
+	if errors.As(err, &errs) {
+		fieldErrors := make(map[string]string, len(errs))
+		for field, fieldErr := range errs {
+			fieldErrors[field] = fieldErr.Error()
+		}
+
+		return &ValidationError{
+			Fields: fieldErrors,
+			err:    err,
+		}
+	}
+
+	return err
+}
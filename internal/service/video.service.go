@@ -4,11 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
-	"github.com/gorilla/websocket"
 	"github.com/sharetube/server/internal/repository/room"
+	"github.com/sharetube/server/pkg/videoprovider"
 	"github.com/sharetube/server/pkg/ytvideodata"
 )
 
@@ -30,6 +29,7 @@ func (s service) getVideos(ctx context.Context, roomId string) ([]Video, error)
 
 		playlist = append(playlist, Video{
 			Id:           videoId,
+			Provider:     s.getVideoProvider(video.Provider),
 			Url:          video.Url,
 			Title:        video.Title,
 			AuthorName:   video.AuthorName,
@@ -97,35 +97,6 @@ func (s service) getPlayer(ctx context.Context, roomId string) (*Player, error)
 	}, nil
 }
 
-func (s service) getPlaylistWithIncrVersion(ctx context.Context, roomId string) (*Playlist, error) {
-	playlistVersion, err := s.roomRepo.IncrPlaylistVersion(ctx, roomId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to incr playlist version: %w", err)
-	}
-
-	videos, err := s.getVideos(ctx, roomId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get videos: %w", err)
-	}
-
-	lastVideo, err := s.getLastVideo(ctx, roomId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get last video: %w", err)
-	}
-
-	currentVideo, err := s.getCurrentVideo(ctx, roomId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get current video: %w", err)
-	}
-
-	return &Playlist{
-		Videos:       videos,
-		LastVideo:    lastVideo,
-		CurrentVideo: *currentVideo,
-		Version:      playlistVersion,
-	}, nil
-}
-
 func (s service) getCurrentVideo(ctx context.Context, roomId string) (*Video, error) {
 	currentVideoId, err := s.roomRepo.GetCurrentVideoId(ctx, roomId)
 	if err != nil {
@@ -145,6 +116,7 @@ func (s service) getCurrentVideo(ctx context.Context, roomId string) (*Video, er
 
 	return &Video{
 		Id:           currentVideoId,
+		Provider:     s.getVideoProvider(video.Provider),
 		Url:          video.Url,
 		Title:        video.Title,
 		AuthorName:   video.AuthorName,
@@ -175,6 +147,7 @@ func (s service) getLastVideo(ctx context.Context, roomId string) (*Video, error
 
 	return &Video{
 		Id:           *lastVideoId,
+		Provider:     s.getVideoProvider(video.Provider),
 		Url:          video.Url,
 		Title:        video.Title,
 		AuthorName:   video.AuthorName,
@@ -183,13 +156,11 @@ func (s service) getLastVideo(ctx context.Context, roomId string) (*Video, error
 }
 
 type AddVideoParams struct {
-	SenderConn      *websocket.Conn `json:"-"`
-	SenderId        string          `json:"sender_id"`
-	RoomId          string          `json:"room_id"`
-	VideoUrl        string          `json:"video_url"`
-	UpdatedAt       int             `json:"updated_at"`
-	PlaylistVersion int             `json:"playlist_version"`
-	PlayerVersion   int             `json:"player_version"`
+	SenderId        string `json:"sender_id"`
+	RoomId          string `json:"room_id"`
+	VideoUrl        string `json:"video_url"`
+	PlaylistVersion int    `json:"playlist_version"`
+	PlayerVersion   int    `json:"player_version"`
 }
 
 type PlayerVersionMismatchResponse struct {
@@ -206,13 +177,14 @@ type VideoAddedResponse struct {
 }
 
 type PlayerVideoUpdatedResponse struct {
-	Playlist Playlist
-	Player   Player
-	Members  []Member
+	Playlist       Playlist
+	Player         Player
+	Members        []Member
+	MembersVersion int
 }
 
 type AddVideoResponse struct {
-	Conns                           []*websocket.Conn
+	MemberIds                       []string
 	PlayerVideoUpdatedResponse      *PlayerVideoUpdatedResponse
 	VideoAddedResponse              *VideoAddedResponse
 	PlayerVersionMismatchResponse   *PlayerVersionMismatchResponse
@@ -224,149 +196,330 @@ func (s service) AddVideo(ctx context.Context, params *AddVideoParams) (*AddVide
 		return nil, err
 	}
 
-	if err := validation.ValidateStructWithContext(ctx, params,
+	if err := validateStruct(ctx, params,
 		validation.Field(&params.VideoUrl, VideoUrlRule...),
 	); err != nil {
 		return nil, err
 	}
 
-	playerVersion, err := s.roomRepo.GetPlayerVersion(ctx, params.RoomId)
+	video, err := s.resolveVideo(ctx, "video_url", params.VideoUrl)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get player version: %w", err)
+		return nil, err
 	}
 
-	if params.PlayerVersion != playerVersion {
-		player, err := s.getPlayer(ctx, params.RoomId)
+	return s.addVideo(ctx, params.SenderId, &room.AddVideoParams{
+		RoomId:          params.RoomId,
+		PlayerVersion:   params.PlayerVersion,
+		PlaylistVersion: params.PlaylistVersion,
+		PlaylistLimit:   s.playlistLimit,
+		Provider:        video.Provider,
+		Url:             video.Id,
+		Title:           video.Title,
+		AuthorName:      video.AuthorName,
+		ThumbnailUrl:    video.ThumbnailUrl,
+		AddedBy:         params.SenderId,
+		StartTime:       s.getVideoStartTime(video),
+		UpdatedAt:       s.getServerTime(),
+		IsPlaying:       s.getDefaultPlayerIsPlaying(),
+		CurrentTime:     s.getDefaultPlayerCurrentTime(),
+		PlaybackRate:    s.getDefaultPlayerPlaybackRate(),
+		HistoryLimit:    s.historyLimit,
+	})
+}
+
+// addVideo adds video on behalf of sender and builds response for it.
+func (s service) addVideo(ctx context.Context, senderId string, params *room.AddVideoParams) (*AddVideoResponse, error) {
+	addVideoRes, err := s.roomRepo.AddVideo(ctx, params)
+	if err != nil {
+		switch {
+		case errors.Is(err, room.ErrPlayerVersionMismatch):
+			player, err := s.getPlayer(ctx, params.RoomId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get player: %w", err)
+			}
+
+			return &AddVideoResponse{
+				MemberIds: []string{senderId},
+				PlayerVersionMismatchResponse: &PlayerVersionMismatchResponse{
+					Player: *player,
+				},
+				PlayerVideoUpdatedResponse:      nil,
+				VideoAddedResponse:              nil,
+				PlaylistVersionMismatchResponse: nil,
+			}, nil
+		case errors.Is(err, room.ErrPlaylistVersionMismatch):
+			playlist, err := s.getPlaylist(ctx, params.RoomId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get playlist: %w", err)
+			}
+
+			return &AddVideoResponse{
+				MemberIds: []string{senderId},
+				PlaylistVersionMismatchResponse: &PlaylistVersionMismatchResponse{
+					Playlist: *playlist,
+				},
+				PlayerVideoUpdatedResponse:    nil,
+				VideoAddedResponse:            nil,
+				PlayerVersionMismatchResponse: nil,
+			}, nil
+		}
+
+		return nil, fmt.Errorf("failed to add video: %w", s.mapRoomError(err))
+	}
+
+	if addVideoRes.IsCurrent {
+		updatePlayerVideoRes, err := s.getUpdatePlayerVideoResponse(ctx, params.RoomId)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get player: %w", err)
+			return nil, fmt.Errorf("failed to get update player video response: %w", err)
 		}
 
 		return &AddVideoResponse{
-			Conns: []*websocket.Conn{params.SenderConn},
-			PlayerVersionMismatchResponse: &PlayerVersionMismatchResponse{
-				Player: *player,
+			MemberIds: updatePlayerVideoRes.MemberIds,
+			PlayerVideoUpdatedResponse: &PlayerVideoUpdatedResponse{
+				Playlist:       updatePlayerVideoRes.Playlist,
+				Player:         updatePlayerVideoRes.Player,
+				Members:        updatePlayerVideoRes.Members,
+				MembersVersion: updatePlayerVideoRes.MembersVersion,
 			},
-			PlayerVideoUpdatedResponse:      nil,
 			VideoAddedResponse:              nil,
+			PlayerVersionMismatchResponse:   nil,
 			PlaylistVersionMismatchResponse: nil,
 		}, nil
 	}
 
-	playlistVersion, err := s.roomRepo.GetPlaylistVersion(ctx, params.RoomId)
+	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get playlist version: %w", err)
+		return nil, fmt.Errorf("failed to get member ids: %w", err)
 	}
 
-	if params.PlaylistVersion != playlistVersion {
-		playlist, err := s.getPlaylist(ctx, params.RoomId)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get playlist: %w", err)
-		}
+	playlist, err := s.getPlaylist(ctx, params.RoomId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get playlist: %w", err)
+	}
 
-		return &AddVideoResponse{
-			Conns: []*websocket.Conn{params.SenderConn},
-			PlaylistVersionMismatchResponse: &PlaylistVersionMismatchResponse{
-				Playlist: *playlist,
+	return &AddVideoResponse{
+		MemberIds: memberIds,
+		VideoAddedResponse: &VideoAddedResponse{
+			Playlist: *playlist,
+			AddedVideo: Video{
+				Id:           addVideoRes.VideoId,
+				Provider:     s.getVideoProvider(params.Provider),
+				Url:          params.Url,
+				Title:        params.Title,
+				ThumbnailUrl: params.ThumbnailUrl,
+				AuthorName:   params.AuthorName,
 			},
-			PlayerVideoUpdatedResponse:    nil,
-			VideoAddedResponse:            nil,
-			PlayerVersionMismatchResponse: nil,
-		}, nil
+		},
+		PlayerVideoUpdatedResponse:      nil,
+		PlayerVersionMismatchResponse:   nil,
+		PlaylistVersionMismatchResponse: nil,
+	}, nil
+}
+
+type ResolvePlaylistParams struct {
+	SenderId   string `json:"sender_id"`
+	RoomId     string `json:"room_id"`
+	PlaylistId string `json:"playlist_id"`
+}
+
+type ResolvedPlaylist struct {
+	Videos []ytvideodata.PlaylistVideo
+	// set if playlist may have videos which were not resolved, see
+	// ytvideodata.Playlist
+	Truncated bool
+}
+
+var errPlaylistHasNoEmbeddableVideos = errors.New("playlist has no embeddable videos")
+
+// ResolvePlaylist resolves embeddable videos of YouTube playlist which fit in
+// room playlist. It requests every video, so unlike ImportPlaylist it must
+// not be serialized with other room commands.
+func (s service) ResolvePlaylist(ctx context.Context, params *ResolvePlaylistParams) (*ResolvedPlaylist, error) {
+	if err := s.checkIfMemberAdmin(ctx, params.RoomId, params.SenderId); err != nil {
+		return nil, err
 	}
 
-	videoData, err := ytvideodata.Get(params.VideoUrl)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get video data: %w", err)
+	if err := validateStruct(ctx, params,
+		validation.Field(&params.PlaylistId, PlaylistIdRule...),
+	); err != nil {
+		return nil, err
 	}
 
-	videosLength, err := s.roomRepo.GetVideosLength(ctx, params.RoomId)
+	videoIds, err := s.roomRepo.GetVideoIds(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get videos length: %w", err)
+		return nil, fmt.Errorf("failed to get video ids: %w", err)
 	}
 
-	if videosLength >= s.playlistLimit {
+	// checked before fetching to not resolve playlist which can not be added,
+	// repo checks it again atomically
+	available := s.playlistLimit - len(videoIds)
+	if available <= 0 {
 		return nil, ErrPlaylistLimitReached
 	}
 
-	videoEnded, err := s.roomRepo.GetVideoEnded(ctx, params.RoomId)
+	playlist, err := s.playlistFetcher.GetPlaylist(ctx, params.PlaylistId, available)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get video ended: %w", err)
+		if errors.Is(err, ytvideodata.ErrPlaylistNotFound) {
+			return nil, newFieldValidationError("playlist_id", ytvideodata.ErrPlaylistNotFound)
+		}
+
+		return nil, fmt.Errorf("failed to get playlist videos: %w", err)
+	}
+
+	if len(playlist.Videos) == 0 {
+		return nil, newFieldValidationError("playlist_id", errPlaylistHasNoEmbeddableVideos)
+	}
+
+	return &ResolvedPlaylist{
+		Videos:    playlist.Videos,
+		Truncated: playlist.Truncated,
+	}, nil
+}
+
+type ImportPlaylistParams struct {
+	SenderId        string            `json:"sender_id"`
+	RoomId          string            `json:"room_id"`
+	PlaylistVersion int               `json:"playlist_version"`
+	Playlist        *ResolvedPlaylist `json:"-"`
+}
+
+type PlaylistImportedResponse struct {
+	AddedVideos []Video
+	Playlist    Playlist
+}
+
+type ImportPlaylistResponse struct {
+	MemberIds []string
+	// number of added videos including one which became current
+	AddedCount int
+	// set if not all videos of playlist were added
+	Truncated                       bool
+	PlaylistImportedResponse        *PlaylistImportedResponse
+	PlayerVideoUpdatedResponse      *PlayerVideoUpdatedResponse
+	PlaylistVersionMismatchResponse *PlaylistVersionMismatchResponse
+}
+
+// ImportPlaylist appends videos of playlist resolved with ResolvePlaylist to
+// the end of room playlist, videos exceeding playlist limit are skipped. The
+// first video becomes current if playlist is empty and current video ended.
+func (s service) ImportPlaylist(ctx context.Context, params *ImportPlaylistParams) (*ImportPlaylistResponse, error) {
+	// admin could be demoted while playlist was resolved
+	if err := s.checkIfMemberAdmin(ctx, params.RoomId, params.SenderId); err != nil {
+		return nil, err
 	}
 
-	videoId, err := s.roomRepo.SetVideo(ctx, &room.SetVideoParams{
-		RoomId:       params.RoomId,
-		Url:          params.VideoUrl,
-		Title:        videoData.Title,
-		ThumbnailUrl: videoData.ThumbnailUrl,
-		AuthorName:   videoData.AuthorName,
+	videos := make([]room.AddedVideo, 0, len(params.Playlist.Videos))
+	for _, playlistVideo := range params.Playlist.Videos {
+		videos = append(videos, room.AddedVideo{
+			Provider:     videoprovider.YouTube,
+			Url:          playlistVideo.VideoId,
+			Title:        playlistVideo.Title,
+			AuthorName:   playlistVideo.AuthorName,
+			ThumbnailUrl: playlistVideo.ThumbnailUrl,
+		})
+	}
+
+	addVideosRes, err := s.roomRepo.AddVideos(ctx, &room.AddVideosParams{
+		RoomId:          params.RoomId,
+		PlaylistVersion: params.PlaylistVersion,
+		PlaylistLimit:   s.playlistLimit,
+		AddedBy:         params.SenderId,
+		Videos:          videos,
+		UpdatedAt:       s.getServerTime(),
+		IsPlaying:       s.getDefaultPlayerIsPlaying(),
+		CurrentTime:     s.getDefaultPlayerCurrentTime(),
+		PlaybackRate:    s.getDefaultPlayerPlaybackRate(),
+		HistoryLimit:    s.historyLimit,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to set video: %w", err)
+		if errors.Is(err, room.ErrPlaylistVersionMismatch) {
+			playlist, err := s.getPlaylist(ctx, params.RoomId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get playlist: %w", err)
+			}
+
+			return &ImportPlaylistResponse{
+				MemberIds:  []string{params.SenderId},
+				AddedCount: 0,
+				Truncated:  false,
+				PlaylistVersionMismatchResponse: &PlaylistVersionMismatchResponse{
+					Playlist: *playlist,
+				},
+				PlaylistImportedResponse:   nil,
+				PlayerVideoUpdatedResponse: nil,
+			}, nil
+		}
+
+		return nil, fmt.Errorf("failed to add videos: %w", s.mapRoomError(err))
 	}
 
-	if videosLength == 0 && videoEnded {
-		updatePlayerVideoRes, err := s.updatePlayerVideo(ctx, params.RoomId, videoId, params.UpdatedAt)
+	addedCount := len(addVideosRes.VideoIds)
+	truncated := params.Playlist.Truncated || addedCount < len(videos)
+
+	if addVideosRes.IsCurrent {
+		updatePlayerVideoRes, err := s.getUpdatePlayerVideoResponse(ctx, params.RoomId)
 		if err != nil {
-			return nil, fmt.Errorf("failed to update player video: %w", err)
+			return nil, fmt.Errorf("failed to get update player video response: %w", err)
 		}
 
-		return &AddVideoResponse{
-			Conns: updatePlayerVideoRes.Conns,
+		return &ImportPlaylistResponse{
+			MemberIds:  updatePlayerVideoRes.MemberIds,
+			AddedCount: addedCount,
+			Truncated:  truncated,
 			PlayerVideoUpdatedResponse: &PlayerVideoUpdatedResponse{
-				Playlist: updatePlayerVideoRes.Playlist,
-				Player:   updatePlayerVideoRes.Player,
-				Members:  updatePlayerVideoRes.Members,
+				Playlist:       updatePlayerVideoRes.Playlist,
+				Player:         updatePlayerVideoRes.Player,
+				Members:        updatePlayerVideoRes.Members,
+				MembersVersion: updatePlayerVideoRes.MembersVersion,
 			},
-			VideoAddedResponse:              nil,
-			PlayerVersionMismatchResponse:   nil,
+			PlaylistImportedResponse:        nil,
 			PlaylistVersionMismatchResponse: nil,
 		}, nil
 	}
 
-	if err := s.roomRepo.AddVideoToList(ctx, &room.AddVideoToListParams{
-		RoomId:  params.RoomId,
-		VideoId: videoId,
-	}); err != nil {
-		return nil, fmt.Errorf("failed to add video to list: %w", err)
+	addedVideos := make([]Video, 0, addedCount)
+	for i, videoId := range addVideosRes.VideoIds {
+		addedVideos = append(addedVideos, Video{
+			Id:           videoId,
+			Provider:     videos[i].Provider,
+			Url:          videos[i].Url,
+			Title:        videos[i].Title,
+			AuthorName:   videos[i].AuthorName,
+			ThumbnailUrl: videos[i].ThumbnailUrl,
+		})
 	}
 
-	conns, err := s.getConns(ctx, params.RoomId)
+	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get conns: %w", err)
+		return nil, fmt.Errorf("failed to get member ids: %w", err)
 	}
 
-	playlist, err := s.getPlaylistWithIncrVersion(ctx, params.RoomId)
+	playlist, err := s.getPlaylist(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get playlist with incr version: %w", err)
+		return nil, fmt.Errorf("failed to get playlist: %w", err)
 	}
 
-	return &AddVideoResponse{
-		Conns: conns,
-		VideoAddedResponse: &VideoAddedResponse{
-			Playlist: *playlist,
-			AddedVideo: Video{
-				Id:           videoId,
-				Url:          params.VideoUrl,
-				Title:        videoData.Title,
-				ThumbnailUrl: videoData.ThumbnailUrl,
-				AuthorName:   videoData.AuthorName,
-			},
+	return &ImportPlaylistResponse{
+		MemberIds:  memberIds,
+		AddedCount: addedCount,
+		Truncated:  truncated,
+		PlaylistImportedResponse: &PlaylistImportedResponse{
+			AddedVideos: addedVideos,
+			Playlist:    *playlist,
 		},
 		PlayerVideoUpdatedResponse:      nil,
-		PlayerVersionMismatchResponse:   nil,
 		PlaylistVersionMismatchResponse: nil,
 	}, nil
 }
 
 type EndVideoParams struct {
-	SenderConn    *websocket.Conn `json:"-"`
-	SenderId      string          `json:"sender_id"`
-	RoomId        string          `json:"room_id"`
-	PlayerVersion int             `json:"player_version"`
+	SenderId      string `json:"sender_id"`
+	RoomId        string `json:"room_id"`
+	PlayerVersion int    `json:"player_version"`
 }
 
 type EndVideoResponse struct {
-	Conns                         []*websocket.Conn
+	MemberIds                     []string
 	PlayerVersionMismatchResponse *PlayerVersionMismatchResponse
 	PlayerVideoUpdatedResponse    *PlayerVideoUpdatedResponse
 	PlayerStateUpdatedResponse    *PlayerStateUpdatedResponse
@@ -377,69 +530,57 @@ func (s service) EndVideo(ctx context.Context, params *EndVideoParams) (*EndVide
 		return nil, err
 	}
 
-	playerVersion, err := s.roomRepo.GetPlayerVersion(ctx, params.RoomId)
+	endVideoRes, err := s.roomRepo.EndVideo(ctx, &room.EndVideoParams{
+		RoomId:        params.RoomId,
+		PlayerVersion: params.PlayerVersion,
+		UpdatedAt:     s.getServerTime(),
+		IsPlaying:     s.getDefaultPlayerIsPlaying(),
+		CurrentTime:   s.getDefaultPlayerCurrentTime(),
+		PlaybackRate:  s.getDefaultPlayerPlaybackRate(),
+		HistoryLimit:  s.historyLimit,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get player version: %w", err)
-	}
-
-	if playerVersion != params.PlayerVersion {
-		player, err := s.getPlayer(ctx, params.RoomId)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get player: %w", err)
+		if errors.Is(err, room.ErrPlayerVersionMismatch) {
+			player, err := s.getPlayer(ctx, params.RoomId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get player: %w", err)
+			}
+
+			return &EndVideoResponse{
+				MemberIds: []string{params.SenderId},
+				PlayerVersionMismatchResponse: &PlayerVersionMismatchResponse{
+					Player: *player,
+				},
+				PlayerVideoUpdatedResponse: nil,
+				PlayerStateUpdatedResponse: nil,
+			}, nil
 		}
 
-		return &EndVideoResponse{
-			Conns: []*websocket.Conn{params.SenderConn},
-			PlayerVersionMismatchResponse: &PlayerVersionMismatchResponse{
-				Player: *player,
-			},
-			PlayerVideoUpdatedResponse: nil,
-			PlayerStateUpdatedResponse: nil,
-		}, nil
-	}
-
-	videoEnded, err := s.roomRepo.GetVideoEnded(ctx, params.RoomId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get video ended: %w", err)
-	}
-
-	if videoEnded {
-		return nil, errors.New("ended already set")
-	}
-
-	videos, err := s.getVideos(ctx, params.RoomId)
-	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to end video: %w", s.mapRoomError(err))
 	}
 
-	if len(videos) > 0 {
-		updatePlayerVideoRes, err := s.updatePlayerVideo(ctx, params.RoomId, videos[0].Id, int(time.Now().UnixMicro()))
+	if endVideoRes.NextVideoId != nil {
+		updatePlayerVideoRes, err := s.getUpdatePlayerVideoResponse(ctx, params.RoomId)
 		if err != nil {
-			return nil, fmt.Errorf("failed to update player video: %w", err)
+			return nil, fmt.Errorf("failed to get update player video response: %w", err)
 		}
 
 		return &EndVideoResponse{
-			Conns: updatePlayerVideoRes.Conns,
+			MemberIds: updatePlayerVideoRes.MemberIds,
 			PlayerVideoUpdatedResponse: &PlayerVideoUpdatedResponse{
-				Playlist: updatePlayerVideoRes.Playlist,
-				Player:   updatePlayerVideoRes.Player,
-				Members:  updatePlayerVideoRes.Members,
+				Playlist:       updatePlayerVideoRes.Playlist,
+				Player:         updatePlayerVideoRes.Player,
+				Members:        updatePlayerVideoRes.Members,
+				MembersVersion: updatePlayerVideoRes.MembersVersion,
 			},
 			PlayerVersionMismatchResponse: nil,
 			PlayerStateUpdatedResponse:    nil,
 		}, nil
 	}
 
-	if err := s.roomRepo.SetVideoEnded(ctx, &room.SetVideoEndedParams{
-		RoomId:     params.RoomId,
-		VideoEnded: true,
-	}); err != nil {
-		return nil, fmt.Errorf("failed to set video ended: %w", err)
-	}
-
-	conns, err := s.getConns(ctx, params.RoomId)
+	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get conns: %w", err)
+		return nil, fmt.Errorf("failed to get member ids: %w", err)
 	}
 
 	player, err := s.getPlayer(ctx, params.RoomId)
@@ -451,18 +592,17 @@ func (s service) EndVideo(ctx context.Context, params *EndVideoParams) (*EndVide
 		PlayerStateUpdatedResponse: &PlayerStateUpdatedResponse{
 			Player: *player,
 		},
-		Conns:                         conns,
+		MemberIds:                     memberIds,
 		PlayerVersionMismatchResponse: nil,
 		PlayerVideoUpdatedResponse:    nil,
 	}, nil
 }
 
 type RemoveVideoParams struct {
-	SenderId        string          `json:"sender_id"`
-	SenderConn      *websocket.Conn `json:"-"`
-	VideoId         int             `json:"video_id"`
-	RoomId          string          `json:"room_id"`
-	PlaylistVersion int             `json:"playlist_version"`
+	SenderId        string `json:"sender_id"`
+	VideoId         int    `json:"video_id"`
+	RoomId          string `json:"room_id"`
+	PlaylistVersion int    `json:"playlist_version"`
 }
 
 type VideoRemovedResponse struct {
@@ -471,7 +611,7 @@ type VideoRemovedResponse struct {
 }
 
 type RemoveVideoResponse struct {
-	Conns                           []*websocket.Conn
+	MemberIds                       []string
 	VideoRemovedResponse            *VideoRemovedResponse
 	PlaylistVersionMismatchResponse *PlaylistVersionMismatchResponse
 }
@@ -481,58 +621,47 @@ func (s service) RemoveVideo(ctx context.Context, params *RemoveVideoParams) (*R
 		return nil, err
 	}
 
-	if err := validation.ValidateStructWithContext(ctx, params,
+	if err := validateStruct(ctx, params,
 		validation.Field(&params.VideoId, VideoIdRule...),
 	); err != nil {
 		return nil, err
 	}
 
-	playlistVersion, err := s.roomRepo.GetPlaylistVersion(ctx, params.RoomId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get playlist version: %w", err)
-	}
-
-	if params.PlaylistVersion != playlistVersion {
-		playlist, err := s.getPlaylist(ctx, params.RoomId)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get playlist: %w", err)
-		}
-
-		return &RemoveVideoResponse{
-			Conns: []*websocket.Conn{params.SenderConn},
-			PlaylistVersionMismatchResponse: &PlaylistVersionMismatchResponse{
-				Playlist: *playlist,
-			},
-			VideoRemovedResponse: nil,
-		}, nil
-	}
-
-	if err := s.roomRepo.RemoveVideoFromList(ctx, &room.RemoveVideoFromListParams{
-		VideoId: params.VideoId,
-		RoomId:  params.RoomId,
+	if _, err := s.roomRepo.RemovePlaylistVideo(ctx, &room.RemovePlaylistVideoParams{
+		RoomId:          params.RoomId,
+		VideoId:         params.VideoId,
+		PlaylistVersion: params.PlaylistVersion,
 	}); err != nil {
-		return nil, fmt.Errorf("failed to remove video from list: %w", err)
-	}
+		if errors.Is(err, room.ErrPlaylistVersionMismatch) {
+			playlist, err := s.getPlaylist(ctx, params.RoomId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get playlist: %w", err)
+			}
+
+			return &RemoveVideoResponse{
+				MemberIds: []string{params.SenderId},
+				PlaylistVersionMismatchResponse: &PlaylistVersionMismatchResponse{
+					Playlist: *playlist,
+				},
+				VideoRemovedResponse: nil,
+			}, nil
+		}
 
-	if err := s.roomRepo.RemoveVideo(ctx, &room.RemoveVideoParams{
-		VideoId: params.VideoId,
-		RoomId:  params.RoomId,
-	}); err != nil {
-		return nil, fmt.Errorf("failed to remove video: %w", err)
+		return nil, fmt.Errorf("failed to remove playlist video: %w", s.mapRoomError(err))
 	}
 
-	conns, err := s.getConns(ctx, params.RoomId)
+	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get conns: %w", err)
+		return nil, fmt.Errorf("failed to get member ids: %w", err)
 	}
 
-	playlist, err := s.getPlaylistWithIncrVersion(ctx, params.RoomId)
+	playlist, err := s.getPlaylist(ctx, params.RoomId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get playlist: %w", err)
 	}
 
 	return &RemoveVideoResponse{
-		Conns: conns,
+		MemberIds: memberIds,
 		VideoRemovedResponse: &VideoRemovedResponse{
 			RemovedVideoId: params.VideoId,
 			Playlist:       *playlist,
@@ -542,19 +671,21 @@ func (s service) RemoveVideo(ctx context.Context, params *RemoveVideoParams) (*R
 }
 
 type ReorderPlaylistParams struct {
-	VideoIds        []int           `json:"video_ids"`
-	SenderId        string          `json:"sender_id"`
-	SenderConn      *websocket.Conn `json:"-"`
-	RoomId          string          `json:"room_id"`
-	PlaylistVersion int             `json:"playlist_version"`
+	VideoIds        []int  `json:"video_ids"`
+	SenderId        string `json:"sender_id"`
+	RoomId          string `json:"room_id"`
+	PlaylistVersion int    `json:"playlist_version"`
 }
 
 type PlaylistReorderedResponse struct {
 	Playlist Playlist
+	// set if playlist differs from previous one by single moved video
+	MovedVideoId    *int
+	MovedVideoIndex int
 }
 
 type ReorderPlaylistResponse struct {
-	Conns                           []*websocket.Conn
+	MemberIds                       []string
 	PlaylistReorderedResponse       *PlaylistReorderedResponse
 	PlaylistVersionMismatchResponse *PlaylistVersionMismatchResponse
 }
@@ -564,53 +695,63 @@ func (s service) ReorderPlaylist(ctx context.Context, params *ReorderPlaylistPar
 		return nil, err
 	}
 
-	if err := validation.ValidateStructWithContext(ctx, params,
+	if err := validateStruct(ctx, params,
 		validation.Field(&params.VideoIds, validation.Each(VideoIdRule...)),
 	); err != nil {
 		return nil, err
 	}
 
-	playlistVersion, err := s.roomRepo.GetPlaylistVersion(ctx, params.RoomId)
+	prevVideoIds, err := s.roomRepo.GetVideoIds(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get playlist version: %w", err)
+		return nil, fmt.Errorf("failed to get video ids: %w", err)
 	}
 
-	if params.PlaylistVersion != playlistVersion {
-		playlist, err := s.getPlaylist(ctx, params.RoomId)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get playlist: %w", err)
+	if _, err := s.roomRepo.ReorderPlaylist(ctx, &room.ReorderPlaylistParams{
+		RoomId:          params.RoomId,
+		VideoIds:        params.VideoIds,
+		PlaylistVersion: params.PlaylistVersion,
+	}); err != nil {
+		if errors.Is(err, room.ErrPlaylistVersionMismatch) {
+			playlist, err := s.getPlaylist(ctx, params.RoomId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get playlist: %w", err)
+			}
+
+			return &ReorderPlaylistResponse{
+				MemberIds: []string{params.SenderId},
+				PlaylistVersionMismatchResponse: &PlaylistVersionMismatchResponse{
+					Playlist: *playlist,
+				},
+				PlaylistReorderedResponse: nil,
+			}, nil
 		}
 
-		return &ReorderPlaylistResponse{
-			Conns: []*websocket.Conn{params.SenderConn},
-			PlaylistVersionMismatchResponse: &PlaylistVersionMismatchResponse{
-				Playlist: *playlist,
-			},
-			PlaylistReorderedResponse: nil,
-		}, nil
+		return nil, fmt.Errorf("failed to reorder playlist: %w", s.mapRoomError(err))
 	}
 
-	if err := s.roomRepo.ReorderList(ctx, &room.ReorderListParams{
-		VideoIds: params.VideoIds,
-		RoomId:   params.RoomId,
-	}); err != nil {
-		return nil, fmt.Errorf("failed to reorder playlist: %w", err)
-	}
-
-	conns, err := s.getConns(ctx, params.RoomId)
+	memberIds, err := s.roomRepo.GetMemberIds(ctx, params.RoomId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get conns: %w", err)
+		return nil, fmt.Errorf("failed to get member ids: %w", err)
 	}
 
-	playlist, err := s.getPlaylistWithIncrVersion(ctx, params.RoomId)
+	playlist, err := s.getPlaylist(ctx, params.RoomId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get playlist: %w", err)
 	}
 
+	var movedVideoId *int
+	movedVideoIndex := 0
+	if videoId, index, ok := s.findMovedVideo(prevVideoIds, params.VideoIds); ok {
+		movedVideoId = &videoId
+		movedVideoIndex = index
+	}
+
 	return &ReorderPlaylistResponse{
-		Conns: conns,
+		MemberIds: memberIds,
 		PlaylistReorderedResponse: &PlaylistReorderedResponse{
-			Playlist: *playlist,
+			Playlist:        *playlist,
+			MovedVideoId:    movedVideoId,
+			MovedVideoIndex: movedVideoIndex,
 		},
 		PlaylistVersionMismatchResponse: nil,
 	}, nil
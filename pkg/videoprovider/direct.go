@@ -0,0 +1,55 @@
+package videoprovider
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var directFileExtensions = []string{".mp4", ".m4v", ".webm", ".ogv"}
+
+type direct struct{}
+
+// NewDirect creates provider of video files played directly by browser from
+// https url. File is not requested, so server never sends requests to hosts
+// given by members; unavailable file fails on client.
+func NewDirect() Provider {
+	return direct{}
+}
+
+func (direct) Resolve(_ context.Context, videoUrl string) (*Video, error) {
+	u, err := url.Parse(strings.TrimSpace(videoUrl))
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+		return nil, ErrUnsupportedUrl
+	}
+
+	ext := strings.ToLower(path.Ext(u.Path))
+	if !slices.Contains(directFileExtensions, ext) {
+		return nil, ErrUnsupportedUrl
+	}
+
+	// media fragment, e.g. #t=90
+	var startTime time.Duration
+	if value, ok := strings.CutPrefix(u.Fragment, "t="); ok {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds < 0 {
+			return nil, ErrUnsupportedUrl
+		}
+		startTime = time.Duration(seconds * float64(time.Second))
+	}
+	u.Fragment = ""
+
+	return &Video{
+		Provider:     Direct,
+		Id:           u.String(),
+		Title:        strings.TrimSuffix(path.Base(u.Path), path.Ext(u.Path)),
+		AuthorName:   u.Hostname(),
+		ThumbnailUrl: "",
+		Duration:     0,
+		StartTime:    startTime,
+	}, nil
+}
@@ -0,0 +1,62 @@
+package videoprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	YouTube = "youtube"
+	Vimeo   = "vimeo"
+	Direct  = "direct"
+)
+
+var (
+	ErrUnsupportedUrl     = fmt.Errorf("unsupported video url")
+	ErrVideoNotFound      = fmt.Errorf("video not found")
+	ErrVideoNotEmbeddable = fmt.Errorf("video is not embeddable")
+)
+
+type Video struct {
+	// name of provider which resolved video
+	Provider string
+	// canonical id of video within provider, client plays video by it
+	Id           string
+	Title        string
+	AuthorName   string
+	ThumbnailUrl string
+	// zero if unknown
+	Duration time.Duration
+	// offset given in url playback starts at
+	StartTime time.Duration
+}
+
+type Provider interface {
+	// Resolve returns ErrUnsupportedUrl if videoUrl is not url of provider
+	// video, ErrVideoNotFound or ErrVideoNotEmbeddable if video can not be
+	// played in room.
+	Resolve(ctx context.Context, videoUrl string) (*Video, error)
+}
+
+type chain []Provider
+
+// NewChain creates provider resolving url with the first of providers
+// supporting it.
+func NewChain(providers ...Provider) Provider {
+	return chain(providers)
+}
+
+func (c chain) Resolve(ctx context.Context, videoUrl string) (*Video, error) {
+	for _, provider := range c {
+		video, err := provider.Resolve(ctx, videoUrl)
+		if errors.Is(err, ErrUnsupportedUrl) {
+			continue
+		}
+
+		return video, err
+	}
+
+	return nil, ErrUnsupportedUrl
+}
@@ -0,0 +1,133 @@
+package videoprovider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const VimeoBaseUrl = "https://vimeo.com"
+
+var vimeoIdRegexp = regexp.MustCompile("^[0-9]{1,12}$")
+
+type vimeoOEmbed struct {
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	ThumbnailUrl string `json:"thumbnail_url"`
+	// in seconds
+	Duration int `json:"duration"`
+}
+
+type vimeo struct {
+	client  *http.Client
+	baseUrl string
+}
+
+// NewVimeo creates provider of Vimeo videos resolved with oEmbed, requests are
+// sent with client to baseUrl, which is VimeoBaseUrl outside of tests.
+func NewVimeo(client *http.Client, baseUrl string) Provider {
+	return vimeo{
+		client:  client,
+		baseUrl: baseUrl,
+	}
+}
+
+func (v vimeo) Resolve(ctx context.Context, videoUrl string) (*Video, error) {
+	videoId, startTime, err := parseVimeoUrl(videoUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	oEmbedUrl := fmt.Sprintf("%s/api/oembed.json?url=%s", v.baseUrl, url.QueryEscape("https://vimeo.com/"+videoId))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, oEmbedUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			return nil, ErrVideoNotFound
+		case http.StatusForbidden:
+			return nil, ErrVideoNotEmbeddable
+		default:
+			return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+	}
+
+	var oEmbed vimeoOEmbed
+	if err := json.NewDecoder(resp.Body).Decode(&oEmbed); err != nil {
+		return nil, fmt.Errorf("failed to decode oembed: %w", err)
+	}
+
+	return &Video{
+		Provider:     Vimeo,
+		Id:           videoId,
+		Title:        oEmbed.Title,
+		AuthorName:   oEmbed.AuthorName,
+		ThumbnailUrl: oEmbed.ThumbnailUrl,
+		Duration:     time.Duration(oEmbed.Duration) * time.Second,
+		StartTime:    startTime,
+	}, nil
+}
+
+// parseVimeoUrl extracts video id from vimeo.com/{id}, vimeo.com/channels/
+// {channel}/{id} or player.vimeo.com/video/{id} url, and start offset from
+// #t= fragment, e.g. #t=90 or #t=1m30s.
+func parseVimeoUrl(videoUrl string) (string, time.Duration, error) {
+	videoUrl = strings.TrimSpace(videoUrl)
+	if !strings.Contains(videoUrl, "://") {
+		videoUrl = "https://" + videoUrl
+	}
+
+	u, err := url.Parse(videoUrl)
+	if err != nil {
+		return "", 0, ErrUnsupportedUrl
+	}
+
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	var videoId string
+	switch strings.ToLower(u.Hostname()) {
+	case "vimeo.com", "www.vimeo.com":
+		switch {
+		case len(path) == 1:
+			videoId = path[0]
+		case len(path) == 3 && path[0] == "channels":
+			videoId = path[2]
+		}
+	case "player.vimeo.com":
+		if len(path) == 2 && path[0] == "video" {
+			videoId = path[1]
+		}
+	}
+
+	if !vimeoIdRegexp.MatchString(videoId) {
+		return "", 0, ErrUnsupportedUrl
+	}
+
+	var startTime time.Duration
+	if value, ok := strings.CutPrefix(u.Fragment, "t="); ok {
+		if _, err := strconv.Atoi(value); err == nil {
+			value += "s"
+		}
+
+		startTime, err = time.ParseDuration(value)
+		if err != nil || startTime < 0 {
+			return "", 0, ErrUnsupportedUrl
+		}
+	}
+
+	return videoId, startTime, nil
+}
@@ -0,0 +1,86 @@
+package videoprovider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newVimeoStubServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/oembed.json", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("url") {
+		case "https://vimeo.com/76979871":
+			fmt.Fprint(w, `{"title":"The New Vimeo Player","author_name":"Vimeo","thumbnail_url":"https://i.vimeocdn.com/video/1.jpg","duration":62}`)
+		case "https://vimeo.com/2":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestVimeoResolve(t *testing.T) {
+	server := newVimeoStubServer(t)
+	provider := NewVimeo(server.Client(), server.URL)
+
+	for _, videoUrl := range []string{
+		"https://vimeo.com/76979871",
+		"vimeo.com/channels/staffpicks/76979871",
+		"https://player.vimeo.com/video/76979871",
+	} {
+		t.Run(videoUrl, func(t *testing.T) {
+			video, err := provider.Resolve(context.Background(), videoUrl)
+			require.NoError(t, err)
+
+			assert.Equal(t, &Video{
+				Provider:     Vimeo,
+				Id:           "76979871",
+				Title:        "The New Vimeo Player",
+				AuthorName:   "Vimeo",
+				ThumbnailUrl: "https://i.vimeocdn.com/video/1.jpg",
+				Duration:     62 * time.Second,
+			}, video)
+		})
+	}
+}
+
+func TestVimeoResolveStartTime(t *testing.T) {
+	server := newVimeoStubServer(t)
+	provider := NewVimeo(server.Client(), server.URL)
+
+	video, err := provider.Resolve(context.Background(), "https://vimeo.com/76979871#t=1m30s")
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, video.StartTime)
+
+	video, err = provider.Resolve(context.Background(), "https://vimeo.com/76979871#t=15")
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Second, video.StartTime)
+}
+
+func TestVimeoResolveErrors(t *testing.T) {
+	server := newVimeoStubServer(t)
+	provider := NewVimeo(server.Client(), server.URL)
+
+	_, err := provider.Resolve(context.Background(), "https://vimeo.com/1")
+	assert.ErrorIs(t, err, ErrVideoNotFound)
+
+	_, err = provider.Resolve(context.Background(), "https://vimeo.com/2")
+	assert.ErrorIs(t, err, ErrVideoNotEmbeddable)
+
+	_, err = provider.Resolve(context.Background(), "https://www.youtube.com/watch?v=dQw4w9WgXcQ")
+	assert.ErrorIs(t, err, ErrUnsupportedUrl)
+}
@@ -0,0 +1,56 @@
+package videoprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sharetube/server/pkg/ytvideodata"
+)
+
+// VideoDataFetcher gets metadata of YouTube video by id, see
+// ytvideodata.Fetcher.
+type VideoDataFetcher interface {
+	GetVideoData(ctx context.Context, videoId string) (*ytvideodata.VideoData, error)
+}
+
+type youTube struct {
+	fetcher VideoDataFetcher
+}
+
+// NewYouTube creates provider of YouTube videos, see ytvideodata.ParseUrl for
+// supported urls.
+func NewYouTube(fetcher VideoDataFetcher) Provider {
+	return youTube{
+		fetcher: fetcher,
+	}
+}
+
+func (y youTube) Resolve(ctx context.Context, videoUrl string) (*Video, error) {
+	parsedUrl, err := ytvideodata.ParseUrl(videoUrl)
+	if err != nil {
+		return nil, ErrUnsupportedUrl
+	}
+
+	videoData, err := y.fetcher.GetVideoData(ctx, parsedUrl.VideoId)
+	if err != nil {
+		switch {
+		case errors.Is(err, ytvideodata.ErrVideoNotFound):
+			return nil, fmt.Errorf("%w: %w", ErrVideoNotFound, err)
+		case errors.Is(err, ytvideodata.ErrVideoNotEmbeddable):
+			return nil, fmt.Errorf("%w: %w", ErrVideoNotEmbeddable, err)
+		}
+
+		return nil, err
+	}
+
+	return &Video{
+		Provider:     YouTube,
+		Id:           parsedUrl.VideoId,
+		Title:        videoData.Title,
+		AuthorName:   videoData.AuthorName,
+		ThumbnailUrl: videoData.ThumbnailUrl,
+		Duration:     0,
+		StartTime:    parsedUrl.StartTime,
+	}, nil
+}
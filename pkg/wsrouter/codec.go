@@ -0,0 +1,100 @@
+package wsrouter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec converts messages between JSON, which handlers are written against,
+// and wire format of conn. Codec is selected per conn, see WithCodec.
+type Codec interface {
+	// MessageType returns websocket message type of encoded messages.
+	MessageType() int
+	// Encode converts JSON document to wire format.
+	Encode(data []byte) ([]byte, error)
+	// Decode converts message in wire format to JSON document.
+	Decode(data []byte) ([]byte, error)
+}
+
+var (
+	JSONCodec    Codec = jsonCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) Encode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (jsonCodec) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// msgpackCodec transcodes JSON documents, so JSON semantics of encoded
+// values, e.g. field names and custom marshalers, are kept.
+type msgpackCodec struct{}
+
+func (msgpackCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (msgpackCodec) Encode(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed to decode json: %w", err)
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.UseCompactInts(true)
+	if err := enc.Encode(convertJSONNumbers(v)); err != nil {
+		return nil, fmt.Errorf("failed to encode msgpack: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Decode(data []byte) ([]byte, error) {
+	var v any
+	if err := msgpack.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("failed to decode msgpack: %w", err)
+	}
+
+	return json.Marshal(v)
+}
+
+// convertJSONNumbers replaces json.Number values with integers where possible
+// and floats otherwise, msgpack encodes json.Number as string.
+func convertJSONNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, value := range v {
+			v[key] = convertJSONNumbers(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = convertJSONNumbers(value)
+		}
+	}
+
+	return v
+}
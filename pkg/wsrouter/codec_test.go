@@ -0,0 +1,129 @@
+package wsrouter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type testPayload struct {
+	Id       int      `json:"id"`
+	Title    string   `json:"title"`
+	Rate     float64  `json:"rate"`
+	IsActive bool     `json:"is_active"`
+	Avatar   *string  `json:"avatar"`
+	Tags     []string `json:"tags"`
+	Data     []byte   `json:"data"`
+}
+
+func TestCodecMessageType(t *testing.T) {
+	assert.Equal(t, websocket.TextMessage, JSONCodec.MessageType())
+	assert.Equal(t, websocket.BinaryMessage, MsgpackCodec.MessageType())
+}
+
+func TestMsgpackCodecOutputRoundTrip(t *testing.T) {
+	output := OutputMessage{
+		Type: "VIDEO_ADDED",
+		Payload: testPayload{
+			Id:       9007199254740993,
+			Title:    "title",
+			Rate:     1.25,
+			IsActive: true,
+			Avatar:   nil,
+			Tags:     []string{"a", "b"},
+			Data:     []byte{0, 1, 2, 255},
+		},
+	}
+
+	data, err := json.Marshal(output)
+	require.NoError(t, err)
+
+	encoded, err := MsgpackCodec.Encode(data)
+	require.NoError(t, err)
+
+	// field names of json tags are kept and integers are not turned into floats
+	var wire map[string]any
+	require.NoError(t, msgpack.Unmarshal(encoded, &wire))
+	assert.Equal(t, "VIDEO_ADDED", wire["type"])
+	payload := wire["payload"].(map[string]any)
+	assert.EqualValues(t, 9007199254740993, payload["id"])
+	assert.Equal(t, 1.25, payload["rate"])
+	assert.Nil(t, payload["avatar"])
+
+	decoded, err := MsgpackCodec.Decode(encoded)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(decoded))
+
+	var decodedPayload struct {
+		Payload testPayload `json:"payload"`
+	}
+	require.NoError(t, json.Unmarshal(decoded, &decodedPayload))
+	assert.Equal(t, output.Payload, decodedPayload.Payload)
+}
+
+func TestMsgpackCodecInputRoundTrip(t *testing.T) {
+	// client encodes message with msgpack natively, bytes as bin
+	encoded, err := msgpack.Marshal(map[string]any{
+		"id":   "request-1",
+		"type": "UPDATE_PLAYER_STATE",
+		"payload": map[string]any{
+			"current_time":  int64(1500000),
+			"playback_rate": 1.5,
+			"is_playing":    true,
+			"data":          []byte{0, 1, 2, 255},
+		},
+	})
+	require.NoError(t, err)
+
+	decoded, err := MsgpackCodec.Decode(encoded)
+	require.NoError(t, err)
+
+	var msg Message
+	require.NoError(t, json.Unmarshal(decoded, &msg))
+	assert.Equal(t, "request-1", msg.Id)
+	assert.Equal(t, "UPDATE_PLAYER_STATE", msg.Type)
+
+	var payload struct {
+		CurrentTime  int     `json:"current_time"`
+		PlaybackRate float64 `json:"playback_rate"`
+		IsPlaying    bool    `json:"is_playing"`
+		Data         []byte  `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(msg.Payload, &payload))
+	assert.Equal(t, 1500000, payload.CurrentTime)
+	assert.Equal(t, 1.5, payload.PlaybackRate)
+	assert.True(t, payload.IsPlaying)
+	assert.Equal(t, []byte{0, 1, 2, 255}, payload.Data)
+
+	// decoded input encodes back to the same message
+	reencoded, err := MsgpackCodec.Encode(decoded)
+	require.NoError(t, err)
+
+	redecoded, err := MsgpackCodec.Decode(reencoded)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(decoded), string(redecoded))
+}
+
+func TestJSONCodecRoundTrip(t *testing.T) {
+	data := []byte(`{"type":"ALIVE","payload":null}`)
+
+	encoded, err := JSONCodec.Encode(data)
+	require.NoError(t, err)
+	assert.Equal(t, data, encoded)
+
+	decoded, err := JSONCodec.Decode(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, data, decoded)
+}
+
+func TestMsgpackCodecInvalidData(t *testing.T) {
+	_, err := MsgpackCodec.Encode([]byte(`{"type":`))
+	assert.Error(t, err)
+
+	_, err = MsgpackCodec.Decode([]byte{0xc1})
+	assert.Error(t, err)
+}
@@ -1,13 +1,74 @@
 package wsrouter
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type ctxKey string
 
 const (
 	messageTypeKey ctxKey = "message_type"
+	messageIdKey   ctxKey = "message_id"
+	versionKey     ctxKey = "protocol_version"
+	codecKey       ctxKey = "codec"
+	receivedAtKey  ctxKey = "received_at"
 )
 
 func GetMessageTypeFromCtx(ctx context.Context) string {
 	return ctx.Value(messageTypeKey).(string)
 }
+
+// GetReceivedAtFromCtx returns time message was read from conn, it is taken
+// before handler and middlewares run, so time spent waiting for them is
+// included.
+func GetReceivedAtFromCtx(ctx context.Context) time.Time {
+	receivedAt, ok := ctx.Value(receivedAtKey).(time.Time)
+	if !ok {
+		return time.Now()
+	}
+
+	return receivedAt
+}
+
+// GetMessageIdFromCtx returns id of request set by peer or empty string.
+func GetMessageIdFromCtx(ctx context.Context) string {
+	id, ok := ctx.Value(messageIdKey).(string)
+	if !ok {
+		return ""
+	}
+
+	return id
+}
+
+// WithProtocolVersion sets protocol version negotiated with peer, it selects
+// handlers registered with HandleVersion.
+func WithProtocolVersion(ctx context.Context, version int) context.Context {
+	return context.WithValue(ctx, versionKey, version)
+}
+
+// GetProtocolVersionFromCtx returns protocol version set with WithProtocolVersion
+// or zero.
+func GetProtocolVersionFromCtx(ctx context.Context) int {
+	version, ok := ctx.Value(versionKey).(int)
+	if !ok {
+		return 0
+	}
+
+	return version
+}
+
+// WithCodec sets codec of conn served with ctx.
+func WithCodec(ctx context.Context, codec Codec) context.Context {
+	return context.WithValue(ctx, codecKey, codec)
+}
+
+// GetCodecFromCtx returns codec set with WithCodec or JSONCodec.
+func GetCodecFromCtx(ctx context.Context) Codec {
+	codec, ok := ctx.Value(codecKey).(Codec)
+	if !ok {
+		return JSONCodec
+	}
+
+	return codec
+}
@@ -3,12 +3,22 @@ package wsrouter
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+var (
+	ErrInvalidMessage  = errors.New("invalid message")
+	ErrHandlerNotFound = errors.New("handler not found")
+)
+
 type Message struct {
+	// optional request id, message with id is replied with exactly one ACK or ERROR
+	Id      string          `json:"id"`
 	Type    string          `json:"type"`
 	Payload json.RawMessage `json:"payload"`
 }
@@ -22,18 +32,27 @@ type (
 	Middleware         func(HandlerFunc[any]) HandlerFunc[any]
 	HandlerFunc[T any] func(context.Context, *websocket.Conn, T) error
 	ErrorHandlerFunc   func(context.Context, *websocket.Conn, error) error
+	AckHandlerFunc     func(context.Context, *websocket.Conn) error
 )
 
 type WSRouter struct {
-	handlers     map[string]handler
+	// handlers of message type sorted by protocol version
+	handlers     map[string][]versionedHandler
 	middlewares  []Middleware
 	errorHandler ErrorHandlerFunc
+	ackHandler   AckHandlerFunc
+	readTimeout  time.Duration
 }
 
 type handler interface {
 	handle(context.Context, *websocket.Conn, json.RawMessage) error
 }
 
+type versionedHandler struct {
+	version int
+	handler handler
+}
+
 type typedHandler[T any] struct {
 	fn HandlerFunc[T]
 }
@@ -41,7 +60,7 @@ type typedHandler[T any] struct {
 func (h typedHandler[T]) handle(ctx context.Context, conn *websocket.Conn, payload json.RawMessage) error {
 	var data T
 	if err := json.Unmarshal(payload, &data); err != nil {
-		return fmt.Errorf("failed to unmarshal payload: %w", err)
+		return fmt.Errorf("failed to unmarshal payload: %w: %w", ErrInvalidMessage, err)
 	}
 
 	return h.fn(ctx, conn, data)
@@ -49,28 +68,84 @@ func (h typedHandler[T]) handle(ctx context.Context, conn *websocket.Conn, paylo
 
 func New() *WSRouter {
 	return &WSRouter{
-		handlers:    make(map[string]handler),
+		handlers:    make(map[string][]versionedHandler),
 		middlewares: make([]Middleware, 0),
+		// error details are not sent to peer, set custom handler to report them
 		errorHandler: func(ctx context.Context, conn *websocket.Conn, err error) error {
-			return conn.WriteJSON(&OutputMessage{
-				Type:    "ERROR",
-				Payload: err.Error(),
+			return WriteMessage(ctx, conn, &OutputMessage{
+				Type: "ERROR",
+				Payload: map[string]any{
+					"message": "failed to handle message",
+				},
 			})
 		},
+		ackHandler: func(ctx context.Context, conn *websocket.Conn) error {
+			return WriteMessage(ctx, conn, &OutputMessage{
+				Type: "ACK",
+				Payload: map[string]any{
+					"request_id": GetMessageIdFromCtx(ctx),
+				},
+			})
+		},
+		readTimeout: 0,
+	}
+}
+
+// WriteMessage writes msg encoded with codec of ctx. It must not be called
+// concurrently with other writes to conn.
+func WriteMessage(ctx context.Context, conn *websocket.Conn, msg *OutputMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	codec := GetCodecFromCtx(ctx)
+	data, err = codec.Encode(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
 	}
+
+	return conn.WriteMessage(codec.MessageType(), data)
 }
 
 func (r *WSRouter) SetErrorHandler(f ErrorHandlerFunc) {
 	r.errorHandler = f
 }
 
+// SetAckHandler sets handler replying to successfully handled messages with id.
+func (r *WSRouter) SetAckHandler(f AckHandlerFunc) {
+	r.ackHandler = f
+}
+
+// SetReadTimeout sets how long conn may stay silent. Every received message or
+// pong extends the deadline, so peers answering pings are kept alive.
+// Zero disables the deadline.
+func (r *WSRouter) SetReadTimeout(d time.Duration) {
+	r.readTimeout = d
+}
+
+func (r *WSRouter) extendReadDeadline(conn *websocket.Conn) error {
+	if r.readTimeout == 0 {
+		return nil
+	}
+
+	return conn.SetReadDeadline(time.Now().Add(r.readTimeout))
+}
+
 // Use adds middleware to the router
 func (r *WSRouter) Use(middlewares ...Middleware) {
 	r.middlewares = append(r.middlewares, middlewares...)
 }
 
-// Handle registers a handler with middleware support
+// Handle registers a handler with middleware support for all protocol versions
 func Handle[T any](r *WSRouter, msgType string, handler HandlerFunc[T]) {
+	HandleVersion(r, 0, msgType, handler)
+}
+
+// HandleVersion registers a handler for conns using protocol version greater
+// or equal to version. Handler registered for later version of the same
+// message type takes over starting from that version.
+func HandleVersion[T any](r *WSRouter, version int, msgType string, handler HandlerFunc[T]) {
 	// Convert the typed handler to a generic one to apply middleware
 	genericHandler := func(ctx context.Context, conn *websocket.Conn, data any) error {
 		return handler(ctx, conn, data.(T))
@@ -86,38 +161,104 @@ func Handle[T any](r *WSRouter, msgType string, handler HandlerFunc[T]) {
 		return genericHandler(ctx, conn, data)
 	}
 
-	r.handlers[msgType] = typedHandler[T]{finalHandler}
+	handlers := slices.DeleteFunc(r.handlers[msgType], func(h versionedHandler) bool {
+		return h.version == version
+	})
+	handlers = append(handlers, versionedHandler{
+		version: version,
+		handler: typedHandler[T]{finalHandler},
+	})
+	slices.SortFunc(handlers, func(a, b versionedHandler) int {
+		return a.version - b.version
+	})
+	r.handlers[msgType] = handlers
+}
+
+// getHandler returns handler registered for the latest version not greater
+// than conn protocol version.
+func (r *WSRouter) getHandler(msgType string, version int) (handler, bool) {
+	handlers := r.handlers[msgType]
+	for i := len(handlers) - 1; i >= 0; i-- {
+		if handlers[i].version <= version {
+			return handlers[i].handler, true
+		}
+	}
+
+	return nil, false
+}
+
+func decodeMessage(codec Codec, data []byte) (*Message, error) {
+	data, err := codec.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
 }
 
 func (r *WSRouter) ServeConn(ctx context.Context, conn *websocket.Conn) error {
-	for {
-		var msg Message
-		if err := conn.ReadJSON(&msg); err != nil {
-			if _, ok := err.(*websocket.CloseError); ok {
-				return err
-			}
+	if err := r.extendReadDeadline(conn); err != nil {
+		return err
+	}
 
-			if err := r.errorHandler(ctx, conn, err); err != nil {
-				return err
-			}
-			continue
+	conn.SetPongHandler(func(string) error {
+		return r.extendReadDeadline(conn)
+	})
+
+	codec := GetCodecFromCtx(ctx)
+
+	for {
+		// read errors are permanent, including missed deadline
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
 		}
+		receivedAt := time.Now()
 
-		ctx = context.WithValue(ctx, messageTypeKey, msg.Type)
-		handler, exists := r.handlers[msg.Type]
+		if err := r.extendReadDeadline(conn); err != nil {
+			return err
+		}
 
-		if !exists {
-			if err := r.errorHandler(ctx, conn, fmt.Errorf("handler for type %s not found", msg.Type)); err != nil {
+		msg, err := decodeMessage(codec, data)
+		if err != nil {
+			if err := r.errorHandler(ctx, conn, fmt.Errorf("%w: %w", ErrInvalidMessage, err)); err != nil {
 				return err
 			}
 			continue
 		}
 
-		// todo: run handler in goroutine
-		if err := handler.handle(ctx, conn, msg.Payload); err != nil {
-			if err := r.errorHandler(ctx, conn, err); err != nil {
-				return err
-			}
+		if err := r.serveMessage(context.WithValue(ctx, receivedAtKey, receivedAt), conn, msg); err != nil {
+			return err
 		}
 	}
 }
+
+// serveMessage handles msg and replies with ERROR if it failed or with ACK if
+// it succeeded and has id.
+func (r *WSRouter) serveMessage(ctx context.Context, conn *websocket.Conn, msg *Message) error {
+	ctx = context.WithValue(ctx, messageTypeKey, msg.Type)
+	if msg.Id != "" {
+		ctx = context.WithValue(ctx, messageIdKey, msg.Id)
+	}
+
+	handler, exists := r.getHandler(msg.Type, GetProtocolVersionFromCtx(ctx))
+	if !exists {
+		return r.errorHandler(ctx, conn, fmt.Errorf("%w: %s", ErrHandlerNotFound, msg.Type))
+	}
+
+	// todo: run handler in goroutine
+	if err := handler.handle(ctx, conn, msg.Payload); err != nil {
+		return r.errorHandler(ctx, conn, err)
+	}
+
+	if msg.Id != "" {
+		return r.ackHandler(ctx, conn)
+	}
+
+	return nil
+}
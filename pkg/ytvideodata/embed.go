@@ -1,6 +1,7 @@
 package ytvideodata
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -11,9 +12,14 @@ var (
 	ErrVideoNotEmbeddable = fmt.Errorf("video is not embeddable")
 )
 
-func getVideoWithEmbed(videoId string) (*VideoData, error) {
-	url := fmt.Sprintf("https://www.youtube.com/oembed?url=https://www.youtube.com/watch?v=%s", videoId)
-	resp, err := http.Get(url)
+func getVideoWithEmbed(ctx context.Context, client *http.Client, baseUrl, videoId string) (*VideoData, error) {
+	url := fmt.Sprintf("%s/oembed?url=https://www.youtube.com/watch?v=%s", baseUrl, videoId)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -31,7 +37,9 @@ func getVideoWithEmbed(videoId string) (*VideoData, error) {
 	}
 
 	var result VideoData
-	json.NewDecoder(resp.Body).Decode(&result)
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
 
-	return &result, err
+	return &result, nil
 }
@@ -1,10 +1,14 @@
 package ytvideodata
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 )
 
+const YouTubeBaseUrl = "https://www.youtube.com"
+
 type VideoData struct {
 	Title        string `json:"title"`
 	AuthorName   string `json:"author_name"`
@@ -12,17 +16,42 @@ type VideoData struct {
 }
 
 func Get(videoUrl string) (*VideoData, error) {
-	videoData, err := getVideoWithEmbed(videoUrl)
+	return get(context.Background(), http.DefaultClient, YouTubeBaseUrl, videoUrl)
+}
+
+func get(ctx context.Context, client *http.Client, baseUrl, videoUrl string) (*VideoData, error) {
+	videoData, err := getVideoWithEmbed(ctx, client, baseUrl, videoUrl)
 	if err != nil {
+		if errors.Is(err, ErrVideoNotFound) {
+			return nil, err
+		}
+
 		if !errors.Is(err, ErrVideoNotEmbeddable) {
 			return nil, fmt.Errorf("failed to get video data with embed: %w", err)
 		}
 
-		videoData, err = getFromPage(videoUrl)
+		// oEmbed is unauthorized for restricted videos too, player response
+		// of video page tells if video can be played in embedded player
+		videoPage, err := getFromPage(ctx, client, baseUrl, videoUrl)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get video data from page: %w", err)
 		}
+
+		if !videoPage.playableInEmbed {
+			return nil, ErrVideoNotEmbeddable
+		}
+
+		return &videoPage.VideoData, nil
 	}
 
 	return videoData, nil
 }
+
+// Fetcher gets video data with Get, it is a seam for decorators, e.g. cache.
+type Fetcher struct{}
+
+// GetVideoData returns ErrVideoNotFound and ErrVideoNotEmbeddable unwrapped
+// only if upstream response confirmed them, other errors are wrapped.
+func (Fetcher) GetVideoData(ctx context.Context, videoId string) (*VideoData, error) {
+	return get(ctx, http.DefaultClient, YouTubeBaseUrl, videoId)
+}
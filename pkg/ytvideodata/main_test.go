@@ -0,0 +1,102 @@
+package ytvideodata
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPage = `<html><head><title>%[1]s - YouTube</title></head><body>
+<span itemprop="author"><link itemprop="name" content="author %[1]s"></span>
+<script>var ytInitialPlayerResponse = {"playabilityStatus":{"status":"%[2]s","playableInEmbed":%[3]t}};</script>
+</body></html>`
+
+func newVideoStubServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, r *http.Request) {
+		videoId := strings.TrimPrefix(r.URL.Query().Get("url"), "https://www.youtube.com/watch?v=")
+		switch videoId {
+		case "embeddable0":
+			fmt.Fprintf(w, `{"title":"title %[1]s","author_name":"author %[1]s","thumbnail_url":"thumbnail %[1]s"}`, videoId)
+		case "missing0000":
+			w.WriteHeader(http.StatusBadRequest)
+		case "failing0000":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	mux.HandleFunc("/watch", func(w http.ResponseWriter, r *http.Request) {
+		videoId := r.URL.Query().Get("v")
+		switch videoId {
+		case "restricted0":
+			fmt.Fprintf(w, testPage, videoId, "OK", true)
+		case "noembed0000":
+			fmt.Fprintf(w, testPage, videoId, "OK", false)
+		case "private0000":
+			fmt.Fprintf(w, testPage, videoId, "LOGIN_REQUIRED", false)
+		case "nostatus000":
+			fmt.Fprint(w, `<html><head><title>page</title></head></html>`)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestGet(t *testing.T) {
+	server := newVideoStubServer(t)
+
+	videoData, err := get(context.Background(), server.Client(), server.URL, "embeddable0")
+	require.NoError(t, err)
+	assert.Equal(t, &VideoData{
+		Title:        "title embeddable0",
+		AuthorName:   "author embeddable0",
+		ThumbnailUrl: "thumbnail embeddable0",
+	}, videoData)
+
+	// oEmbed is unauthorized, but page states video is playable in embed
+	videoData, err = get(context.Background(), server.Client(), server.URL, "restricted0")
+	require.NoError(t, err)
+	assert.Equal(t, "restricted0 - YouTube", videoData.Title)
+	assert.Equal(t, "author restricted0", videoData.AuthorName)
+}
+
+func TestGetErrors(t *testing.T) {
+	server := newVideoStubServer(t)
+
+	for videoId, expected := range map[string]error{
+		"missing0000": ErrVideoNotFound,
+		"noembed0000": ErrVideoNotEmbeddable,
+		"private0000": ErrVideoNotEmbeddable,
+		"nostatus000": ErrVideoNotEmbeddable,
+	} {
+		t.Run(videoId, func(t *testing.T) {
+			// confirmed results are not wrapped, so they can be cached
+			_, err := get(context.Background(), server.Client(), server.URL, videoId)
+			assert.Equal(t, expected, err)
+		})
+	}
+
+	// failures of oEmbed and page are not reported as not embeddable video
+	for _, videoId := range []string{"failing0000", "pagefailing"} {
+		t.Run(videoId, func(t *testing.T) {
+			_, err := get(context.Background(), server.Client(), server.URL, videoId)
+			require.Error(t, err)
+			assert.NotErrorIs(t, err, ErrVideoNotEmbeddable)
+			assert.NotErrorIs(t, err, ErrVideoNotFound)
+		})
+	}
+}
@@ -1,30 +1,71 @@
 package ytvideodata
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"regexp"
 
 	"golang.org/x/net/html"
 )
 
-func getFromPage(videoId string) (*VideoData, error) {
-	resp, err := http.Get("https://youtu.be/" + videoId)
+var (
+	playabilityStatusRe = regexp.MustCompile(`"playabilityStatus":\{"status":"([A-Z_]+)"`)
+	playableInEmbedRe   = regexp.MustCompile(`"playableInEmbed":(true|false)`)
+)
+
+type page struct {
+	VideoData
+	// false if player response of page does not state video is playable
+	// in embedded player
+	playableInEmbed bool
+}
+
+func getFromPage(ctx context.Context, client *http.Client, baseUrl, videoId string) (*page, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/watch?v=%s", baseUrl, videoId), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
 	// todo: do not use html.Parse, parse html manually
-	doc, err := html.Parse(resp.Body)
+	doc, err := html.Parse(bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 
-	var videoData VideoData
-	videoData.Title = getTitle(doc)
-	videoData.ThumbnailUrl = fmt.Sprintf("https://i.ytimg.com/vi/%s/hqdefault.jpg", videoId)
-	videoData.AuthorName = getLinkContent(doc)
-	return &videoData, nil
+	var videoPage page
+	videoPage.Title = getTitle(doc)
+	videoPage.ThumbnailUrl = fmt.Sprintf("https://i.ytimg.com/vi/%s/hqdefault.jpg", videoId)
+	videoPage.AuthorName = getLinkContent(doc)
+	videoPage.playableInEmbed = isPlayableInEmbed(body)
+	return &videoPage, nil
+}
+
+func isPlayableInEmbed(body []byte) bool {
+	status := playabilityStatusRe.FindSubmatch(body)
+	if status == nil || string(status[1]) != "OK" {
+		return false
+	}
+
+	playableInEmbed := playableInEmbedRe.FindSubmatch(body)
+	return playableInEmbed != nil && string(playableInEmbed[1]) == "true"
 }
 
 func getTitle(n *html.Node) string {
@@ -0,0 +1,135 @@
+package ytvideodata
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+var ErrPlaylistNotFound = fmt.Errorf("playlist not found")
+
+// PlaylistFeedLimit is max number of entries in playlist feed, longer
+// playlists are cut by YouTube and feed does not support paging, so feed with
+// this many entries can not be told from the cut one.
+const PlaylistFeedLimit = 15
+
+type PlaylistVideo struct {
+	VideoId string
+	VideoData
+}
+
+type Playlist struct {
+	Videos []PlaylistVideo
+	// set if playlist may have videos which were not returned, because of
+	// requested limit or because feed is full, see PlaylistFeedLimit
+	Truncated bool
+}
+
+type playlistFeed struct {
+	Entries []struct {
+		VideoId string `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+	} `xml:"entry"`
+}
+
+// VideoDataFetcher gets video data by id, see Fetcher.
+type VideoDataFetcher interface {
+	GetVideoData(ctx context.Context, videoId string) (*VideoData, error)
+}
+
+// PlaylistFetcher resolves playlist entries using playlist feed, which does
+// not require API key, and videoDataFetcher for every entry, so entries are
+// resolved like videos added by url.
+type PlaylistFetcher struct {
+	client           *http.Client
+	baseUrl          string
+	videoDataFetcher VideoDataFetcher
+}
+
+// NewPlaylistFetcher creates fetcher requesting feed with client from
+// baseUrl, which is YouTubeBaseUrl outside of tests.
+func NewPlaylistFetcher(client *http.Client, baseUrl string, videoDataFetcher VideoDataFetcher) *PlaylistFetcher {
+	return &PlaylistFetcher{
+		client:           client,
+		baseUrl:          baseUrl,
+		videoDataFetcher: videoDataFetcher,
+	}
+}
+
+// GetPlaylist returns up to limit embeddable videos of playlist in playlist
+// order, unavailable and not embeddable videos are skipped. Only first
+// PlaylistFeedLimit entries of playlist are available.
+func (f PlaylistFetcher) GetPlaylist(ctx context.Context, playlistId string, limit int) (*Playlist, error) {
+	videoIds, err := f.getPlaylistVideoIds(ctx, playlistId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get playlist video ids: %w", err)
+	}
+
+	playlist := &Playlist{
+		Videos:    make([]PlaylistVideo, 0, min(len(videoIds), limit)),
+		Truncated: len(videoIds) >= PlaylistFeedLimit,
+	}
+	for _, videoId := range videoIds {
+		if len(playlist.Videos) >= limit {
+			playlist.Truncated = true
+			break
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		videoData, err := f.videoDataFetcher.GetVideoData(ctx, videoId)
+		if err != nil {
+			if errors.Is(err, ErrVideoNotFound) || errors.Is(err, ErrVideoNotEmbeddable) {
+				continue
+			}
+
+			return nil, fmt.Errorf("failed to get video data: %w", err)
+		}
+
+		playlist.Videos = append(playlist.Videos, PlaylistVideo{
+			VideoId:   videoId,
+			VideoData: *videoData,
+		})
+	}
+
+	return playlist, nil
+}
+
+func (f PlaylistFetcher) getPlaylistVideoIds(ctx context.Context, playlistId string) ([]string, error) {
+	feedUrl := fmt.Sprintf("%s/feeds/videos.xml?playlist_id=%s", f.baseUrl, url.QueryEscape(playlistId))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		switch resp.StatusCode {
+		case http.StatusBadRequest, http.StatusNotFound:
+			return nil, ErrPlaylistNotFound
+		default:
+			return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+	}
+
+	var feed playlistFeed
+	if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("failed to decode playlist feed: %w", err)
+	}
+
+	videoIds := make([]string, 0, len(feed.Entries))
+	for _, entry := range feed.Entries {
+		videoIds = append(videoIds, entry.VideoId)
+	}
+
+	return videoIds, nil
+}
@@ -0,0 +1,172 @@
+package ytvideodata
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns:media="http://search.yahoo.com/mrss/" xmlns="http://www.w3.org/2005/Atom">
+	<title>Playlist</title>
+	<entry><yt:videoId>aaaaaaaaaaa</yt:videoId><title>A</title></entry>
+	<entry><yt:videoId>bbbbbbbbbbb</yt:videoId><title>B</title></entry>
+	<entry><yt:videoId>ccccccccccc</yt:videoId><title>C</title></entry>
+	<entry><yt:videoId>ddddddddddd</yt:videoId><title>D</title></entry>
+	<entry><yt:videoId>eeeeeeeeeee</yt:videoId><title>E</title></entry>
+</feed>`
+
+// testVideoDataFetcher is Fetcher requesting stub server.
+type testVideoDataFetcher struct {
+	client  *http.Client
+	baseUrl string
+}
+
+func (f testVideoDataFetcher) GetVideoData(ctx context.Context, videoId string) (*VideoData, error) {
+	return get(ctx, f.client, f.baseUrl, videoId)
+}
+
+func newTestPlaylistFetcher(server *httptest.Server) *PlaylistFetcher {
+	return NewPlaylistFetcher(server.Client(), server.URL, testVideoDataFetcher{
+		client:  server.Client(),
+		baseUrl: server.URL,
+	})
+}
+
+func newStubServer(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+
+	var oembedRequests []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feeds/videos.xml", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("playlist_id") != "PLtest" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		fmt.Fprint(w, testFeed)
+	})
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, r *http.Request) {
+		videoId := strings.TrimPrefix(r.URL.Query().Get("url"), "https://www.youtube.com/watch?v=")
+		oembedRequests = append(oembedRequests, videoId)
+
+		switch videoId {
+		case "bbbbbbbbbbb", "eeeeeeeeeee":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "ccccccccccc":
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			fmt.Fprintf(w, `{"title":"title %[1]s","author_name":"author %[1]s","thumbnail_url":"thumbnail %[1]s"}`, videoId)
+		}
+	})
+	mux.HandleFunc("/watch", func(w http.ResponseWriter, r *http.Request) {
+		videoId := r.URL.Query().Get("v")
+		// restricted video, which is playable in embed unlike bbbbbbbbbbb
+		fmt.Fprintf(w, testPage, videoId, "OK", videoId == "eeeeeeeeeee")
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server, &oembedRequests
+}
+
+func TestGetPlaylistSkipsUnavailableVideos(t *testing.T) {
+	server, _ := newStubServer(t)
+	fetcher := newTestPlaylistFetcher(server)
+
+	playlist, err := fetcher.GetPlaylist(context.Background(), "PLtest", 10)
+	require.NoError(t, err)
+
+	assert.False(t, playlist.Truncated)
+	assert.Equal(t, []PlaylistVideo{
+		{
+			VideoId: "aaaaaaaaaaa",
+			VideoData: VideoData{
+				Title:        "title aaaaaaaaaaa",
+				AuthorName:   "author aaaaaaaaaaa",
+				ThumbnailUrl: "thumbnail aaaaaaaaaaa",
+			},
+		},
+		{
+			VideoId: "ddddddddddd",
+			VideoData: VideoData{
+				Title:        "title ddddddddddd",
+				AuthorName:   "author ddddddddddd",
+				ThumbnailUrl: "thumbnail ddddddddddd",
+			},
+		},
+		{
+			VideoId: "eeeeeeeeeee",
+			VideoData: VideoData{
+				Title:        "eeeeeeeeeee - YouTube",
+				AuthorName:   "author eeeeeeeeeee",
+				ThumbnailUrl: "https://i.ytimg.com/vi/eeeeeeeeeee/hqdefault.jpg",
+			},
+		},
+	}, playlist.Videos)
+}
+
+func TestGetPlaylistStopsAtLimit(t *testing.T) {
+	server, oembedRequests := newStubServer(t)
+	fetcher := newTestPlaylistFetcher(server)
+
+	playlist, err := fetcher.GetPlaylist(context.Background(), "PLtest", 1)
+	require.NoError(t, err)
+
+	assert.True(t, playlist.Truncated)
+	require.Len(t, playlist.Videos, 1)
+	assert.Equal(t, "aaaaaaaaaaa", playlist.Videos[0].VideoId)
+	assert.Equal(t, []string{"aaaaaaaaaaa"}, *oembedRequests)
+}
+
+func TestGetPlaylistTruncatedByFeed(t *testing.T) {
+	var feed strings.Builder
+	feed.WriteString(`<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns="http://www.w3.org/2005/Atom">`)
+	for i := range PlaylistFeedLimit {
+		fmt.Fprintf(&feed, `<entry><yt:videoId>video%06d</yt:videoId></entry>`, i)
+	}
+	feed.WriteString(`</feed>`)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feeds/videos.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, feed.String())
+	})
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"title":"title"}`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	playlist, err := newTestPlaylistFetcher(server).GetPlaylist(context.Background(), "PLlong", 25)
+	require.NoError(t, err)
+
+	assert.True(t, playlist.Truncated)
+	assert.Len(t, playlist.Videos, PlaylistFeedLimit)
+}
+
+func TestGetPlaylistCanceled(t *testing.T) {
+	server, oembedRequests := newStubServer(t)
+	fetcher := newTestPlaylistFetcher(server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := fetcher.GetPlaylist(ctx, "PLtest", 10)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, *oembedRequests)
+}
+
+func TestGetPlaylistNotFound(t *testing.T) {
+	server, _ := newStubServer(t)
+	fetcher := newTestPlaylistFetcher(server)
+
+	_, err := fetcher.GetPlaylist(context.Background(), "PLmissing", 10)
+	assert.ErrorIs(t, err, ErrPlaylistNotFound)
+}
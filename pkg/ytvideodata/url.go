@@ -0,0 +1,103 @@
+package ytvideodata
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidUrl = fmt.Errorf("invalid video url")
+
+var (
+	videoIdRegexp   = regexp.MustCompile("^[a-zA-Z0-9_-]{11}$")
+	startTimeRegexp = regexp.MustCompile(`^(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s?)?$`)
+)
+
+type VideoUrl struct {
+	VideoId string
+	// offset playback starts at, zero if url has no t or start parameter
+	StartTime time.Duration
+}
+
+// ParseUrl extracts video id and start offset from bare video id or
+// watch, youtu.be, shorts, embed, live url on www., m. or music. host.
+func ParseUrl(rawUrl string) (*VideoUrl, error) {
+	rawUrl = strings.TrimSpace(rawUrl)
+	if videoIdRegexp.MatchString(rawUrl) {
+		return &VideoUrl{VideoId: rawUrl}, nil
+	}
+
+	if !strings.Contains(rawUrl, "://") {
+		rawUrl = "https://" + rawUrl
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, ErrInvalidUrl
+	}
+
+	var videoId string
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.") {
+	case "youtube.com", "m.youtube.com", "music.youtube.com", "youtube-nocookie.com":
+		switch {
+		case len(path) == 1 && path[0] == "watch":
+			videoId = u.Query().Get("v")
+		case len(path) == 2 && (path[0] == "shorts" || path[0] == "embed" || path[0] == "live" || path[0] == "v"):
+			videoId = path[1]
+		}
+	case "youtu.be":
+		if len(path) == 1 {
+			videoId = path[0]
+		}
+	}
+
+	if !videoIdRegexp.MatchString(videoId) {
+		return nil, ErrInvalidUrl
+	}
+
+	startTime, err := parseStartTime(u.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	return &VideoUrl{
+		VideoId:   videoId,
+		StartTime: startTime,
+	}, nil
+}
+
+// parseStartTime parses t or start parameter, given in seconds, e.g. 90 or
+// 90s, or as 1h2m3s.
+func parseStartTime(query url.Values) (time.Duration, error) {
+	value := query.Get("t")
+	if value == "" {
+		value = query.Get("start")
+	}
+	if value == "" {
+		return 0, nil
+	}
+
+	matches := startTimeRegexp.FindStringSubmatch(value)
+	if matches == nil {
+		return 0, ErrInvalidUrl
+	}
+
+	var startTime time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		if matches[i+1] == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(matches[i+1])
+		if err != nil {
+			return 0, ErrInvalidUrl
+		}
+		startTime += time.Duration(n) * unit
+	}
+
+	return startTime, nil
+}
@@ -0,0 +1,54 @@
+package ytvideodata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUrl(t *testing.T) {
+	tests := []struct {
+		url       string
+		startTime time.Duration
+	}{
+		{url: "dQw4w9WgXcQ"},
+		{url: "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{url: "youtube.com/watch?v=dQw4w9WgXcQ&list=PL123"},
+		{url: "https://m.youtube.com/watch?v=dQw4w9WgXcQ&t=42", startTime: 42 * time.Second},
+		{url: "https://music.youtube.com/watch?v=dQw4w9WgXcQ&t=90s", startTime: 90 * time.Second},
+		{url: "https://youtu.be/dQw4w9WgXcQ?t=1h2m3s", startTime: time.Hour + 2*time.Minute + 3*time.Second},
+		{url: "https://www.youtube.com/shorts/dQw4w9WgXcQ"},
+		{url: "https://www.youtube.com/embed/dQw4w9WgXcQ?start=15", startTime: 15 * time.Second},
+		{url: "https://www.youtube.com/live/dQw4w9WgXcQ?t=2m", startTime: 2 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			videoUrl, err := ParseUrl(tt.url)
+			require.NoError(t, err)
+
+			assert.Equal(t, &VideoUrl{
+				VideoId:   "dQw4w9WgXcQ",
+				StartTime: tt.startTime,
+			}, videoUrl)
+		})
+	}
+}
+
+func TestParseUrlInvalid(t *testing.T) {
+	for _, url := range []string{
+		"",
+		"dQw4w9WgXc",
+		"https://example.com/watch?v=dQw4w9WgXcQ",
+		"https://www.youtube.com/watch?v=short",
+		"https://www.youtube.com/playlist?list=PL123",
+		"https://youtu.be/dQw4w9WgXcQ?t=abc",
+	} {
+		t.Run(url, func(t *testing.T) {
+			_, err := ParseUrl(url)
+			assert.ErrorIs(t, err, ErrInvalidUrl)
+		})
+	}
+}